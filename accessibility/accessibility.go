@@ -0,0 +1,286 @@
+// Package accessibility checks the colors of CartoCSS styles for the
+// readability of labels and for color vision deficiencies.
+package accessibility
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	cartocss "github.com/flywave/go-cartocss"
+	"github.com/flywave/go-cartocss/color"
+)
+
+// RuleID identifies the check of a Finding.
+type RuleID string
+
+const (
+	// LowContrast is a text or shield fill with a WCAG contrast ratio to the
+	// halo or the map background below the minimum.
+	LowContrast RuleID = "low-contrast"
+	// Indistinguishable is a pair of fill or line colors of a layer that can
+	// not be distinguished with a color vision deficiency.
+	Indistinguishable RuleID = "indistinguishable-colors"
+)
+
+// Finding is a single problem in a style.
+type Finding struct {
+	Rule     RuleID
+	Severity cartocss.Severity
+	Message  string
+	Layer    string
+	// Range of the text fill, or of the first color of a pair. The range is
+	// empty for default values.
+	Range cartocss.Range
+	// Related contains the range of the halo fill or background color, or of
+	// the second color of a pair.
+	Related []cartocss.Range
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s (%s) in %s", f.Severity, f.Message, f.Rule, f.Range.Start)
+}
+
+// Options of the checks. Zero values are replaced by the defaults.
+type Options struct {
+	// MinContrast is the minimum contrast ratio of text, 4.5 by default
+	// (WCAG level AA).
+	MinContrast float64
+	// MinLargeContrast is the minimum contrast ratio of text with a size of
+	// at least LargeTextSize, 3 by default.
+	MinLargeContrast float64
+	// MinDistance is the minimum perceptual distance (see color.Distance)
+	// of the fill and line colors of a layer, 0.04 by default.
+	MinDistance float64
+	// Zoom limits the checks to rules for these zoom levels. All rules are
+	// checked by default.
+	Zoom cartocss.ZoomRange
+}
+
+// LargeTextSize is the minimum size of large text in pixels (18pt).
+const LargeTextSize = 24
+
+func (o *Options) setDefaults() {
+	if o.MinContrast == 0 {
+		o.MinContrast = 4.5
+	}
+	if o.MinLargeContrast == 0 {
+		o.MinLargeContrast = 3
+	}
+	if o.MinDistance == 0 {
+		o.MinDistance = 0.04
+	}
+}
+
+// Check checks the rules of all layers (see cartocss.MSS.EachLayer) and
+// returns the findings, ordered by their position.
+//
+// The contrast of each text and shield symbolizer is checked against the
+// halo, if the rule has a halo radius, or against the background-color of
+// the map. Colors with transparency are blended over their background, the
+// halo over the background-color and the background-color over white.
+// Rules without text-name or shield-name are skipped, as they do not create
+// a symbolizer.
+//
+// All fill and line colors of a layer are compared in pairs. Pairs that are
+// distinguishable with normal vision but not with protanopia, deuteranopia
+// or tritanopia are reported.
+func Check(d *cartocss.Decoder, mml *cartocss.MML, opts Options) []Finding {
+	opts.setDefaults()
+	c := checker{
+		opts:       opts,
+		background: d.MSS().Map(),
+		seen:       map[[2]cartocss.Range]struct{}{},
+	}
+
+	d.MSS().EachLayer(mml, opts.Zoom, c.layer)
+
+	sort.SliceStable(c.findings, func(i, j int) bool {
+		a, b := c.findings[i], c.findings[j]
+		if a.Range.Start != b.Range.Start {
+			return a.Range.Start.Before(b.Range.Start)
+		}
+		return len(a.Related) > 0 && len(b.Related) > 0 && a.Related[0].Start.Before(b.Related[0].Start)
+	})
+	return c.findings
+}
+
+type checker struct {
+	opts       Options
+	background *cartocss.Properties
+	findings   []Finding
+	// seen contains the ranges of all reported colors, as the same
+	// declarations are part of multiple rules
+	seen map[[2]cartocss.Range]struct{}
+}
+
+// declaredColor is a color with the range of its declaration.
+type declaredColor struct {
+	property string
+	color    color.Color
+	r        cartocss.Range
+}
+
+// text and shield symbolizers with their text properties.
+var textPrefixes = []string{"text-", "shield-"}
+
+// colorProperties contains all fill and line colors that are compared.
+var colorProperties = []string{"polygon-fill", "line-color", "building-fill", "marker-fill", "marker-line-color", "dot-fill"}
+
+func (c *checker) layer(layer string, rules []cartocss.Rule) {
+	// only the first declaration of each color is compared
+	var colors []declaredColor
+	added := map[string]struct{}{}
+
+	for _, r := range rules {
+		for _, p := range cartocss.SortedPrefixes(r.Properties, textPrefixes) {
+			c.contrast(layer, p, r.Properties.WithInstance(p.Instance))
+		}
+
+		for _, p := range cartocss.SortedPrefixes(r.Properties, colorProperties) {
+			props := r.Properties.WithInstance(p.Instance)
+			col, ok := props.GetColor(p.Name)
+			if !ok {
+				continue
+			}
+			if _, ok := added[col.String()]; ok {
+				continue
+			}
+			added[col.String()] = struct{}{}
+			pos, _ := props.Range(p.Name)
+			name := p.Name
+			if p.Instance != "" {
+				name = p.Instance + "/" + name
+			}
+			colors = append(colors, declaredColor{name, col, pos})
+		}
+	}
+	c.deficiencies(layer, colors)
+}
+
+// contrast checks the text fill of the symbolizer with the prefix.
+func (c *checker) contrast(layer string, p cartocss.Prefix, props *cartocss.Properties) {
+	if _, ok := props.Range(p.Name + "name"); !ok {
+		return
+	}
+	fill := colorProperty(props, p.Name+"fill")
+
+	mapBackground, hasBackground := c.background.GetColor("background-color")
+	base := color.MustParse("white")
+	if hasBackground {
+		base = color.Blend(mapBackground, base)
+	}
+	var bg declaredColor
+	var background color.Color
+	if radius, ok := props.GetFloat(p.Name + "halo-radius"); ok && radius > 0 {
+		bg = colorProperty(props, p.Name+"halo-fill")
+		background = color.Blend(bg.color, base)
+	} else if hasBackground {
+		r, _ := c.background.Range("background-color")
+		bg = declaredColor{"background-color", mapBackground, r}
+		background = base
+	} else {
+		// transparent background
+		return
+	}
+
+	key := [2]cartocss.Range{fill.r, bg.r}
+	if fill.r == (cartocss.Range{}) {
+		// default fill, report at the first property of the symbolizer
+		key[0] = firstRange(props, p.Name)
+	}
+	if _, ok := c.seen[key]; ok {
+		return
+	}
+	c.seen[key] = struct{}{}
+
+	ratio := color.Contrast(color.Blend(fill.color, background), background)
+	minRatio := c.opts.MinContrast
+	if size, ok := props.GetFloat(p.Name + "size"); ok && size >= LargeTextSize {
+		minRatio = c.opts.MinLargeContrast
+	}
+	if ratio >= minRatio {
+		return
+	}
+	name := fill.property
+	if p.Instance != "" {
+		name = p.Instance + "/" + name
+	}
+	f := Finding{
+		Rule:     LowContrast,
+		Severity: cartocss.SeverityWarning,
+		Message: fmt.Sprintf("%s %s on %s %s has a contrast ratio of %.2f:1, minimum is %.1f:1",
+			name, fill.color, bg.property, bg.color, ratio, minRatio),
+		Layer: layer,
+		Range: key[0],
+	}
+	if bg.r != (cartocss.Range{}) {
+		f.Related = []cartocss.Range{bg.r}
+	}
+	c.findings = append(c.findings, f)
+}
+
+// colorProperty returns the color of the property, or the default value.
+func colorProperty(props *cartocss.Properties, name string) declaredColor {
+	if col, ok := props.GetColor(name); ok {
+		r, _ := props.Range(name)
+		return declaredColor{name, col, r}
+	}
+	spec, _ := cartocss.PropertySpec(name)
+	col, _ := spec.Default.(color.Color)
+	return declaredColor{name, col, cartocss.Range{}}
+}
+
+// firstRange returns the first declaration of all properties with the prefix.
+func firstRange(props *cartocss.Properties, prefix string) cartocss.Range {
+	var first cartocss.Range
+	for _, name := range props.Names() {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		r, _ := props.Range(name)
+		if first.Start.Line == 0 || r.Start.Before(first.Start) {
+			first = r
+		}
+	}
+	return first
+}
+
+// deficiencies compares all pairs of colors with simulated color vision
+// deficiencies.
+func (c *checker) deficiencies(layer string, colors []declaredColor) {
+	for i := range colors {
+		for j := i + 1; j < len(colors); j++ {
+			a, b := colors[i], colors[j]
+			if b.r.Start.Before(a.r.Start) {
+				a, b = b, a
+			}
+			if color.Distance(a.color, b.color) < c.opts.MinDistance {
+				continue
+			}
+			key := [2]cartocss.Range{a.r, b.r}
+			if _, ok := c.seen[key]; ok {
+				continue
+			}
+			var names []string
+			for _, d := range color.Deficiencies {
+				if color.Distance(color.Simulate(a.color, d), color.Simulate(b.color, d)) < c.opts.MinDistance {
+					names = append(names, d.String())
+				}
+			}
+			if len(names) == 0 {
+				continue
+			}
+			c.seen[key] = struct{}{}
+			c.findings = append(c.findings, Finding{
+				Rule:     Indistinguishable,
+				Severity: cartocss.SeverityWarning,
+				Message: fmt.Sprintf("%s %s and %s %s are indistinguishable with %s",
+					a.property, a.color, b.property, b.color, strings.Join(names, ", ")),
+				Layer:   layer,
+				Range:   a.r,
+				Related: []cartocss.Range{b.r},
+			})
+		}
+	}
+}
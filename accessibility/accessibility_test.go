@@ -0,0 +1,87 @@
+package accessibility
+
+import (
+	"strings"
+	"testing"
+
+	cartocss "github.com/flywave/go-cartocss"
+	"github.com/flywave/go-cartocss/internal/decodetest"
+	"github.com/stretchr/testify/assert"
+)
+
+type result struct {
+	rule    RuleID
+	line    int
+	related int
+	msg     string
+}
+
+func results(findings []Finding) []result {
+	var got []result
+	for _, f := range findings {
+		r := result{f.Rule, f.Range.Start.Line, 0, f.Message}
+		if len(f.Related) > 0 {
+			r.related = f.Related[0].Start.Line
+		}
+		got = append(got, r)
+	}
+	return got
+}
+
+func TestCheck(t *testing.T) {
+	d := decodetest.DecodeFile(t, decodetest.Colors)
+	findings := Check(d, nil, Options{})
+	assert.Equal(t, []result{
+		{LowContrast, 6, 2, "text-fill #999999 on background-color #f8f8f8 has a contrast ratio of 2.68:1, minimum is 4.5:1"},
+		{LowContrast, 6, 9, "text-fill #999999 on text-halo-fill #888888 has a contrast ratio of 1.24:1, minimum is 4.5:1"},
+		{Indistinguishable, 18, 19, "polygon-fill #d62728 and polygon-fill #2ca02c are indistinguishable with deuteranopia"},
+	}, results(findings))
+	assert.Equal(t, "places", findings[0].Layer)
+	assert.Equal(t, "landuse", findings[2].Layer)
+	assert.True(t, strings.HasPrefix(findings[0].String(), "warning: text-fill #999999 on background-color"), findings[0].String())
+
+	// classes from the MML
+	mml := &cartocss.MML{Layers: []cartocss.Layer{{ID: "landuse", Classes: []string{"small"}}}}
+	assert.Equal(t, []result{
+		{Indistinguishable, 18, 19, "polygon-fill #d62728 and polygon-fill #2ca02c are indistinguishable with deuteranopia"},
+		{LowContrast, 21, 2, "b/text-fill #aaaaaa on background-color #f8f8f8 has a contrast ratio of 2.19:1, minimum is 4.5:1"},
+	}, results(Check(d, mml, Options{})))
+}
+
+func TestCheckOptions(t *testing.T) {
+	d := decodetest.DecodeFile(t, decodetest.Colors)
+
+	// halo is only used from zoom level 10
+	assert.Equal(t, []result{
+		{LowContrast, 6, 2, "text-fill #999999 on background-color #f8f8f8 has a contrast ratio of 2.68:1, minimum is 4.5:1"},
+		{Indistinguishable, 18, 19, "polygon-fill #d62728 and polygon-fill #2ca02c are indistinguishable with deuteranopia"},
+	}, results(Check(d, nil, Options{Zoom: cartocss.NewZoomRange(cartocss.LT, 10)})))
+
+	assert.Equal(t, []result{
+		{LowContrast, 6, 9, "text-fill #999999 on text-halo-fill #888888 has a contrast ratio of 1.24:1, minimum is 2.5:1"},
+		{LowContrast, 13, 2, "a/text-fill #888888 on background-color #f8f8f8 has a contrast ratio of 3.34:1, minimum is 7.0:1"},
+	}, results(Check(d, nil, Options{MinContrast: 2.5, MinLargeContrast: 7, MinDistance: 0.01})))
+}
+
+func TestCheckDefaults(t *testing.T) {
+	// default text fill is black, no background without background-color
+	d := decodetest.Decode(t, `
+#a { text-name: [name]; text-halo-radius: 1; text-halo-fill: #333; }
+#b { text-name: [name]; text-halo-radius: 0; text-halo-fill: #333; }
+`)
+	assert.Equal(t, []result{
+		{LowContrast, 2, 2, "text-fill #000000 on text-halo-fill #333333 has a contrast ratio of 1.66:1, minimum is 4.5:1"},
+	}, results(Check(d, nil, Options{})))
+}
+
+func TestCheckTransparentHalo(t *testing.T) {
+	// the halo is blended over the background-color, not over white
+	d := decodetest.Decode(t, `
+Map { background-color: #000; }
+#a { text-name: [name]; text-fill: #fff; text-halo-radius: 1; text-halo-fill: rgba(255, 255, 255, 0.5); }
+#b { text-size: 12; text-fill: #111; shield-size: 12; shield-fill: #111; shield-file: url(shield.svg); }
+`)
+	assert.Equal(t, []result{
+		{LowContrast, 3, 3, "text-fill #ffffff on text-halo-fill rgba(255, 255, 255, 0.50000) has a contrast ratio of 3.98:1, minimum is 4.5:1"},
+	}, results(Check(d, nil, Options{})))
+}
@@ -0,0 +1,385 @@
+package cartocss
+
+import (
+	"strings"
+
+	"github.com/flywave/go-cartocss/color"
+)
+
+// Stylesheet is the syntax tree of a single parsed .mss file or string. See
+// Decoder.Stylesheets.
+//
+// The syntax tree is read-only. It reflects the source as written, before
+// evaluation, and changes are not reflected in the decoded MSS.
+type Stylesheet struct {
+	// Filename is empty for stylesheets parsed with ParseString.
+	Filename string
+	// Nodes contains all top level statements (*Import, *VarDecl and *Block)
+	// in order of appearance. Statements with parse errors are omitted.
+	Nodes []Node
+	// Comments contains all comments in order of appearance, including the
+	// comments attached to the nodes.
+	Comments []*Comment
+}
+
+// Node is a single element of the syntax tree.
+type Node interface {
+	// Range returns the source range of this node.
+	Range() Range
+	node()
+}
+
+type span struct {
+	r Range
+}
+
+// Range returns the source range of this node.
+func (s span) Range() Range { return s.r }
+func (s span) node()        {}
+
+// Comment is a /* block */ or // line comment. Text includes the comment
+// delimiters.
+type Comment struct {
+	span
+	Text string
+}
+
+// Import is an @import statement.
+type Import struct {
+	span
+	Path string
+}
+
+// VarDecl is a variable definition, eg. `@water: #a0c8f0;`.
+type VarDecl struct {
+	span
+	// Name of the variable without @.
+	Name  string
+	Value *Expr
+	// Comments directly before the definition, see Block.Comments.
+	Comments []*Comment
+}
+
+// Block is a rule with one or more selectors, or the Map block. Nodes
+// contains all declarations (*Declaration) and nested rules (*Block) in order
+// of appearance.
+type Block struct {
+	span
+	// Map is true for the Map block, which has no selectors.
+	Map       bool
+	Selectors []*SelectorNode
+	Nodes     []Node
+	// Comments directly before the block. Comments that are separated by an
+	// empty line, or that follow another statement on the same line, are not
+	// attached.
+	Comments []*Comment
+	// Doc contains the annotations of all doc comments (/** ... */) in
+	// Comments, eg. desc: Motorway casing for /** @desc Motorway casing */.
+	// The text before the first annotation is stored as desc.
+	Doc map[string]string
+}
+
+// SelectorNode is a single selector of a Block, eg.
+// `#roads::casing.major[zoom >= 12]`.
+type SelectorNode struct {
+	span
+	// Text of the whole selector in a normalized form.
+	Text string
+	// Layer without #, empty for selectors without layer.
+	Layer string
+	// Classes without ., in order of appearance.
+	Classes []string
+	// Attachment without ::, empty for selectors without attachment.
+	Attachment string
+	// Filters contains all filters (including zoom filters) in a normalized
+	// form, eg. `[zoom >= 12]`.
+	Filters []string
+
+	toks []*token
+}
+
+// Declaration is a property declaration, eg. `line-width: 2;`.
+type Declaration struct {
+	span
+	// Instance name without /, empty for the default instance.
+	Instance string
+	Property string
+	Value    *Expr
+	// Comments directly before the declaration, see Block.Comments.
+	Comments []*Comment
+}
+
+// Expr is the unevaluated value of a declaration or variable definition.
+type Expr struct {
+	span
+	// Text of the expression in a normalized form, eg. `@width * 2`.
+	Text string
+
+	toks []*token
+}
+
+// ColorLiteral is a hex or named color in an expression, eg. #a0c8f0 or red.
+type ColorLiteral struct {
+	Range Range
+	Color color.Color
+}
+
+// Colors returns all color literals of the expression in order of appearance.
+// Colors from functions, like rgb(0, 0, 0), are not included.
+func (e *Expr) Colors() []ColorLiteral {
+	var colors []ColorLiteral
+	for _, tok := range e.toks {
+		if tok.t != tokenHash && tok.t != tokenIdent {
+			continue
+		}
+		c, err := color.Parse(tok.value)
+		if err != nil {
+			continue
+		}
+		line, col := tok.end()
+		colors = append(colors, ColorLiteral{
+			Range: Range{
+				Start: Position{Filename: e.r.Start.Filename, Line: tok.line, Column: tok.column},
+				End:   Position{Filename: e.r.Start.Filename, Line: line, Column: col},
+			},
+			Color: c,
+		})
+	}
+	return colors
+}
+
+// Stylesheets returns the syntax trees of all parsed files and strings,
+// including imported files, in order of parsing.
+func (d *Decoder) Stylesheets() []*Stylesheet {
+	return append([]*Stylesheet(nil), d.sheets...)
+}
+
+// addNode appends n to the current block, or to the current stylesheet for
+// top level statements.
+func (d *Decoder) addNode(n Node) {
+	if len(d.blocks) > 0 {
+		b := d.blocks[len(d.blocks)-1]
+		b.Nodes = append(b.Nodes, n)
+		return
+	}
+	d.sheet.Nodes = append(d.sheet.Nodes, n)
+}
+
+// pushNodeBlock adds a new block starting with the first token to the syntax
+// tree. All following nodes are added to this block till popNodeBlock is
+// called.
+func (d *Decoder) pushNodeBlock(b *Block, first *token) {
+	b.Comments = d.leadingComments(first)
+	b.Doc = parseDocComments(b.Comments)
+	d.addNode(b)
+	d.blocks = append(d.blocks, b)
+}
+
+func (d *Decoder) popNodeBlock(first *token) {
+	b := d.blocks[len(d.blocks)-1]
+	b.r = d.rangePos(first, d.lastTok).Range()
+	d.blocks = d.blocks[:len(d.blocks)-1]
+}
+
+// addComment records a comment token for the current stylesheet.
+func (d *Decoder) addComment(tok *token) *Comment {
+	c := &Comment{
+		span: span{d.rangePos(tok, tok).Range()},
+		Text: tok.value,
+	}
+	d.sheet.Comments = append(d.sheet.Comments, c)
+	return c
+}
+
+// leadingComments returns the comments before tok, without comments that
+// are separated from tok by an empty line.
+func (d *Decoder) leadingComments(tok *token) []*Comment {
+	comments := d.leading[tok]
+	line := tok.line
+	for i := len(comments) - 1; i >= 0; i-- {
+		r := comments[i].Range()
+		if r.End.Line < line-1 {
+			return comments[i+1:]
+		}
+		line = r.Start.Line
+	}
+	return comments
+}
+
+// parseDocComments returns the annotations of all doc comments, eg:
+//
+//	/**
+//	 * Motorways and trunks
+//	 * @casing true
+//	 */
+//
+// returns desc: Motorways and trunks, casing: true. Each word starting with @
+// starts a new annotation. Returns nil if there are no doc comments.
+func parseDocComments(comments []*Comment) map[string]string {
+	var doc map[string]string
+	for _, c := range comments {
+		if !strings.HasPrefix(c.Text, "/**") || c.Text == "/**/" {
+			continue
+		}
+		if doc == nil {
+			doc = map[string]string{}
+		}
+		tag := "desc"
+		var text []string
+		flush := func() {
+			if len(text) > 0 || tag != "desc" {
+				doc[tag] = strings.Join(text, " ")
+			}
+			text = nil
+		}
+		body := strings.TrimSuffix(strings.TrimPrefix(c.Text, "/**"), "*/")
+		for _, line := range strings.Split(body, "\n") {
+			line = strings.TrimLeft(strings.TrimSpace(line), "*")
+			for _, word := range strings.Fields(line) {
+				if len(word) > 1 && word[0] == '@' {
+					flush()
+					tag = word[1:]
+					continue
+				}
+				text = append(text, word)
+			}
+		}
+		flush()
+	}
+	return doc
+}
+
+// recorded returns a copy of all tokens since the start index of the token
+// record.
+func (d *Decoder) recorded(start int) []*token {
+	return append([]*token(nil), d.record[start:]...)
+}
+
+// newSelectorNode creates a selector from the tokens of a single selector.
+func (d *Decoder) newSelectorNode(toks []*token) *SelectorNode {
+	s := &SelectorNode{
+		span: span{d.rangePos(toks[0], toks[len(toks)-1]).Range()},
+		Text: selectorText(toks, nil),
+		toks: toks,
+	}
+	for i := 0; i < len(toks); i++ {
+		switch toks[i].t {
+		case tokenHash:
+			s.Layer = toks[i].value[1:]
+		case tokenAttachment:
+			s.Attachment = toks[i].value[2:]
+		case tokenClass:
+			s.Classes = append(s.Classes, toks[i].value[1:])
+		case tokenLBracket:
+			end := filterEnd(toks, i)
+			s.Filters = append(s.Filters, "["+formatTokens(toks[i+1:end], nil)+"]")
+			i = end
+		}
+	}
+	return s
+}
+
+// filterEnd returns the index of the closing bracket of the filter starting
+// at toks[start].
+func filterEnd(toks []*token, start int) int {
+	end := start + 1
+	for end < len(toks)-1 && toks[end].t != tokenRBracket {
+		end++
+	}
+	return end
+}
+
+// selectorText joins the tokens of a single selector. Filters are formatted
+// with formatTokens.
+func selectorText(toks []*token, value func(*token) string) string {
+	var b strings.Builder
+	for i := 0; i < len(toks); i++ {
+		if toks[i].t == tokenLBracket {
+			end := filterEnd(toks, i)
+			b.WriteString("[" + formatTokens(toks[i+1:end], value) + "]")
+			i = end
+			continue
+		}
+		b.WriteString(toks[i].value)
+	}
+	return b.String()
+}
+
+// newExpr creates an expression from all tokens of an expression list.
+func (d *Decoder) newExpr(toks []*token) *Expr {
+	e := &Expr{
+		Text: formatTokens(toks, nil),
+		toks: toks,
+	}
+	if len(toks) > 0 {
+		e.r = d.rangePos(toks[0], toks[len(toks)-1]).Range()
+	}
+	return e
+}
+
+// formatTokens joins the tokens of an expression or a filter with normalized
+// whitespace: one space after commas and around binary operators, none inside
+// of brackets and parentheses. value returns the text of each token, the
+// source text is used if value is nil.
+func formatTokens(toks []*token, value func(*token) string) string {
+	var b strings.Builder
+	for i, tok := range toks {
+		if i > 0 && spaceBetween(toks, i) {
+			b.WriteByte(' ')
+		}
+		if value != nil {
+			b.WriteString(value(tok))
+		} else {
+			b.WriteString(tok.value)
+		}
+	}
+	return b.String()
+}
+
+// spaceBetween returns whether toks[i] needs to be separated from the
+// previous token.
+func spaceBetween(toks []*token, i int) bool {
+	switch toks[i-1].t {
+	case tokenComma:
+		return true
+	case tokenLParen, tokenLBracket, tokenFunction:
+		return false
+	case tokenMinus:
+		// no space after unary minus
+		return i > 1 && !isOperatorToken(toks[i-2].t)
+	}
+	switch toks[i].t {
+	case tokenComma, tokenRParen, tokenRBracket:
+		return false
+	}
+	return true
+}
+
+// isOperatorToken returns whether a following minus is a unary minus.
+func isOperatorToken(t tokenType) bool {
+	switch t {
+	case tokenComma, tokenLParen, tokenFunction, tokenLBracket,
+		tokenPlus, tokenMinus, tokenMultiply, tokenDivide, tokenComp, tokenModulo:
+		return true
+	}
+	return false
+}
+
+// numNodes returns the number of nodes in the current block or stylesheet.
+func (d *Decoder) numNodes() int {
+	if len(d.blocks) > 0 {
+		return len(d.blocks[len(d.blocks)-1].Nodes)
+	}
+	return len(d.sheet.Nodes)
+}
+
+// truncateNodes drops all nodes of the current block or stylesheet after the
+// first n nodes.
+func (d *Decoder) truncateNodes(n int) {
+	if len(d.blocks) > 0 {
+		b := d.blocks[len(d.blocks)-1]
+		b.Nodes = b.Nodes[:n]
+		return
+	}
+	d.sheet.Nodes = d.sheet.Nodes[:n]
+}
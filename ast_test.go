@@ -0,0 +1,209 @@
+package cartocss
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStylesheet(t *testing.T) {
+	d := NewDecoder()
+	err := d.ParseString(`/* colors */
+@water: #a0c8f0;
+@width: -@base*2 + 1;
+
+Map { background-color: @water; }
+
+#roads::casing.major[zoom>=12], #bridges {
+	line-width: max(@width,2); // line comment
+	[type='motorway'] {
+		a/line-color:   red;
+		line-cap: round;
+	}
+}
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	sheets := d.Stylesheets()
+	if !assert.Len(t, sheets, 1) {
+		return
+	}
+	s := sheets[0]
+	assert.Equal(t, "", s.Filename)
+	if assert.Len(t, s.Comments, 2) {
+		assert.Equal(t, "/* colors */", s.Comments[0].Text)
+		assert.Equal(t, "// line comment", s.Comments[1].Text)
+		assert.Equal(t, Position{Line: 8, Column: 29}, s.Comments[1].Range().Start)
+	}
+	if !assert.Len(t, s.Nodes, 4) {
+		return
+	}
+
+	water := s.Nodes[0].(*VarDecl)
+	assert.Equal(t, "water", water.Name)
+	assert.Equal(t, "#a0c8f0", water.Value.Text)
+	assert.Equal(t, Range{Start: Position{Line: 2, Column: 1}, End: Position{Line: 2, Column: 16}}, water.Range())
+	assert.Equal(t, Range{Start: Position{Line: 2, Column: 9}, End: Position{Line: 2, Column: 16}}, water.Value.Range())
+	assert.Equal(t, "-@base * 2 + 1", s.Nodes[1].(*VarDecl).Value.Text)
+
+	m := s.Nodes[2].(*Block)
+	assert.True(t, m.Map)
+	assert.Empty(t, m.Selectors)
+	if assert.Len(t, m.Nodes, 1) {
+		assert.Equal(t, "background-color", m.Nodes[0].(*Declaration).Property)
+		assert.Equal(t, "@water", m.Nodes[0].(*Declaration).Value.Text)
+	}
+
+	roads := s.Nodes[3].(*Block)
+	assert.False(t, roads.Map)
+	assert.Equal(t, Range{Start: Position{Line: 7, Column: 1}, End: Position{Line: 13, Column: 2}}, roads.Range())
+	if assert.Len(t, roads.Selectors, 2) {
+		sel := roads.Selectors[0]
+		assert.Equal(t, "#roads::casing.major[zoom >= 12]", sel.Text)
+		assert.Equal(t, "roads", sel.Layer)
+		assert.Equal(t, "casing", sel.Attachment)
+		assert.Equal(t, []string{"major"}, sel.Classes)
+		assert.Equal(t, []string{"[zoom >= 12]"}, sel.Filters)
+		assert.Equal(t, Range{Start: Position{Line: 7, Column: 1}, End: Position{Line: 7, Column: 31}}, sel.Range())
+		assert.Equal(t, "bridges", roads.Selectors[1].Layer)
+	}
+	if !assert.Len(t, roads.Nodes, 2) {
+		return
+	}
+	width := roads.Nodes[0].(*Declaration)
+	assert.Equal(t, "line-width", width.Property)
+	assert.Equal(t, "max(@width, 2)", width.Value.Text)
+	assert.Equal(t, Range{Start: Position{Line: 8, Column: 2}, End: Position{Line: 8, Column: 27}}, width.Range())
+
+	nested := roads.Nodes[1].(*Block)
+	if assert.Len(t, nested.Selectors, 1) {
+		assert.Equal(t, "[type = 'motorway']", nested.Selectors[0].Text)
+		assert.Equal(t, "", nested.Selectors[0].Layer)
+	}
+	if assert.Len(t, nested.Nodes, 2) {
+		c := nested.Nodes[0].(*Declaration)
+		assert.Equal(t, "a", c.Instance)
+		assert.Equal(t, "line-color", c.Property)
+		assert.Equal(t, "red", c.Value.Text)
+		assert.Equal(t, Position{Line: 10, Column: 3}, c.Range().Start)
+	}
+}
+
+func TestStylesheetErrorRecovery(t *testing.T) {
+	d := NewDecoder()
+	err := d.ParseString(`
+		@a: 1;
+		@b: ;
+		#foo {
+			line-width: 1;
+			line-color: ;
+			[zoom > ] { line-cap: round; }
+			line-opacity: 0.5;
+		}
+		#bar { line-width: 2; }
+	`)
+	assert.Error(t, err)
+	s := d.Stylesheets()[0]
+	if !assert.Len(t, s.Nodes, 3) {
+		return
+	}
+	assert.Equal(t, "a", s.Nodes[0].(*VarDecl).Name)
+	foo := s.Nodes[1].(*Block)
+	if assert.Len(t, foo.Nodes, 2) {
+		assert.Equal(t, "line-width", foo.Nodes[0].(*Declaration).Property)
+		assert.Equal(t, "line-opacity", foo.Nodes[1].(*Declaration).Property)
+	}
+	assert.Equal(t, "bar", s.Nodes[2].(*Block).Selectors[0].Layer)
+}
+
+func TestStylesheetImport(t *testing.T) {
+	d := NewDecoder()
+	assert.NoError(t, d.ParseFile("tests/import/main.mss"))
+	sheets := d.Stylesheets()
+	if !assert.Len(t, sheets, 3) {
+		return
+	}
+	assert.Equal(t, "tests/import/main.mss", sheets[0].Filename)
+	assert.Equal(t, filepath.Join("tests/import", "colors.mss"), sheets[1].Filename)
+	imp, ok := sheets[0].Nodes[0].(*Import)
+	if assert.True(t, ok) {
+		assert.Equal(t, "colors.mss", imp.Path)
+	}
+	for _, s := range sheets {
+		assert.NotEmpty(t, s.Nodes, s.Filename)
+	}
+}
+
+func TestStylesheetFileContent(t *testing.T) {
+	d := NewDecoder()
+	assert.NoError(t, d.ParseFileContent("tests/import/unsaved.mss", `@import "colors.mss";`))
+	sheets := d.Stylesheets()
+	if assert.Len(t, sheets, 2) {
+		assert.Equal(t, "tests/import/unsaved.mss", sheets[0].Filename)
+		assert.Equal(t, filepath.Join("tests/import", "colors.mss"), sheets[1].Filename)
+	}
+	assert.Equal(t, []string{"tests/import/unsaved.mss", filepath.Join("tests/import", "colors.mss")}, d.Files())
+}
+
+func TestExprColors(t *testing.T) {
+	d := NewDecoder()
+	assert.NoError(t, d.ParseString(`#a { line-color: mix(#FFF, red, 50%); text-name: "red"; line-cap: round; }`))
+	b := d.Stylesheets()[0].Nodes[0].(*Block)
+
+	colors := b.Nodes[0].(*Declaration).Value.Colors()
+	if assert.Len(t, colors, 2) {
+		assert.Equal(t, Range{Start: Position{Line: 1, Column: 22}, End: Position{Line: 1, Column: 26}}, colors[0].Range)
+		assert.Equal(t, "#ffffff", colors[0].Color.String())
+		assert.Equal(t, Range{Start: Position{Line: 1, Column: 28}, End: Position{Line: 1, Column: 31}}, colors[1].Range)
+		assert.Equal(t, "#ff0000", colors[1].Color.String())
+	}
+	assert.Empty(t, b.Nodes[1].(*Declaration).Value.Colors())
+	assert.Empty(t, b.Nodes[2].(*Declaration).Value.Colors())
+}
+
+func TestStylesheetComments(t *testing.T) {
+	d := NewDecoder()
+	err := d.ParseString(`/* header */
+
+// water color
+@water: blue; // trailing
+
+/**
+ * Motorways
+ * and trunks
+ * @casing
+ * @since 2.0
+ */
+#roads { // after brace
+	/* width */
+	line-width: 1;
+	line-color: red;
+	/** @desc Labels */
+	::labels { text-name: [name]; }
+}
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	s := d.Stylesheets()[0]
+	assert.Len(t, s.Comments, 7)
+
+	water := s.Nodes[0].(*VarDecl)
+	if assert.Len(t, water.Comments, 1) {
+		assert.Equal(t, "// water color", water.Comments[0].Text)
+	}
+
+	roads := s.Nodes[1].(*Block)
+	assert.Len(t, roads.Comments, 1)
+	assert.Equal(t, map[string]string{"desc": "Motorways and trunks", "casing": "", "since": "2.0"}, roads.Doc)
+	if assert.Len(t, roads.Nodes, 3) {
+		width := roads.Nodes[0].(*Declaration)
+		if assert.Len(t, width.Comments, 1) {
+			assert.Equal(t, "/* width */", width.Comments[0].Text)
+		}
+		assert.Empty(t, roads.Nodes[1].(*Declaration).Comments)
+		assert.Equal(t, map[string]string{"desc": "Labels"}, roads.Nodes[2].(*Block).Doc)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 
 	cartocss "github.com/flywave/go-cartocss"
 
@@ -16,10 +17,13 @@ import (
 type Builder struct {
 	dstMap          Map
 	mss             []string
+	mssFiles        []string
 	mml             string
 	locator         config.Locator
 	dumpRules       io.Writer
 	includeInactive bool
+	variables       map[string]interface{}
+	warnings        []cartocss.Warning
 }
 
 // New returns a Builder
@@ -37,6 +41,27 @@ func (b *Builder) SetMML(mml string) {
 	b.mml = mml
 }
 
+// SetVariables sets/overwrites variables of all MSS files, eg. to build
+// different variants of the same style. Names are without the leading @.
+// Integers and color strings, eg. from JSON or YAML, are converted (see
+// cartocss.Decoder.SetVar). Variables that are not used by any MSS file are
+// reported by Warnings with the cartocss.UnusedOverride code.
+func (b *Builder) SetVariables(vars map[string]interface{}) {
+	b.variables = vars
+}
+
+// MSSFiles returns all MSS files parsed by the last Build call, including
+// all files referenced with @import.
+func (b *Builder) MSSFiles() []string {
+	return b.mssFiles
+}
+
+// Warnings returns all warnings of the last Build call (see
+// cartocss.Decoder.Warnings).
+func (b *Builder) Warnings() []cartocss.Warning {
+	return b.warnings
+}
+
 // SetDumpRulesDest enables internal debuging output.
 func (b *Builder) SetDumpRulesDest(w io.Writer) {
 	b.dumpRules = w
@@ -49,6 +74,7 @@ func (b *Builder) SetIncludeInactive(includeInactive bool) {
 
 // Build parses MML, MSS files, builds all rules and adds them to the Map.
 func (b *Builder) Build() error {
+	b.warnings = nil
 	layerIDs := []string{}
 	layers := []cartocss.Layer{}
 
@@ -77,16 +103,29 @@ func (b *Builder) Build() error {
 
 	carto := cartocss.NewDecoder()
 
+	names := make([]string, 0, len(b.variables))
+	for name := range b.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := carto.SetVar(name, b.variables[name]); err != nil {
+			return err
+		}
+	}
+
 	for _, mss := range b.mss {
 		err := carto.ParseFile(mss)
 		if err != nil {
 			return err
 		}
 	}
+	b.mssFiles = carto.Files()
 
 	if err := carto.Evaluate(); err != nil {
 		return err
 	}
+	b.warnings = carto.Warnings()
 
 	if m, ok := b.dstMap.(MapZoomScaleSetter); ok {
 		if mmlObj != nil && mmlObj.Map.ZoomScales != nil {
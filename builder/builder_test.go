@@ -0,0 +1,42 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	cartocss "github.com/flywave/go-cartocss"
+	"github.com/stretchr/testify/assert"
+)
+
+type layerMap struct {
+	layers []string
+}
+
+func (m *layerMap) AddLayer(l cartocss.Layer, rules []cartocss.Rule) {
+	m.layers = append(m.layers, l.ID)
+}
+
+func TestBuildUnusedVariables(t *testing.T) {
+	mss := filepath.Join(t.TempDir(), "style.mss")
+	if err := os.WriteFile(mss, []byte("@width: 1;\n#roads { line-width: @width; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &layerMap{}
+	b := New(m)
+	b.AddMSS(mss)
+	b.SetVariables(map[string]interface{}{"width": 2, "widht": 3})
+	assert.NoError(t, b.Build())
+	assert.Equal(t, []string{"roads"}, m.layers)
+
+	warnings := b.Warnings()
+	if assert.Len(t, warnings, 1) {
+		assert.Equal(t, cartocss.UnusedOverride, warnings[0].Code)
+		assert.Contains(t, warnings[0].Message, "widht")
+	}
+
+	b.SetVariables(map[string]interface{}{"width": 2})
+	assert.NoError(t, b.Build())
+	assert.Empty(t, b.Warnings())
+}
@@ -28,6 +28,7 @@ type style struct {
 	mapMaker   MapMaker
 	mml        string
 	mss        []string
+	imports    []string // all parsed mss files, including @imports
 	file       string
 	lastUpdate time.Time
 }
@@ -69,6 +70,11 @@ func (s *style) isStale() (bool, error) {
 			return true, nil
 		}
 	}
+	for _, mss := range s.imports {
+		if isNewer(mss, timestamp) {
+			return true, nil
+		}
+	}
 	return false, nil
 }
 
@@ -246,6 +252,7 @@ func (c *Cache) build(style *style) error {
 		}
 	}
 	log.Printf("rebuild style %s as %s with %v\n", style.mml, styleFile, style.mss)
+	style.imports = builder.MSSFiles()
 	style.lastUpdate = time.Now()
 	style.file = styleFile
 	return nil
@@ -0,0 +1,18 @@
+// Command cartocss-lsp is a Language Server Protocol server for CartoCSS
+// (.mss) files. It communicates with the editor over stdin and stdout.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/flywave/go-cartocss/lsp"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("cartocss-lsp: ")
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,158 @@
+// Command cartocss-palette lists all colors of a CartoCSS style with the
+// properties, layers, zoom levels and positions where they are used. Similar
+// colors are grouped, to find colors that should be consolidated.
+//
+// Usage:
+//
+//	cartocss-palette [-mml project.mml] [-distance 0.02] [-json] [style.mss ...]
+//
+// The stylesheets of the MML are used if no .mss files are given.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	cartocss "github.com/flywave/go-cartocss"
+	"github.com/flywave/go-cartocss/palette"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("cartocss-palette: ")
+
+	mmlFile := flag.String("mml", "", "MML project file with layers and stylesheets")
+	distance := flag.Float64("distance", 0.02, "group colors with a perceptual distance below this value (OKLab)")
+	asJSON := flag.Bool("json", false, "write groups as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [style.mss ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var mml *cartocss.MML
+	files := flag.Args()
+	if *mmlFile != "" {
+		f, err := os.Open(*mmlFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mml, err = cartocss.Parse(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("parsing %s: %v", *mmlFile, err)
+		}
+		if len(files) == 0 {
+			for _, s := range mml.Stylesheets {
+				files = append(files, filepath.Join(filepath.Dir(*mmlFile), s))
+			}
+		}
+	}
+	if len(files) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	d := cartocss.NewDecoder()
+	for _, f := range files {
+		if err := d.ParseFile(f); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := d.Evaluate(); err != nil {
+		log.Fatal(err)
+	}
+
+	groups := palette.GroupSimilar(palette.Extract(d, mml), *distance)
+	if *asJSON {
+		if err := writeJSON(os.Stdout, groups); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	writeText(os.Stdout, groups)
+}
+
+func writeText(w io.Writer, groups []palette.Group) {
+	for i, g := range groups {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		for j, e := range g.Entries {
+			uses := "uses"
+			if len(e.Usages) == 1 {
+				uses = "use"
+			}
+			if j == 0 {
+				fmt.Fprintf(w, "%s (%d %s)\n", e.Color, len(e.Usages), uses)
+			} else {
+				fmt.Fprintf(w, "  ~ %s (%d %s, distance %.4f to %s)\n", e.Color, len(e.Usages), uses, g.Distances[j], g.Entries[0].Color)
+			}
+			for _, u := range e.Usages {
+				fmt.Fprintf(w, "    %s %s %s in %s\n", layerName(u.Layer), u.Property, u.Zoom, u.Range.Start)
+			}
+		}
+	}
+}
+
+func layerName(layer string) string {
+	if layer == "" {
+		return "Map"
+	}
+	return "#" + layer
+}
+
+type jsonUsage struct {
+	Layer    string `json:"layer,omitempty"`
+	Property string `json:"property"`
+	Zooms    []int  `json:"zooms"`
+	Filename string `json:"filename,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+}
+
+// zoomLevels returns all zoom levels of z. Zoom ranges can have gaps (e.g.
+// [zoom!=12]), so they are listed instead of reduced to min and max.
+func zoomLevels(z cartocss.ZoomRange) []int {
+	levels := []int{}
+	for l := 0; l <= 30; l++ {
+		if z.ValidFor(l) {
+			levels = append(levels, l)
+		}
+	}
+	return levels
+}
+
+type jsonColor struct {
+	Color    string      `json:"color"`
+	Distance float64     `json:"distance"`
+	Usages   []jsonUsage `json:"usages"`
+}
+
+func writeJSON(w io.Writer, groups []palette.Group) error {
+	result := make([][]jsonColor, len(groups))
+	for i, g := range groups {
+		for j, e := range g.Entries {
+			c := jsonColor{Color: e.Color.String(), Distance: g.Distances[j]}
+			for _, u := range e.Usages {
+				c.Usages = append(c.Usages, jsonUsage{
+					Layer:    u.Layer,
+					Property: u.Property,
+					Zooms:    zoomLevels(u.Zoom),
+					Filename: u.Range.Start.Filename,
+					Line:     u.Range.Start.Line,
+					Column:   u.Range.Start.Column,
+				})
+			}
+			result[i] = append(result[i], c)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
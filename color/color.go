@@ -6,6 +6,7 @@ import (
 	"math"
 	"regexp"
 	"strconv"
+	"strings"
 
 	hsluv "github.com/hsluv/hsluv-go"
 )
@@ -15,7 +16,7 @@ type Color struct {
 	Perceptual bool
 }
 
-var hexRe = regexp.MustCompile(`[a-fA-F0-9]{6,6}`)
+var hexRe = regexp.MustCompile(`^[a-fA-F0-9]+$`)
 
 func FromRgba(r, g, b, a float64, perceptual bool) Color {
 	var h, s, l float64
@@ -35,6 +36,35 @@ func FromHusl(h, s, l, a float64) Color {
 	return Color{h, s, l, a, true}
 }
 
+// FromHwba returns the color for hue (in degrees), whiteness, blackness and
+// alpha. Whiteness and blackness are from 0.0-1.0 and are normalized if their
+// sum is larger than 1.
+func FromHwba(h, w, b, a float64) Color {
+	if w+b >= 1 {
+		grey := w / (w + b)
+		return FromRgba(grey, grey, grey, a, false)
+	}
+	r, g, bl := hslToRgb(h, 1, 0.5)
+	f := 1 - w - b
+	return FromRgba(r*f+w, g*f+w, bl*f+w, a, false)
+}
+
+// Degrees converts an angle with a CSS unit (deg, grad, rad or turn) to
+// degrees.
+func Degrees(v float64, unit string) (float64, bool) {
+	switch strings.ToLower(unit) {
+	case "deg":
+		return v, true
+	case "grad":
+		return v * 0.9, true
+	case "rad":
+		return v * 180 / math.Pi, true
+	case "turn":
+		return v * 360, true
+	}
+	return 0, false
+}
+
 func Parse(colorStr string) (Color, error) {
 	color := Color{}
 	color.A = 1
@@ -48,6 +78,9 @@ func Parse(colorStr string) (Color, error) {
 	if colorStr == "transparent" {
 		return Color{0, 0, 0, 0, false}, nil
 	}
+	if m := funcRe.FindStringSubmatch(colorStr); m != nil {
+		return parseFunction(m[1], m[2])
+	}
 	hex, ok := cssColors[colorStr]
 	if ok {
 		return parseHex(hex)
@@ -61,36 +94,174 @@ func parseHex(hex string) (Color, error) {
 	if len(hex) > 0 && hex[0] == '#' {
 		hex = hex[1:]
 	}
-	if len(hex) == 3 {
-		hex = string(hex[0]) + string(hex[0]) + string(hex[1]) + string(hex[1]) + string(hex[2]) + string(hex[2])
+	if len(hex) == 3 || len(hex) == 4 {
+		long := make([]byte, 0, len(hex)*2)
+		for i := 0; i < len(hex); i++ {
+			long = append(long, hex[i], hex[i])
+		}
+		hex = string(long)
 	}
 
-	if !hexRe.MatchString(hex) {
+	if !hexRe.MatchString(hex) || len(hex) != 6 && len(hex) != 8 {
 		return color, errors.New("invalid hex color")
 	}
 
-	if len(hex) == 6 {
-		v, err := strconv.ParseInt(hex[0:2], 16, 32)
+	var c [4]float64
+	c[3] = 1
+	for i := 0; i < len(hex)/2; i++ {
+		v, err := strconv.ParseUint(hex[i*2:i*2+2], 16, 8)
 		if err != nil {
 			return color, err
 		}
-		r := float64(v) / 255.0
-		v, err = strconv.ParseInt(hex[2:4], 16, 32)
-		if err != nil {
-			return color, err
+		c[i] = float64(v) / 255.0
+	}
+	color.H, color.S, color.L = rgbToHsl(c[0], c[1], c[2])
+	color.A = c[3]
+	return color, nil
+}
+
+var funcRe = regexp.MustCompile(`^(rgba?|hsla?|hwb|lab|lch|oklab|oklch)\((.*)\)$`)
+
+// parseFunction parses the arguments of the functional notations, eg. rgb()
+// or oklch(). Arguments are separated by commas, or by spaces with an
+// optional alpha value after a slash, eg. rgb(255 0 0 / 50%). Like in CSS
+// Color Level 4, numbers are percentages for the saturation, lightness,
+// whiteness and blackness in the space separated form, eg. hsl(120 50 50).
+func parseFunction(name, args string) (Color, error) {
+	var parts []string
+	commas := strings.Contains(args, ",")
+	if commas {
+		parts = strings.Split(args, ",")
+	} else if i := strings.IndexByte(args, '/'); i >= 0 {
+		alpha := strings.TrimSpace(args[i+1:])
+		if alpha == "" {
+			return Color{}, fmt.Errorf("missing %s alpha value after /", name)
+		}
+		parts = append(strings.Fields(args[:i]), alpha)
+	} else {
+		parts = strings.Fields(args)
+		// the alpha value requires a slash in the space separated form
+		if len(parts) > 3 {
+			return Color{}, fmt.Errorf("%s requires / before alpha value", name)
+		}
+	}
+
+	base := strings.TrimSuffix(name, "a")
+	channels := make([]Channel, len(parts))
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		var unit string
+		var err error
+		if n := strings.IndexFunc(p, func(r rune) bool {
+			return r != '-' && r != '+' && r != '.' && (r < '0' || r > '9')
+		}); n >= 0 {
+			channels[i].Value, err = strconv.ParseFloat(p[:n], 64)
+			unit = p[n:]
+		} else {
+			channels[i].Value, err = strconv.ParseFloat(p, 64)
 		}
-		g := float64(v) / 255.0
-		v, err = strconv.ParseInt(hex[4:6], 16, 32)
 		if err != nil {
-			return color, err
+			return Color{}, fmt.Errorf("invalid %s argument %q", name, p)
+		}
+		switch {
+		case unit == "%":
+			channels[i].Percent = true
+		case unit == "" && !commas && (base == "hsl" || base == "hwb") && (i == 1 || i == 2):
+			channels[i].Percent = true
+		case unit != "":
+			deg, ok := Degrees(channels[i].Value, unit)
+			if !ok || i >= 3 || !functionChannels[base][i].hue {
+				return Color{}, fmt.Errorf("invalid %s argument %q", name, p)
+			}
+			channels[i].Value = deg
 		}
-		b := float64(v) / 255.0
+	}
+	return FromFunction(name, channels)
+}
 
-		color.H, color.S, color.L = rgbToHsl(r, g, b)
-	} else {
-		return color, errors.New("hex color not 3 or 6 chars long")
+// Channel is a numeric argument of a color function.
+type Channel struct {
+	Value   float64
+	Percent bool
+}
+
+// channel describes the range of an argument of a color function.
+type channel struct {
+	num     float64 // scale of numbers
+	percent float64 // scale of percentages, 0 if percentages are invalid
+	hue     bool    // hue in degrees, angle units are allowed
+	clamp   bool    // clamp to 0.0-1.0 after scaling
+}
+
+// functionChannels contains the color and hue arguments of all color
+// functions. Saturation, lightness, whiteness and blackness are numbers from
+// 0.0-1.0, like in the MSS expressions. The other ranges are from CSS Color
+// Level 4, eg. 100% of the chroma of lch is 150.
+var functionChannels = map[string][3]channel{
+	"rgb":   {{1.0 / 255, 0.01, false, true}, {1.0 / 255, 0.01, false, true}, {1.0 / 255, 0.01, false, true}},
+	"hsl":   {{1, 0, true, false}, {1, 0.01, false, true}, {1, 0.01, false, true}},
+	"hwb":   {{1, 0, true, false}, {1, 0.01, false, true}, {1, 0.01, false, true}},
+	"lab":   {{1, 1, false, false}, {1, 1.25, false, false}, {1, 1.25, false, false}},
+	"lch":   {{1, 1, false, false}, {1, 1.5, false, false}, {1, 0, true, false}},
+	"oklab": {{1, 0.01, false, false}, {1, 0.004, false, false}, {1, 0.004, false, false}},
+	"oklch": {{1, 0.01, false, false}, {1, 0.004, false, false}, {1, 0, true, false}},
+}
+
+// FromFunction returns the color for the arguments of the CSS color function
+// name (rgb, rgba, hsl, hsla, hwb, lab, lch, oklab or oklch). Angles need to be
+// converted to degrees (see Degrees). The optional fourth argument is the
+// alpha value, as number from 0.0-1.0 or as percentage.
+func FromFunction(name string, args []Channel) (Color, error) {
+	fn := strings.TrimSuffix(name, "a") // rgba and hsla are aliases
+	chans, ok := functionChannels[fn]
+	if !ok {
+		return Color{}, fmt.Errorf("unknown color function %s", name)
+	}
+	if len(args) != 3 && len(args) != 4 {
+		return Color{}, fmt.Errorf("%s takes three or four arguments, got %d", name, len(args))
+	}
+
+	c := [4]float64{0, 0, 0, 1}
+	for i, arg := range args {
+		v := arg.Value
+		if i == 3 {
+			if arg.Percent {
+				v /= 100
+			}
+			c[i] = clamp(v)
+			continue
+		}
+		ch := chans[i]
+		if arg.Percent {
+			if ch.percent == 0 {
+				return Color{}, fmt.Errorf("%s does not accept percentage as argument %d", name, i+1)
+			}
+			v *= ch.percent
+		} else {
+			v *= ch.num
+		}
+		if ch.clamp {
+			v = clamp(v)
+		}
+		c[i] = v
+	}
+
+	switch fn {
+	case "rgb":
+		return FromRgba(c[0], c[1], c[2], c[3], false), nil
+	case "hwb":
+		return FromHwba(c[0], c[1], c[2], c[3]), nil
+	case "lab":
+		return FromLab(c[0], c[1], c[2], c[3]), nil
+	case "lch":
+		return FromLch(c[0], c[1], c[2], c[3]), nil
+	case "oklab":
+		return FromOklab(c[0], c[1], c[2], c[3]), nil
+	case "oklch":
+		return FromOklch(c[0], c[1], c[2], c[3]), nil
+	default:
+		return FromHsla(c[0], c[1], c[2], c[3]), nil
 	}
-	return color, nil
 }
 
 func MustParse(hex string) Color {
@@ -46,6 +46,43 @@ func TestParseColor(t *testing.T) {
 	}
 }
 
+func TestParseColorLevel4(t *testing.T) {
+	for _, tt := range []struct {
+		color string
+		want  string
+	}{
+		{"#f638", "rgba(255, 102, 51, 0.53333)"},
+		{"#ff663380", "rgba(255, 102, 51, 0.50196)"},
+		{"#FF6633FF", "#ff6633"},
+		{"rgb(255, 102, 51)", "#ff6633"},
+		{"rgba(255, 102, 51, 0.5)", "rgba(255, 102, 51, 0.50000)"},
+		{"rgb(255 0 0 / 50%)", "rgba(255, 0, 0, 0.50000)"},
+		{"rgb(100% 40% 20%)", "#ff6633"},
+		{"hsl(120deg 50% 50% / .5)", "rgba(64, 191, 64, 0.50000)"},
+		{"hsl(0.5turn 100% 50%)", "#00ffff"},
+		{"hsla(240, 100%, 50%, 1)", "#0000ff"},
+		{"hwb(0 0% 0%)", "#ff0000"},
+		{"hwb(120deg 20% 40% / 0.25)", "rgba(51, 153, 51, 0.25000)"},
+		{"hwb(0 60% 60%)", "#808080"},
+		{"hsl(120 50 50)", "#40bf40"},
+		{"hsl(120, 0.5, 0.5)", "#40bf40"},
+		{"hwb(120 20 40 / 0.25)", "rgba(51, 153, 51, 0.25000)"},
+	} {
+		c, err := Parse(tt.color)
+		if assert.NoError(t, err, tt.color) {
+			assert.Equal(t, tt.want, c.String(), tt.color)
+		}
+	}
+
+	for _, color := range []string{
+		"#ff66338", "#ff66zz", "rgb(1 2)", "rgb(1, 2, 3, 4, 5)", "rgb(10deg 0 0)", "hsl(120 50% 50px)", "hwb(a b c)",
+		"rgb(1 2 3 4)", "lab(50 0 0 /)", "rgb(1 2 3 4 / 0.5)",
+	} {
+		_, err := Parse(color)
+		assert.Error(t, err, color)
+	}
+}
+
 func TestColorString(t *testing.T) {
 	assert.Equal(t, "#ff6633", Color{15.0, 1.0, 0.6, 1.0, false}.String())
 	assert.Equal(t, "#fa623d", Color{11.71, 0.95, 0.61, 1.0, false}.String())
@@ -66,7 +103,7 @@ func TestMustParseColor(t *testing.T) {
 		MustParse("")
 	})
 	assert.Panics(t, func() {
-		MustParse("#ff66")
+		MustParse("#ff663")
 	})
 	assert.Panics(t, func() {
 		MustParse("#ff6633f")
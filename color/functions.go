@@ -28,6 +28,28 @@ func DarkenP(c Color, v float64) Color {
 	return Darken(c, v)
 }
 
+// LightenLab increases the CIE Lab lightness by v*100.
+func LightenLab(c Color, v float64) Color {
+	l, a, b := c.ToLab()
+	return FromLab(math.Max(math.Min(l+v*100, 100), 0), a, b, c.A)
+}
+
+// DarkenLab decreases the CIE Lab lightness by v*100.
+func DarkenLab(c Color, v float64) Color {
+	return LightenLab(c, -v)
+}
+
+// LightenOklab increases the OKLab lightness by v.
+func LightenOklab(c Color, v float64) Color {
+	l, a, b := c.ToOklab()
+	return FromOklab(clamp(l+v), a, b, c.A)
+}
+
+// DarkenOklab decreases the OKLab lightness by v.
+func DarkenOklab(c Color, v float64) Color {
+	return LightenOklab(c, -v)
+}
+
 func Saturate(c Color, v float64) Color {
 	c.S += v
 	c.S = clamp(c.S)
@@ -169,6 +191,60 @@ func Mix(c1, c2 Color, weight float64) Color {
 		perceptual)
 }
 
+// MixLab mixes c1 and c2 in the CIE Lab color space. weight is the proportion
+// of c1, like in Mix.
+func MixLab(c1, c2 Color, weight float64) Color {
+	l1, a1, b1 := c1.ToLab()
+	l2, a2, b2 := c2.ToLab()
+	return FromLab(lerp(l1, l2, weight), lerp(a1, a2, weight), lerp(b1, b2, weight), lerp(c1.A, c2.A, weight))
+}
+
+// MixLch mixes c1 and c2 in the CIE LCh color space, along the shorter arc
+// of the hue.
+func MixLch(c1, c2 Color, weight float64) Color {
+	l1, ch1, h1 := c1.ToLch()
+	l2, ch2, h2 := c2.ToLch()
+	return FromLch(lerp(l1, l2, weight), lerp(ch1, ch2, weight), lerpHue(h1, ch1, h2, ch2, weight, 0.01), lerp(c1.A, c2.A, weight))
+}
+
+// MixOklab mixes c1 and c2 in the OKLab color space.
+func MixOklab(c1, c2 Color, weight float64) Color {
+	l1, a1, b1 := c1.ToOklab()
+	l2, a2, b2 := c2.ToOklab()
+	return FromOklab(lerp(l1, l2, weight), lerp(a1, a2, weight), lerp(b1, b2, weight), lerp(c1.A, c2.A, weight))
+}
+
+// MixOklch mixes c1 and c2 in the OKLCh color space, along the shorter arc
+// of the hue.
+func MixOklch(c1, c2 Color, weight float64) Color {
+	l1, ch1, h1 := c1.ToOklch()
+	l2, ch2, h2 := c2.ToOklch()
+	return FromOklch(lerp(l1, l2, weight), lerp(ch1, ch2, weight), lerpHue(h1, ch1, h2, ch2, weight, 0.0001), lerp(c1.A, c2.A, weight))
+}
+
+// lerp returns a*weight + b*(1-weight).
+func lerp(a, b, weight float64) float64 {
+	return a*weight + b*(1-weight)
+}
+
+// lerpHue interpolates the hues along the shorter arc. The hue of colors with
+// a chroma below achromatic is ignored.
+func lerpHue(h1, c1, h2, c2, weight, achromatic float64) float64 {
+	if c1 < achromatic {
+		return h2
+	}
+	if c2 < achromatic {
+		return h1
+	}
+	d := h1 - h2
+	if d > 180 {
+		d -= 360
+	} else if d < -180 {
+		d += 360
+	}
+	return math.Mod(h2+d*weight+360, 360)
+}
+
 func SetHue(c, hue Color) Color {
 	base := c.ToPerceptual()
 	base.H = hue.ToPerceptual().H
@@ -0,0 +1,165 @@
+package color
+
+import "math"
+
+// Lab and LCh use the D50 white point and OKLab and OKLCh the D65 white point,
+// like the lab(), lch(), oklab() and oklch() functions of CSS Color Level 4.
+// Colors outside of the sRGB gamut are clipped.
+
+var d50White = [3]float64{0.3457 / 0.3585, 1, (1 - 0.3457 - 0.3585) / 0.3585}
+
+const (
+	labEpsilon = 216.0 / 24389
+	labKappa   = 24389.0 / 27
+)
+
+// ToLab returns the CIE Lab values of the color. L is from 0-100, a and b are
+// roughly from -125-125.
+func (color Color) ToLab() (float64, float64, float64) {
+	x, y, z := xyzD65ToD50(linearRgbToXyz(color.toLinearRgb()))
+	f := func(t float64) float64 {
+		if t > labEpsilon {
+			return math.Cbrt(t)
+		}
+		return (labKappa*t + 16) / 116
+	}
+	fx, fy, fz := f(x/d50White[0]), f(y/d50White[1]), f(z/d50White[2])
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// FromLab returns the color for the CIE Lab values and alpha.
+func FromLab(l, a, b, alpha float64) Color {
+	fy := (l + 16) / 116
+	fx := a/500 + fy
+	fz := fy - b/200
+	f := func(t float64) float64 {
+		if t3 := t * t * t; t3 > labEpsilon {
+			return t3
+		}
+		return (116*t - 16) / labKappa
+	}
+	y := l / labKappa
+	if l > labKappa*labEpsilon {
+		y = fy * fy * fy
+	}
+	r, g, bl := xyzToLinearRgb(xyzD50ToD65(f(fx)*d50White[0], y*d50White[1], f(fz)*d50White[2]))
+	return fromLinearRgb(r, g, bl, alpha)
+}
+
+// ToLch returns the CIE LCh values of the color. L is from 0-100, C is
+// roughly from 0-150 and H is the hue in degrees.
+func (color Color) ToLch() (float64, float64, float64) {
+	return toPolar(color.ToLab())
+}
+
+// FromLch returns the color for the CIE LCh values and alpha.
+func FromLch(l, c, h, alpha float64) Color {
+	l, a, b := fromPolar(l, c, h)
+	return FromLab(l, a, b, alpha)
+}
+
+// ToOklab returns the OKLab values of the color. L is from 0.0-1.0, a and b
+// are roughly from -0.4-0.4.
+func (color Color) ToOklab() (float64, float64, float64) {
+	r, g, b := color.toLinearRgb()
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+	return 0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s
+}
+
+// FromOklab returns the color for the OKLab values and alpha.
+func FromOklab(l, a, b, alpha float64) Color {
+	lc := l + 0.3963377774*a + 0.2158037573*b
+	mc := l - 0.1055613458*a - 0.0638541728*b
+	sc := l - 0.0894841775*a - 1.2914855480*b
+	lc, mc, sc = lc*lc*lc, mc*mc*mc, sc*sc*sc
+	return fromLinearRgb(
+		4.0767416621*lc-3.3077115913*mc+0.2309699292*sc,
+		-1.2684380046*lc+2.6097574011*mc-0.3413193965*sc,
+		-0.0041960863*lc-0.7034186147*mc+1.7076147010*sc,
+		alpha,
+	)
+}
+
+// ToOklch returns the OKLCh values of the color. L is from 0.0-1.0, C is
+// roughly from 0.0-0.4 and H is the hue in degrees.
+func (color Color) ToOklch() (float64, float64, float64) {
+	return toPolar(color.ToOklab())
+}
+
+// FromOklch returns the color for the OKLCh values and alpha.
+func FromOklch(l, c, h, alpha float64) Color {
+	l, a, b := fromPolar(l, c, h)
+	return FromOklab(l, a, b, alpha)
+}
+
+func toPolar(l, a, b float64) (float64, float64, float64) {
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return l, math.Hypot(a, b), h
+}
+
+func fromPolar(l, c, h float64) (float64, float64, float64) {
+	if c < 0 {
+		c = 0
+	}
+	rad := h * math.Pi / 180
+	return l, c * math.Cos(rad), c * math.Sin(rad)
+}
+
+func (color Color) toLinearRgb() (float64, float64, float64) {
+	r, g, b := color.ToRgb()
+	return toLinear(r), toLinear(g), toLinear(b)
+}
+
+func fromLinearRgb(r, g, b, alpha float64) Color {
+	return FromRgba(fromLinear(r), fromLinear(g), fromLinear(b), alpha, false)
+}
+
+// toLinear removes the gamma of a sRGB channel.
+func toLinear(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// fromLinear applies the gamma of a sRGB channel and clips the result to the
+// sRGB gamut.
+func fromLinear(c float64) float64 {
+	c = clamp(c)
+	if c <= 0.0031308 {
+		return 12.92 * c
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+func linearRgbToXyz(r, g, b float64) (float64, float64, float64) {
+	return 0.41239079926595934*r + 0.357584339383878*g + 0.1804807884018343*b,
+		0.21263900587151027*r + 0.715168678767756*g + 0.07219231536073371*b,
+		0.01933081871559182*r + 0.11919477979462598*g + 0.9505321522496607*b
+}
+
+func xyzToLinearRgb(x, y, z float64) (float64, float64, float64) {
+	return 3.2409699419045226*x - 1.537383177570094*y - 0.4986107602930034*z,
+		-0.9692436362808796*x + 1.8759675015077202*y + 0.04155505740717559*z,
+		0.05563007969699366*x - 0.20397695888897652*y + 1.0569715142428786*z
+}
+
+// xyzD65ToD50 adapts the white point with the Bradford transform.
+func xyzD65ToD50(x, y, z float64) (float64, float64, float64) {
+	return 1.0479297925449969*x + 0.022946870601609652*y - 0.05019226628920524*z,
+		0.02962780877005599*x + 0.9904344267538799*y - 0.017073799063418826*z,
+		-0.009243040646204504*x + 0.015055191490298152*y + 0.7518742814281371*z
+}
+
+func xyzD50ToD65(x, y, z float64) (float64, float64, float64) {
+	return 0.955473421488075*x - 0.02309845494876471*y + 0.06325924320057072*z,
+		-0.0283697093338637*x + 1.0099953980813041*y + 0.021041441191917323*z,
+		0.012314014864481998*x - 0.020507649298898964*y + 1.330365926242124*z
+}
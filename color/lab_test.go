@@ -0,0 +1,91 @@
+package color
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLab(t *testing.T) {
+	l, a, b := MustParse("red").ToLab()
+	assert.InDelta(t, 54.29, l, 0.01)
+	assert.InDelta(t, 80.80, a, 0.01)
+	assert.InDelta(t, 69.89, b, 0.01)
+
+	l, c, h := MustParse("blue").ToLch()
+	assert.InDelta(t, 29.57, l, 0.01)
+	assert.InDelta(t, 131.20, c, 0.01)
+	assert.InDelta(t, 301.36, h, 0.01)
+
+	l, a, b = MustParse("white").ToLab()
+	assert.InDelta(t, 100, l, 1e-6)
+	assert.InDelta(t, 0, a, 1e-6)
+	assert.InDelta(t, 0, b, 1e-6)
+}
+
+func TestOklab(t *testing.T) {
+	l, a, b := MustParse("red").ToOklab()
+	assert.InDelta(t, 0.62796, l, 1e-5)
+	assert.InDelta(t, 0.22486, a, 1e-5)
+	assert.InDelta(t, 0.12585, b, 1e-5)
+
+	l, c, h := MustParse("lime").ToOklch()
+	assert.InDelta(t, 0.86644, l, 1e-5)
+	assert.InDelta(t, 0.29483, c, 1e-5)
+	assert.InDelta(t, 142.495, h, 1e-3)
+}
+
+func TestLabRoundTrip(t *testing.T) {
+	for _, hex := range []string{"#000000", "#ffffff", "#ff6633", "#123456", "#abcdef", "#00ff00", "#808080"} {
+		c := MustParse(hex)
+		l, a, b := c.ToLab()
+		assert.Equal(t, hex, FromLab(l, a, b, 1).String(), hex)
+		l, a, b = c.ToOklab()
+		assert.Equal(t, hex, FromOklab(l, a, b, 1).String(), hex)
+
+		l, ch, h := c.ToLch()
+		assert.Equal(t, hex, FromLch(l, ch, h, 1).String(), hex)
+		l, ch, h = c.ToOklch()
+		assert.Equal(t, hex, FromOklch(l, ch, h, 1).String(), hex)
+	}
+
+	// out of gamut colors are clipped
+	assert.Equal(t, "#00ff00", FromOklch(0.9, 0.4, 142.5, 1).String())
+}
+
+func TestParseLab(t *testing.T) {
+	for _, tt := range []struct {
+		color string
+		want  string
+	}{
+		{"lab(54.29 80.8 69.89)", "#ff0000"},
+		{"lab(54.29% 64.64% 55.91%)", "#ff0000"},
+		{"lch(29.57 131.2 301.36deg / 50%)", "rgba(0, 0, 255, 0.50000)"},
+		{"oklab(62.796% 0.22486 0.12585)", "#ff0000"},
+		{"oklch(0.62796 0.25768 29.23)", "#ff0000"},
+		{"oklch(62.796% 64.42% 0.0812turn)", "#ff0000"},
+	} {
+		c, err := Parse(tt.color)
+		if assert.NoError(t, err, tt.color) {
+			assert.Equal(t, tt.want, c.String(), tt.color)
+		}
+	}
+
+	for _, color := range []string{"lab(50 10deg 0)", "lch(50 10 10%)", "oklch(50%)"} {
+		_, err := Parse(color)
+		assert.Error(t, err, color)
+	}
+}
+
+func TestMixSpaces(t *testing.T) {
+	assert.Equal(t, "#636363", MixOklab(MustParse("white"), MustParse("black"), 0.5).String())
+	assert.Equal(t, "#777777", MixLab(MustParse("white"), MustParse("black"), 0.5).String())
+	assert.Equal(t, "#f99500", MixOklch(MustParse("red"), MustParse("lime"), 0.5).String())
+	// hue of achromatic colors is ignored
+	assert.Equal(t, MixOklab(MustParse("red"), MustParse("white"), 0.5).String(), MixOklch(MustParse("red"), MustParse("white"), 0.5).String())
+	assert.Equal(t, "#ff0000", MixLch(MustParse("red"), MustParse("blue"), 1).String())
+
+	assert.Equal(t, "#959595", LightenOklab(MustParse("#777"), 0.1).String())
+	assert.Equal(t, "#777777", DarkenOklab(LightenOklab(MustParse("#777"), 0.1), 0.1).String())
+	assert.Equal(t, "#000000", DarkenLab(MustParse("#777"), 1).String())
+}
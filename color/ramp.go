@@ -0,0 +1,119 @@
+package color
+
+import "sort"
+
+// Ramp returns n colors, evenly spaced along the anchors. The anchors are
+// evenly spaced as well, the first and last color are the first and last
+// anchor. mix interpolates between two anchors (eg. Mix or MixOklab), weight
+// is the proportion of c1.
+func Ramp(anchors []Color, n int, mix func(c1, c2 Color, weight float64) Color) []Color {
+	if len(anchors) == 0 || n <= 0 {
+		return nil
+	}
+	if len(anchors) == 1 || n == 1 {
+		result := make([]Color, n)
+		for i := range result {
+			result[i] = anchors[0]
+		}
+		return result
+	}
+	result := make([]Color, n)
+	segments := float64(len(anchors) - 1)
+	for i := range result {
+		t := float64(i) / float64(n-1) * segments
+		k := int(t)
+		if k >= len(anchors)-1 {
+			result[i] = anchors[len(anchors)-1]
+			continue
+		}
+		if t == float64(k) {
+			result[i] = anchors[k]
+			continue
+		}
+		result[i] = mix(anchors[k], anchors[k+1], 1-(t-float64(k)))
+	}
+	return result
+}
+
+// PaletteKind is the type of a named palette.
+type PaletteKind int
+
+const (
+	// Sequential palettes go from light to dark (or dark to light for
+	// viridis), for ordered values like elevation.
+	Sequential PaletteKind = iota + 1
+	// Diverging palettes have two dark ends and a light center, for values
+	// around a critical midpoint.
+	Diverging
+)
+
+func (k PaletteKind) String() string {
+	switch k {
+	case Sequential:
+		return "sequential"
+	case Diverging:
+		return "diverging"
+	default:
+		return "unknown"
+	}
+}
+
+// Palette is a named list of anchor colors for Ramp.
+type Palette struct {
+	Name   string
+	Kind   PaletteKind
+	Colors []Color
+}
+
+// palettes contains the ColorBrewer palettes (by Cynthia Brewer) and viridis.
+var palettes = map[string]Palette{}
+
+func init() {
+	for _, p := range []struct {
+		name   string
+		kind   PaletteKind
+		colors []string
+	}{
+		{"blues", Sequential, []string{"#f7fbff", "#deebf7", "#c6dbef", "#9ecae1", "#6baed6", "#4292c6", "#2171b5", "#08519c", "#08306b"}},
+		{"greens", Sequential, []string{"#f7fcf5", "#e5f5e0", "#c7e9c0", "#a1d99b", "#74c476", "#41ab5d", "#238b45", "#006d2c", "#00441b"}},
+		{"greys", Sequential, []string{"#ffffff", "#f0f0f0", "#d9d9d9", "#bdbdbd", "#969696", "#737373", "#525252", "#252525", "#000000"}},
+		{"oranges", Sequential, []string{"#fff5eb", "#fee6ce", "#fdd0a2", "#fdae6b", "#fd8d3c", "#f16913", "#d94801", "#a63603", "#7f2704"}},
+		{"purples", Sequential, []string{"#fcfbfd", "#efedf5", "#dadaeb", "#bcbddc", "#9e9ac8", "#807dba", "#6a51a3", "#54278f", "#3f007d"}},
+		{"reds", Sequential, []string{"#fff5f0", "#fee0d2", "#fcbba1", "#fc9272", "#fb6a4a", "#ef3b2c", "#cb181d", "#a50f15", "#67000d"}},
+		{"ylgn", Sequential, []string{"#ffffe5", "#f7fcb9", "#d9f0a3", "#addd8e", "#78c679", "#41ab5d", "#238443", "#006837", "#004529"}},
+		{"ylorbr", Sequential, []string{"#ffffe5", "#fff7bc", "#fee391", "#fec44f", "#fe9929", "#ec7014", "#cc4c02", "#993404", "#662506"}},
+		{"viridis", Sequential, []string{"#440154", "#472d7b", "#3b528b", "#2c728e", "#21918c", "#28ae80", "#5ec962", "#addc30", "#fde725"}},
+		{"brbg", Diverging, []string{"#543005", "#8c510a", "#bf812d", "#dfc27d", "#f6e8c3", "#f5f5f5", "#c7eae5", "#80cdc1", "#35978f", "#01665e", "#003c30"}},
+		{"piyg", Diverging, []string{"#8e0152", "#c51b7d", "#de77ae", "#f1b6da", "#fde0ef", "#f7f7f7", "#e6f5d0", "#b8e186", "#7fbc41", "#4d9221", "#276419"}},
+		{"rdbu", Diverging, []string{"#67001f", "#b2182b", "#d6604d", "#f4a582", "#fddbc7", "#f7f7f7", "#d1e5f0", "#92c5de", "#4393c3", "#2166ac", "#053061"}},
+		{"rdylgn", Diverging, []string{"#a50026", "#d73027", "#f46d43", "#fdae61", "#fee08b", "#ffffbf", "#d9ef8b", "#a6d96a", "#66bd63", "#1a9850", "#006837"}},
+		{"spectral", Diverging, []string{"#9e0142", "#d53e4f", "#f46d43", "#fdae61", "#fee08b", "#ffffbf", "#e6f598", "#abdda4", "#66c2a5", "#3288bd", "#5e4fa2"}},
+	} {
+		colors := make([]Color, len(p.colors))
+		for i, c := range p.colors {
+			colors[i] = MustParse(c)
+		}
+		palettes[p.name] = Palette{Name: p.name, Kind: p.kind, Colors: colors}
+	}
+}
+
+// NamedPalette returns the palette with the name, eg. blues or rdbu.
+func NamedPalette(name string) (Palette, bool) {
+	p, ok := palettes[name]
+	return p, ok
+}
+
+// Palettes returns all named palettes, ordered by kind and name.
+func Palettes() []Palette {
+	result := make([]Palette, 0, len(palettes))
+	for _, p := range palettes {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,50 @@
+package color
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hexColors(colors []Color) []string {
+	var result []string
+	for _, c := range colors {
+		result = append(result, c.String())
+	}
+	return result
+}
+
+func TestRamp(t *testing.T) {
+	bw := []Color{MustParse("white"), MustParse("black")}
+	assert.Equal(t, []string{"#ffffff", "#808080", "#000000"}, hexColors(Ramp(bw, 3, Mix)))
+	assert.Equal(t, []string{"#ffffff", "#636363", "#000000"}, hexColors(Ramp(bw, 3, MixOklab)))
+
+	// anchors are kept if the number of colors matches
+	rgb := []Color{MustParse("red"), MustParse("lime"), MustParse("blue")}
+	assert.Equal(t, []string{"#ff0000", "#00ff00", "#0000ff"}, hexColors(Ramp(rgb, 3, MixOklab)))
+	assert.Equal(t, []string{"#ff0000", "#7f8000", "#00ff00", "#007f80", "#0000ff"}, hexColors(Ramp(rgb, 5, Mix)))
+
+	assert.Equal(t, []string{"#ff0000", "#ff0000"}, hexColors(Ramp(rgb[:1], 2, Mix)))
+	assert.Equal(t, []string{"#ff0000"}, hexColors(Ramp(rgb, 1, Mix)))
+	assert.Len(t, Ramp(nil, 3, Mix), 0)
+	assert.Len(t, Ramp(rgb, 0, Mix), 0)
+}
+
+func TestPalettes(t *testing.T) {
+	p, ok := NamedPalette("rdbu")
+	assert.True(t, ok)
+	assert.Equal(t, Diverging, p.Kind)
+	assert.Equal(t, "#67001f", p.Colors[0].String())
+	assert.Len(t, p.Colors, 11)
+
+	_, ok = NamedPalette("unknown")
+	assert.False(t, ok)
+
+	var names []string
+	for _, p := range Palettes() {
+		names = append(names, p.Kind.String()+" "+p.Name)
+	}
+	assert.Equal(t, "sequential blues", names[0])
+	assert.Equal(t, "diverging brbg", names[9])
+	assert.Len(t, names, 14)
+}
@@ -0,0 +1,107 @@
+package color
+
+import "math"
+
+// Luminance returns the relative luminance of the color as defined by WCAG,
+// from 0.0 for black to 1.0 for white. The alpha value is ignored.
+func (color Color) Luminance() float64 {
+	r, g, b := color.toLinearRgb()
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
+// Contrast returns the WCAG contrast ratio of the colors, from 1 for equal
+// luminance to 21 for black and white.
+func Contrast(c1, c2 Color) float64 {
+	l1, l2 := c1.Luminance(), c2.Luminance()
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
+// Blend returns the color c painted over the background. The alpha value of
+// the background is ignored.
+func Blend(c, background Color) Color {
+	if c.A >= 1 {
+		return c
+	}
+	r1, g1, b1 := c.ToRgb()
+	r2, g2, b2 := background.ToRgb()
+	return FromRgba(
+		r1*c.A+r2*(1-c.A),
+		g1*c.A+g2*(1-c.A),
+		b1*c.A+b2*(1-c.A),
+		1, false)
+}
+
+// Distance returns the perceptual difference of the colors, as euclidean
+// distance in the OKLab color space. Colors with a distance below 0.02 are
+// hardly distinguishable. The alpha value is ignored.
+func Distance(c1, c2 Color) float64 {
+	l1, a1, b1 := c1.ToOklab()
+	l2, a2, b2 := c2.ToOklab()
+	return math.Sqrt((l1-l2)*(l1-l2) + (a1-a2)*(a1-a2) + (b1-b2)*(b1-b2))
+}
+
+// Deficiency is a type of color vision deficiency.
+type Deficiency int
+
+const (
+	// Protanopia is the absence of red cones.
+	Protanopia Deficiency = iota + 1
+	// Deuteranopia is the absence of green cones.
+	Deuteranopia
+	// Tritanopia is the absence of blue cones.
+	Tritanopia
+)
+
+// Deficiencies contains all simulated color vision deficiencies.
+var Deficiencies = []Deficiency{Protanopia, Deuteranopia, Tritanopia}
+
+func (d Deficiency) String() string {
+	switch d {
+	case Protanopia:
+		return "protanopia"
+	case Deuteranopia:
+		return "deuteranopia"
+	case Tritanopia:
+		return "tritanopia"
+	default:
+		return "unknown"
+	}
+}
+
+// deficiencyMatrices are the linear RGB transformations of Machado, Oliveira
+// and Fernandes (2009) for a severity of 1.0.
+var deficiencyMatrices = map[Deficiency][3][3]float64{
+	Protanopia: {
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	},
+	Deuteranopia: {
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	},
+	Tritanopia: {
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	},
+}
+
+// Simulate returns the color as seen with the color vision deficiency d.
+func Simulate(c Color, d Deficiency) Color {
+	m, ok := deficiencyMatrices[d]
+	if !ok {
+		return c
+	}
+	r, g, b := c.toLinearRgb()
+	return fromLinearRgb(
+		m[0][0]*r+m[0][1]*g+m[0][2]*b,
+		m[1][0]*r+m[1][1]*g+m[1][2]*b,
+		m[2][0]*r+m[2][1]*g+m[2][2]*b,
+		c.A,
+	)
+}
@@ -0,0 +1,42 @@
+package color
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContrast(t *testing.T) {
+	assert.InDelta(t, 21, Contrast(MustParse("black"), MustParse("white")), 1e-9)
+	assert.InDelta(t, 21, Contrast(MustParse("white"), MustParse("black")), 1e-9)
+	assert.InDelta(t, 1, Contrast(MustParse("red"), MustParse("red")), 1e-9)
+	assert.InDelta(t, 4.48, Contrast(MustParse("#777"), MustParse("white")), 0.01)
+	assert.InDelta(t, 0.2126, MustParse("red").Luminance(), 1e-9)
+}
+
+func TestBlend(t *testing.T) {
+	assert.Equal(t, "#808080", Blend(MustParse("rgba(0, 0, 0, 0.5)"), MustParse("white")).String())
+	assert.Equal(t, "#ff0000", Blend(MustParse("red"), MustParse("white")).String())
+}
+
+func TestDistance(t *testing.T) {
+	assert.InDelta(t, 0, Distance(MustParse("red"), MustParse("#ff0000")), 1e-9)
+	assert.InDelta(t, 1, Distance(MustParse("black"), MustParse("white")), 1e-6)
+	assert.Less(t, Distance(MustParse("#777"), MustParse("#787878")), 0.02)
+}
+
+func TestSimulate(t *testing.T) {
+	for _, d := range Deficiencies {
+		// greys are not affected
+		assert.Equal(t, "#808080", Simulate(MustParse("#808080"), d).String(), d.String())
+	}
+	// red and green are hard to distinguish with protanopia and deuteranopia,
+	// but not with tritanopia
+	red, green := MustParse("#d62728"), MustParse("#2ca02c")
+	assert.Greater(t, Distance(red, green), 0.2)
+	assert.Less(t, Distance(Simulate(red, Deuteranopia), Simulate(green, Deuteranopia)), 0.1)
+	assert.Greater(t, Distance(Simulate(red, Tritanopia), Simulate(green, Tritanopia)), 0.2)
+
+	assert.Equal(t, 0.5, Simulate(MustParse("rgba(255, 0, 0, 0.5)"), Protanopia).A)
+	assert.Equal(t, "unknown", Deficiency(0).String())
+}
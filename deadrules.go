@@ -0,0 +1,234 @@
+package cartocss
+
+import (
+	"math"
+	"sort"
+)
+
+// DeadRuleReason describes why a rule can never apply.
+type DeadRuleReason int
+
+const (
+	// ShadowedRule is a rule where all properties are overridden by rules
+	// with a higher specificity that match all features of the rule.
+	ShadowedRule DeadRuleReason = iota + 1
+	// ContradictingFilters is a selector with filters that can not match at
+	// the same time, eg. [a=1][a=2] or #foo[a=1] { [a=2] { ... } }.
+	ContradictingFilters
+	// EmptyZoom is a selector with zoom conditions without a common level,
+	// eg. [zoom>10][zoom<5].
+	EmptyZoom
+)
+
+func (r DeadRuleReason) String() string {
+	switch r {
+	case ShadowedRule:
+		return "shadowed rule"
+	case ContradictingFilters:
+		return "contradicting filters"
+	case EmptyZoom:
+		return "empty zoom range"
+	default:
+		return "unknown"
+	}
+}
+
+// DeadRule is a rule that can never apply.
+type DeadRule struct {
+	Reason DeadRuleReason
+	// Range of the selector of the rule.
+	Range Range
+	// Related contains the ranges of the selectors that are responsible,
+	// eg. the parent selectors with the contradicting filters or the
+	// selectors of the rules that override all properties.
+	Related []Range
+}
+
+// DeadRules returns all rules for this layer that can never apply, ordered by
+// their position. Call Decoder.Evaluate first.
+//
+// Rules are shadowed if other rules with a higher specificity define the same
+// properties, and if each of these rules matches all features and zoom
+// levels of the shadowed rule. Rules that are only shadowed by a combination
+// of multiple rules are not reported.
+func (m *MSS) DeadRules(layer string, classes ...string) []DeadRule {
+	var result []DeadRule
+	seen := map[Range]struct{}{}
+	add := func(d DeadRule) {
+		if _, ok := seen[d.Range]; ok {
+			return
+		}
+		seen[d.Range] = struct{}{}
+		result = append(result, d)
+	}
+
+	rules, _ := m.collectRules(layer, InvalidZoom, classes, add)
+	for i := range rules {
+		if related, ok := shadowedBy(rules[i], rules); ok {
+			add(DeadRule{
+				Reason:  ShadowedRule,
+				Range:   rules[i].selectors[len(rules[i].selectors)-1].pos.Range(),
+				Related: related,
+			})
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Range.Start.Before(result[j].Range.Start)
+	})
+	return result
+}
+
+// shadowedBy returns the selector ranges of all rules that override the
+// properties of r, if all properties are overridden.
+func shadowedBy(r Rule, rules []Rule) ([]Range, bool) {
+	var related []Range
+	added := map[Range]struct{}{}
+	for k, a := range r.Properties.values {
+		shadowed := false
+		for _, o := range rules {
+			if o.order == r.order || !o.covers(r) {
+				continue
+			}
+			oa, ok := o.Properties.values[k]
+			if !ok || !a.specificity.less(oa.specificity) {
+				continue
+			}
+			shadowed = true
+			pos := o.selectors[len(o.selectors)-1].pos.Range()
+			if _, ok := added[pos]; !ok {
+				added[pos] = struct{}{}
+				related = append(related, pos)
+			}
+			break
+		}
+		if !shadowed {
+			return nil, false
+		}
+	}
+	return related, len(related) > 0
+}
+
+// covers returns whether r applies to all features and zoom levels of o.
+func (r Rule) covers(o Rule) bool {
+	return r.Layer == o.Layer &&
+		r.Attachment == o.Attachment &&
+		containsClasses(o.Classes, r.Classes) &&
+		o.Zoom&^r.Zoom == 0 &&
+		filterIsSubset(r.Filters, o.Filters)
+}
+
+// contradictingFilters checks whether the filters of s contradict each
+// other or the filters of the parent selectors.
+func contradictingFilters(s *Selector, parents []*Selector) (DeadRule, bool) {
+	d := DeadRule{Reason: ContradictingFilters, Range: s.pos.Range()}
+	for i, a := range s.Filters {
+		for _, b := range s.Filters[i+1:] {
+			if filterDisjoint(a, b) {
+				return d, true
+			}
+		}
+	}
+	for _, p := range parents {
+		if filtersDisjoint(s.Filters, p.Filters) {
+			d.Related = append(d.Related, p.pos.Range())
+		}
+	}
+	return d, len(d.Related) > 0
+}
+
+// emptyZoom returns the dead rule for s, with all parent selectors with
+// zoom conditions.
+func emptyZoom(s *Selector, parents []*Selector) DeadRule {
+	d := DeadRule{Reason: EmptyZoom, Range: s.pos.Range()}
+	for _, p := range parents {
+		if p.Zoom != AllZoom {
+			d.Related = append(d.Related, p.pos.Range())
+		}
+	}
+	return d
+}
+
+// filtersDisjoint returns whether any filter of a contradicts a filter of b.
+func filtersDisjoint(a, b []Filter) bool {
+	for _, fa := range a {
+		for _, fb := range b {
+			if filterDisjoint(fa, fb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterDisjoint returns whether no value matches both filters, eg. a=1 and
+// a=2, or a<5 and a>10. Returns false if this can not be determined.
+func filterDisjoint(a, b Filter) bool {
+	if a.Field != b.Field {
+		return false
+	}
+	if values, ok := finiteValues(a); ok {
+		return noneMatch(values, b)
+	}
+	if values, ok := finiteValues(b); ok {
+		return noneMatch(values, a)
+	}
+	alo, ahi, aok := filterInterval(a)
+	blo, bhi, bok := filterInterval(b)
+	if !aok || !bok {
+		return false
+	}
+	lo, hi := alo.max(blo), ahi.min(bhi)
+	return lo.v > hi.v || lo.v == hi.v && (!lo.incl || !hi.incl)
+}
+
+// noneMatch returns whether none of the values matches f.
+func noneMatch(values []Value, f Filter) bool {
+	for _, v := range values {
+		if match, ok := filterMatch(f, v); !ok || match {
+			return false
+		}
+	}
+	return true
+}
+
+type bound struct {
+	v    float64
+	incl bool
+}
+
+func (b bound) max(o bound) bound {
+	if o.v > b.v || o.v == b.v && !o.incl {
+		return o
+	}
+	return b
+}
+
+func (b bound) min(o bound) bound {
+	if o.v < b.v || o.v == b.v && !o.incl {
+		return o
+	}
+	return b
+}
+
+// filterInterval returns the lower and upper bound of numeric comparisons.
+func filterInterval(f Filter) (lo, hi bound, ok bool) {
+	v, ok := f.Value.(float64)
+	if !ok {
+		return lo, hi, false
+	}
+	lo, hi = bound{v: math.Inf(-1)}, bound{v: math.Inf(1)}
+	switch f.CompOp {
+	case GT:
+		lo = bound{v, false}
+	case GTE:
+		lo = bound{v, true}
+	case LT:
+		hi = bound{v, false}
+	case LTE:
+		hi = bound{v, true}
+	default:
+		return lo, hi, false
+	}
+	return lo, hi, true
+}
@@ -3,11 +3,12 @@ package cartocss
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
-
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/flywave/go-cartocss/color"
 )
@@ -21,40 +22,184 @@ type Decoder struct {
 	scanner       *scanner
 	nextTok       *token
 	lastTok       *token
+	prevTok       *token
 	expr          *expression
 	lastValue     Value
-	warnings      []warning
+	warnings      []Warning
+	usedVars      map[string]struct{}
+	errors        ParseErrors
 	filename      string // for warnings/errors only
 	filesParsed   int
 	propertyIndex int
-}
-
-type warning struct {
-	line, col int
-	file      string
-	msg       string
+	files         []string
+	parsedFiles   map[string]struct{}
+	importStack   []string
+	funcs         map[string]Function
+	overrides     map[string]Value
+	sheets        []*Stylesheet
+	sheet         *Stylesheet
+	blocks        []*Block
+	record        []*token // tokens of the current top level statement
+	lastExpr      *Expr
+	skipImports   bool                  // for Format
+	leading       map[*token][]*Comment // comments before each token
+	specVersion   SpecVersion           // target Mapnik version, zero for all versions
+	angle         bool                  // parsing the hue of a color function
 }
 
 type position struct {
-	line     int
-	column   int
-	filename string
-	filenum  int
-	index    int
+	line      int
+	column    int
+	endLine   int
+	endColumn int
+	filename  string
+	filenum   int
+	index     int
 }
 
-func (w *warning) String() string {
-	file := w.file
-	if file == "" {
-		file = "?"
+// Range returns the exported range of this position. The end equals the
+// start for positions without end.
+func (p position) Range() Range {
+	r := Range{
+		Start: Position{Filename: p.filename, Line: p.line, Column: p.column},
+		End:   Position{Filename: p.filename, Line: p.endLine, Column: p.endColumn},
 	}
-	return fmt.Sprintf("%s in %s line: %d col: %d", w.msg, file, w.line, w.col)
+	if p.endLine == 0 {
+		r.End = r.Start
+	}
+	return r
 }
 
 // New will allocate a new MSS Decoder
-func NewDecoder() *Decoder {
+func NewDecoder(opts ...DecoderOption) *Decoder {
 	mss := newMSS()
-	return &Decoder{mss: mss, vars: &Properties{}, expr: &expression{}}
+	d := &Decoder{
+		mss:         mss,
+		vars:        &Properties{},
+		expr:        &expression{},
+		usedVars:    map[string]struct{}{},
+		parsedFiles: map[string]struct{}{},
+		leading:     map[*token][]*Comment{},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// DecoderOption configures a Decoder, see NewDecoder.
+type DecoderOption func(*Decoder)
+
+// WithSpecVersion sets the target Mapnik version (see ParseSpecVersion).
+// Properties that were added in later versions are reported as warnings with
+// the UnsupportedProperty code. All properties are supported by default.
+func WithSpecVersion(version SpecVersion) DecoderOption {
+	return func(d *Decoder) {
+		d.specVersion = version
+	}
+}
+
+// overrideValue converts integers and color strings of a variable override,
+// see SetVar.
+func overrideValue(v Value) Value {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case string:
+		if strings.HasPrefix(v, "#") || strings.HasSuffix(v, ")") {
+			if c, err := color.Parse(v); err == nil {
+				return c
+			}
+		}
+	}
+	return v
+}
+
+// supportedProperty returns whether the property is supported by the target
+// version, and the version that added the property.
+func (d *Decoder) supportedProperty(property string) (bool, SpecVersion) {
+	if d.specVersion == (SpecVersion{}) {
+		return true, SpecVersion{}
+	}
+	return supportedProperty(property, d.specVersion)
+}
+
+// SetVar overrides the variable name (without @) with the value for the next
+// Evaluate call. The override replaces the definition from all stylesheets
+// and it defines the variable if no stylesheet does. The value needs to be a
+// float64, string, bool, color.Color or []Value.
+//
+// Values from JSON or YAML configurations are converted: integers to float64,
+// []interface{} to []Value and strings with a hex color (eg. "#ff9933") or a
+// color function (eg. "rgb(255, 153, 51)") to color.Color.
+func (d *Decoder) SetVar(name string, v Value) error {
+	var l []Value
+	switch v := v.(type) {
+	case []Value:
+		l = v
+	case []interface{}:
+		l = make([]Value, len(v))
+		for i := range v {
+			l[i] = v[i]
+		}
+	}
+	if l != nil {
+		list := make([]Value, len(l))
+		for i, v := range l {
+			list[i] = overrideValue(v)
+			if valueType(list[i]) == typeUnknown {
+				return fmt.Errorf("unsupported type %T in list for var %s", v, name)
+			}
+		}
+		v = list
+	} else if v = overrideValue(v); valueType(v) == typeUnknown {
+		return fmt.Errorf("unsupported type %T for var %s", v, name)
+	}
+	if d.overrides == nil {
+		d.overrides = map[string]Value{}
+	}
+	d.overrides[name] = v
+	return nil
+}
+
+// RegisterFunction adds a custom function for all expressions. Functions need
+// to be registered before Evaluate is called. Returns an error if a function
+// with this name already exists.
+func (d *Decoder) RegisterFunction(name string, fn Function) error {
+	if fn.Fn == nil {
+		return fmt.Errorf("missing Fn for function %s", name)
+	}
+	if _, ok := d.funcs[name]; ok || isBuiltinFunction(name) {
+		return fmt.Errorf("function %s already defined", name)
+	}
+	if d.funcs == nil {
+		d.funcs = map[string]Function{}
+	}
+	d.funcs[name] = fn
+	return nil
+}
+
+// Warnings returns all warnings found so far, ordered by their position.
+// Warnings without a position (e.g. UnusedOverride) come first.
+// Call Evaluate first, as most warnings are only detected during evaluation.
+func (d *Decoder) Warnings() []Warning {
+	warnings := append([]Warning(nil), d.warnings...)
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Range.Start.Before(warnings[j].Range.Start)
+	})
+	return warnings
 }
 
 // MSS returns the current decoded style.
@@ -72,16 +217,37 @@ func (d *Decoder) next() *token {
 	if d.nextTok != nil {
 		tok := d.nextTok
 		d.nextTok = nil
+		d.prevTok = d.lastTok
 		d.lastTok = tok
+		d.record = append(d.record, tok)
 		return tok
 	}
+	var comments []*Comment
 	for {
 		tok := d.scanner.Next()
 		if tok.t == tokenError {
+			d.lastTok = tok
 			d.error(d.pos(tok), "%s", tok.value)
 		}
+		if tok.t == tokenComment {
+			c := d.addComment(tok)
+			// comments on the same line as the previous token are not
+			// attached to the following statement
+			prevLine := 0
+			if d.lastTok != nil {
+				prevLine, _ = d.lastTok.end()
+			}
+			if c.Range().Start.Line > prevLine {
+				comments = append(comments, c)
+			}
+		}
 		if tok.t != tokenS && tok.t != tokenComment {
+			d.prevTok = d.lastTok
 			d.lastTok = tok
+			d.record = append(d.record, tok)
+			if len(comments) > 0 {
+				d.leading[tok] = comments
+			}
 			return tok
 		}
 	}
@@ -92,88 +258,345 @@ func (d *Decoder) backup() {
 		d.error(d.pos(d.nextTok), "internal parser bug: double backup (%v, %v)", d.nextTok, d.lastTok)
 	}
 	d.nextTok = d.lastTok
+	d.lastTok = d.prevTok
+	d.record = d.record[:len(d.record)-1]
 }
 
 // ParseFile parses the given .mss file.
 // Can be called multiple times to parse a style split into multiple files.
+// Files that were already parsed or imported are skipped.
 func (d *Decoder) ParseFile(filename string) error {
-	d.filename = filename
-	defer func() { d.filename = "" }()
-
-	r, err := os.Open(filename)
-	if err != nil {
-		return err
+	if _, ok := d.parsedFiles[absPath(filename)]; ok {
+		return nil
 	}
-	defer r.Close()
-	content, err := ioutil.ReadAll(r)
+	content, err := os.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	return d.ParseString(string(content))
+	return d.ParseFileContent(filename, string(content))
+}
+
+// ParseFileContent parses content as the .mss file filename, eg. for unsaved
+// changes in an editor. Relative @import paths are resolved to the directory
+// of filename.
+func (d *Decoder) ParseFileContent(filename, content string) error {
+	d.filename = filename
+	d.addFile(filename)
+	defer func() {
+		d.filename = ""
+		d.importStack = d.importStack[:0]
+	}()
+	return d.ParseString(content)
 }
 
+// ParseString parses the given MSS content.
+// The decoder does not stop at the first error. It skips to the end of
+// the invalid statement and continues. All errors are returned as ParseErrors.
+// Relative @import paths are resolved to the current working directory.
 func (d *Decoder) ParseString(content string) (err error) {
-	d.filesParsed += 1
-	d.scanner = newScanner(content)
+	d.errors = nil
 
 	defer func() {
 		if r := recover(); r != nil {
-			switch x := r.(type) {
-			case string:
-				err = errors.New(x)
-			case error:
-				err = x
-			default:
-				err = fmt.Errorf("unexpected error: %v, %T", r, r)
-			}
+			err = recoverError(r)
 		}
 	}()
+	d.parse(content)
+	return d.errors.err()
+}
+
+// Files returns all files parsed with ParseFile, including all imported
+// files, in order of their first appearance.
+func (d *Decoder) Files() []string {
+	return append([]string(nil), d.files...)
+}
+
+// parse decodes all top level statements of content.
+func (d *Decoder) parse(content string) {
+	d.filesParsed += 1
+	d.scanner = newScanner(content)
+	d.nextTok = nil
+	d.lastTok = nil
+	d.prevTok = nil
+	d.sheet = &Stylesheet{Filename: d.filename}
+	d.sheets = append(d.sheets, d.sheet)
+
 	for {
-		tok := d.next()
-		if tok.t == tokenEOF {
+		d.record = d.record[:0]
+		end := d.statement(func() tokenType {
+			tok := d.next()
+			if tok.t == tokenEOF {
+				return tokenEOF
+			}
+			d.topLevel(tok)
+			return tokenSemicolon
+		})
+		if end == tokenEOF {
 			break
 		}
-		if tok.t == tokenError {
-			return fmt.Errorf("%s", tok.String())
-		}
+	}
+}
 
-		d.topLevel(tok)
+// addFile records filename as parsed and pushes it on the import stack.
+func (d *Decoder) addFile(filename string) {
+	abs := absPath(filename)
+	d.importStack = append(d.importStack, abs)
+	if _, ok := d.parsedFiles[abs]; ok {
+		return
 	}
-	return err
+	d.parsedFiles[abs] = struct{}{}
+	d.files = append(d.files, filename)
+}
+
+// absPath returns the absolute path of filename, used to identify parsed
+// files.
+func absPath(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return filepath.Clean(filename)
+	}
+	return abs
+}
+
+// importFile parses the imported file. Relative paths are resolved to the
+// directory of the importing file. Files that were already parsed or imported
+// are skipped.
+func (d *Decoder) importFile(pos position, name string) {
+	filename := name
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(filepath.Dir(d.filename), name)
+	}
+	abs := absPath(filename)
+	for i, f := range d.importStack {
+		if f == abs {
+			cycle := append(append([]string{}, d.importStack[i:]...), abs)
+			d.error(pos, "import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if _, ok := d.parsedFiles[abs]; ok {
+		return
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		d.error(pos, "unable to import %s: %v", name, err)
+	}
+
+	scanner, nextTok, lastTok, prevTok, importer := d.scanner, d.nextTok, d.lastTok, d.prevTok, d.filename
+	sheet, record := d.sheet, d.record
+	d.record = nil
+	d.filename = filename
+	d.addFile(filename)
+	defer func() {
+		d.scanner, d.nextTok, d.lastTok, d.prevTok, d.filename = scanner, nextTok, lastTok, prevTok, importer
+		d.sheet, d.record = sheet, record
+		d.importStack = d.importStack[:len(d.importStack)-1]
+	}()
+	d.parse(string(content))
 }
 
 // Evaluate evaluates all expressions and resolves all references to variables.
 // Must be called after last ParseFile/ParseString call.
+// Evaluation continues after invalid expressions and all errors are
+// returned as ParseErrors.
 func (d *Decoder) Evaluate() (err error) {
+	d.errors = nil
 	defer func() {
 		if r := recover(); r != nil {
-			switch x := r.(type) {
-			case string:
-				err = errors.New(x)
-			case error:
-				err = x
-			default:
-				err = fmt.Errorf("unexpected error: %v, %T", r, r)
-			}
+			err = recoverError(r)
 		}
 	}()
 
+	for name, v := range d.overrides {
+		k := key{name: name}
+		d.vars.setPos(k, v, d.vars.pos(k))
+	}
 	d.evaluateProperties(d.vars, false)
 	d.evaluateProperties(d.mss.Map(), true)
 	for _, b := range d.mss.root.blocks {
 		d.evaluateBlock(b)
 	}
-	return err
+	d.warnUnusedVars()
+	return d.errors.err()
+}
+
+func (d *Decoder) warnUnusedVars() {
+	unusedOverrides := []string{}
+	for name := range d.overrides {
+		if _, ok := d.usedVars[name]; !ok {
+			unusedOverrides = append(unusedOverrides, name)
+		}
+	}
+	sort.Strings(unusedOverrides)
+	for _, name := range unusedOverrides {
+		// overrides are not defined in a stylesheet and have no range
+		d.warnings = append(d.warnings, Warning{
+			Severity: SeverityWarning,
+			Code:     UnusedOverride,
+			Message:  fmt.Sprintf("variable override @%s is not used by any stylesheet", name),
+		})
+	}
+
+	unused := []key{}
+	for _, k := range d.vars.keys() {
+		if _, ok := d.overrides[k.name]; ok {
+			continue
+		}
+		if _, ok := d.usedVars[k.name]; !ok {
+			unused = append(unused, k)
+		}
+	}
+	sort.Slice(unused, func(i, j int) bool {
+		pi, pj := d.vars.pos(unused[i]), d.vars.pos(unused[j])
+		if pi.filenum != pj.filenum {
+			return pi.filenum < pj.filenum
+		}
+		if pi.line != pj.line {
+			return pi.line < pj.line
+		}
+		return pi.column < pj.column
+	})
+	for _, k := range unused {
+		d.warn(d.vars.pos(k), SeverityInfo, UnusedVariable, "unused variable @%s", k.name)
+	}
+}
+
+func recoverError(r interface{}) error {
+	switch x := r.(type) {
+	case string:
+		return errors.New(x)
+	case error:
+		return x
+	default:
+		return fmt.Errorf("unexpected error: %v, %T", r, r)
+	}
 }
 
 func (d *Decoder) evaluateBlock(b *block) {
+	for _, s := range b.selectors {
+		d.evaluateSelector(s)
+	}
 	d.evaluateProperties(b.properties, true)
 	for _, b := range b.blocks {
 		d.evaluateBlock(b)
 	}
 }
 
+// evaluateSelector replaces all variables in filters and zoom conditions.
+func (d *Decoder) evaluateSelector(s *Selector) {
+	for i := range s.Filters {
+		if f := s.Filters[i]; isListOp(f.CompOp) {
+			if hasVarRef(f.Value) {
+				d.catch(func() {
+					s.Filters[i] = newListFilter(f.Field, f.CompOp, d.selectorList(f.Value))
+				})
+			}
+			continue
+		}
+		ref, ok := s.Filters[i].Value.(*varRef)
+		if !ok {
+			continue
+		}
+		d.catch(func() {
+			v := d.selectorVar(*ref)
+			if _, ok := v.(string); !ok && s.Filters[i].CompOp == REGEX {
+				d.error(ref.pos, "regular expression requires string, got %v (%T) for var %s", v, v, ref.name)
+			}
+			switch v.(type) {
+			case string, float64, nil:
+				s.Filters[i].Value = v
+			default:
+				d.error(ref.pos, "filter requires string, number or null, got %v (%T) for var %s", v, v, ref.name)
+			}
+		})
+	}
+	for _, z := range s.zoomVars {
+		d.catch(func() {
+			v := d.selectorVar(z.ref)
+			level, ok := v.(float64)
+			if !ok {
+				d.error(z.ref.pos, "zoom requires num, got %v (%T) for var %s", v, v, z.ref.name)
+			}
+			if level != float64(int64(level)) || level < 0 || level > 30 {
+				d.error(z.ref.pos, "invalid zoom level %v for var %s", level, z.ref.name)
+			}
+			s.addZoom(z.comp, int64(level))
+		})
+	}
+	s.zoomVars = nil
+}
+
+func hasVarRef(v Value) bool {
+	if _, ok := v.(*varRef); ok {
+		return true
+	}
+	if l, ok := v.([]Value); ok {
+		for _, v := range l {
+			if _, ok := v.(*varRef); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectorList returns the values of a IN/NOTIN filter with all variables
+// resolved. Variables with lists are expanded.
+func (d *Decoder) selectorList(v Value) []Value {
+	var values []Value
+	if ref, ok := v.(*varRef); ok {
+		resolved := d.selectorVar(*ref)
+		if l, ok := resolved.([]Value); ok {
+			values = l
+		} else {
+			values = []Value{resolved}
+		}
+		for _, v := range values {
+			switch v.(type) {
+			case string, float64, nil:
+			default:
+				d.error(ref.pos, "list filter requires strings, numbers or null, got %v (%T) for var %s", v, v, ref.name)
+			}
+		}
+		return values
+	}
+
+	for _, v := range v.([]Value) {
+		ref, ok := v.(*varRef)
+		if !ok {
+			values = append(values, v)
+			continue
+		}
+		resolved := d.selectorVar(*ref)
+		switch resolved.(type) {
+		case string, float64, nil:
+			values = append(values, resolved)
+		default:
+			d.error(ref.pos, "list filter requires strings, numbers or null, got %v (%T) for var %s", resolved, resolved, ref.name)
+		}
+	}
+	return values
+}
+
+// selectorVar returns the evaluated value of a variable referenced in a selector.
+func (d *Decoder) selectorVar(ref varRef) Value {
+	k := key{name: ref.name}
+	v, ok := d.vars.values[k]
+	if !ok {
+		d.error(ref.pos, "missing var %s in selector", ref.name)
+	}
+	d.usedVars[ref.name] = struct{}{}
+	if expr, ok := v.value.(*expression); ok {
+		// evaluation of the var failed before, reports the same error again
+		return d.evaluateExpression(expr)
+	}
+	return v.value
+}
+
 func (d *Decoder) evaluateExpression(expr *expression) Value {
+	// evaluate a copy, the original expression might be evaluated again
+	// if it is referenced by multiple properties
+	expr = &expression{code: append([]code(nil), expr.code...), pos: expr.pos}
+
 	// resolve all vars in the expression before evaluating it
 	for i := range expr.code {
 		if expr.code[i].T == typeVar {
@@ -182,70 +605,102 @@ func (d *Decoder) evaluateExpression(expr *expression) Value {
 			if v == nil {
 				d.error(expr.pos, "missing var %s in expression", varname)
 			}
+			d.usedVars[varname] = struct{}{}
 			if cexpr, ok := v.(*expression); ok {
 				// evaluate recursive
 				v = d.evaluateExpression(cexpr)
-				d.vars.set(varname, v)
+				k := key{name: varname}
+				d.vars.setPos(k, v, d.vars.pos(k))
 			}
-			t := d.valueType(v)
+			t := valueType(v)
 			if t == typeUnknown {
 				d.error(expr.pos, "unable to determine type of var %s (%v)", varname, v)
 			}
 			expr.code[i] = code{Value: v, T: t}
 		}
 	}
-	v, err := expr.evaluate()
+	v, err := expr.evaluateWith(d.funcs)
 	if err != nil {
-		d.error(expr.pos, "expression error: %v", err)
+		pos := expr.pos
+		var ferr *funcError
+		if errors.As(err, &ferr) && ferr.pos.line > 0 {
+			pos = ferr.pos
+		}
+		d.error(pos, "expression error: %v", err)
 	}
 	return v
 }
 
-func (d *Decoder) valueType(v interface{}) codeType {
-	switch v.(type) {
-	case string:
-		return typeString
-	case float64:
-		return typeNum
-	case color.Color:
-		return typeColor
-	case bool:
-		return typeBool
-	case []Value:
-		return typeList // TODO convert v to typeList?
-	default:
-		return typeUnknown
-	}
-}
-
 func (d *Decoder) evaluateProperties(properties *Properties, validate bool) {
 	if properties == nil {
 		return
 	}
-	for _, k := range properties.keys() {
+	keys := properties.keys()
+	// evaluate in order of appearance to report errors in a stable order
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := exprIndex(properties.getKey(keys[i])), exprIndex(properties.getKey(keys[j]))
+		if pi != pj {
+			return pi < pj
+		}
+		return keys[i].name < keys[j].name
+	})
+	for _, k := range keys {
 		if expr, ok := properties.getKey(k).(*expression); ok {
-			v := d.evaluateExpression(expr)
-			if validate {
-				if validProp, validVal := validProperty(k.name, v); !validProp {
-					d.warn(properties.pos(k), "invalid property %v %v", k.name, v)
-				} else if !validVal {
-					d.warn(properties.pos(k), "invalid property value for %v %v", k.name, v)
+			d.catch(func() {
+				v := d.evaluateExpression(expr)
+				if validate {
+					if validProp, validVal := validProperty(k.name, v); !validProp {
+						d.warn(properties.pos(k), SeverityWarning, InvalidProperty, "invalid property %v %v", k.name, v)
+					} else {
+						if ok, since := d.supportedProperty(k.name); !ok {
+							d.warn(properties.pos(k), SeverityWarning, UnsupportedProperty, "property %v requires Mapnik %v, target is %v", k.name, since, d.specVersion)
+						}
+						if !validVal {
+							d.warn(properties.pos(k), SeverityWarning, InvalidValue, "invalid property value for %v %v", k.name, v)
+						}
+					}
 				}
-			}
-			attr := properties.values[k]
-			properties.setPos(k, v, attr.pos)
+				attr := properties.values[k]
+				properties.setPos(k, v, attr.pos)
+			})
 		}
 	}
 }
 
+func exprIndex(v Value) int {
+	if expr, ok := v.(*expression); ok {
+		return expr.pos.index
+	}
+	return -1
+}
+
 func (d *Decoder) topLevel(tok *token) {
 	switch tok.t {
 	case tokenAtKeyword:
+		if tok.value == "@import" {
+			if next := d.next(); next.t == tokenString {
+				path := unquote(next.value)
+				d.expect(tokenSemicolon)
+				d.addNode(&Import{span: span{d.rangePos(tok, d.lastTok).Range()}, Path: path})
+				if !d.skipImports {
+					d.importFile(d.pos(tok), path)
+				}
+				return
+			}
+			d.backup()
+		}
 		keyword := tok.value[1:]
 		d.expect(tokenColon)
 		d.expressionList()
+		pos := d.rangePos(tok, d.lastTok)
 		d.expect(tokenSemicolon)
-		d.vars.set(keyword, d.lastValue)
+		d.vars.setPos(key{name: keyword}, d.lastValue, pos)
+		d.addNode(&VarDecl{
+			span:     span{pos.Range()},
+			Name:     keyword,
+			Value:    d.lastExpr,
+			Comments: d.leadingComments(tok),
+		})
 	case tokenHash, tokenAttachment, tokenClass, tokenLBracket:
 		d.rule(tok)
 	case tokenIdent:
@@ -253,10 +708,11 @@ func (d *Decoder) topLevel(tok *token) {
 			d.error(d.pos(tok), "only 'Map' identifier expected at top level, got %v", tok)
 		}
 		d.mss.pushMapBlock()
+		d.pushNodeBlock(&Block{Map: true}, tok)
 		d.expect(tokenLBrace)
 		d.block()
+		d.popNodeBlock(tok)
 		d.mss.popBlock()
-		// todo mark block as Map
 	default:
 		d.error(d.pos(tok), "unexpected token at top level, got %v", tok)
 	}
@@ -264,43 +720,160 @@ func (d *Decoder) topLevel(tok *token) {
 
 func (d *Decoder) rule(tok *token) {
 	d.mss.pushBlock()
+	b := &Block{}
+	d.pushNodeBlock(b, tok)
+	d.mss.current().doc = b.Doc
 	d.selectors(tok)
 	d.expect(tokenLBrace)
 	d.block()
+	d.popNodeBlock(tok)
 	d.mss.popBlock()
 }
 
 func (d *Decoder) block() {
 	for {
-		tok := d.next()
-		switch tok.t {
-		case tokenHash, tokenAttachment, tokenClass, tokenLBracket:
-			d.rule(tok)
-		case tokenIdent, tokenInstance:
-			keyword := tok.value
-			if tok.t == tokenInstance {
-				d.mss.setInstance(tok.value[:len(tok.value)-1]) // strip /
-				tok = d.next()
-				if tok.t != tokenIdent {
-					d.error(d.pos(tok), "expected property name for instance, found %v", tok)
-				}
-				keyword = tok.value
-			}
-			d.expect(tokenColon)
-			d.expressionList()
-			d.mss.setProperty(keyword, d.lastValue,
-				position{line: tok.line, column: tok.column, filename: d.filename, filenum: d.filesParsed, index: d.propertyIndex},
-			)
-			d.propertyIndex += 1
-			d.expectEndOfStatement()
-		case tokenRBrace:
+		end := d.statement(func() tokenType {
+			tok := d.next()
+			if tok.t == tokenRBrace {
+				return tokenRBrace
+			}
+			d.blockStatement(tok)
+			return tokenSemicolon
+		})
+		if end == tokenRBrace || end == tokenEOF {
 			return
-		default:
-			d.error(d.pos(tok), "unexpected token %v", tok)
 		}
 	}
 }
 
+// decode single statement inside a block, eg:
+//
+//	line-width: 2;
+//	[zoom=3] { ... }
+func (d *Decoder) blockStatement(tok *token) {
+	switch tok.t {
+	case tokenHash, tokenAttachment, tokenClass, tokenLBracket:
+		d.rule(tok)
+	case tokenIdent, tokenInstance:
+		first := tok
+		keyword := tok.value
+		instance := ""
+		if tok.t == tokenInstance {
+			instance = tok.value[:len(tok.value)-1] // strip /
+			d.mss.setInstance(instance)
+			tok = d.next()
+			if tok.t != tokenIdent {
+				d.error(d.pos(tok), "expected property name for instance, found %v", tok)
+			}
+			keyword = tok.value
+		}
+		d.expect(tokenColon)
+		d.expressionList()
+		pos := d.rangePos(tok, d.lastTok)
+		pos.index = d.propertyIndex
+		property := keyword
+		if name, ok := deprecatedProperties[keyword]; ok {
+			d.warn(d.rangePos(tok, tok), SeverityWarning, DeprecatedProperty, "property %s is deprecated, use %s", keyword, name)
+			property = name
+		}
+		d.mss.setProperty(property, d.lastValue, pos)
+		d.propertyIndex += 1
+		d.addNode(&Declaration{
+			span:     span{d.rangePos(first, d.lastTok).Range()},
+			Instance: instance,
+			Property: keyword,
+			Value:    d.lastExpr,
+			Comments: d.leadingComments(first),
+		})
+		d.expectEndOfStatement()
+	default:
+		d.error(d.pos(tok), "unexpected token %v", tok)
+	}
+}
+
+// statement calls fn to decode a single statement and returns the type of the
+// token that ended the statement. Parse errors are recorded and the decoder
+// skips all tokens till the next `;` or `}` to continue with the following
+// statement.
+func (d *Decoder) statement(fn func() tokenType) (end tokenType) {
+	stackDepth := len(d.mss.stack)
+	current := d.mss.current()
+	numBlocks := len(current.blocks)
+	blockDepth := len(d.blocks)
+	numNodes := d.numNodes()
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		err, ok := r.(*ParseError)
+		if !ok {
+			panic(r)
+		}
+		d.addError(err)
+
+		// drop partially decoded blocks and values
+		d.mss.stack = d.mss.stack[:stackDepth]
+		current.blocks = current.blocks[:numBlocks]
+		current.instance = ""
+		d.expr = &expression{}
+		d.blocks = d.blocks[:blockDepth]
+		d.truncateNodes(numNodes)
+
+		end = d.skipStatement()
+	}()
+
+	return fn()
+}
+
+// skipStatement skips all tokens till the end of the current statement.
+// Nested blocks are skipped as a whole. Returns tokenRBrace if the enclosing
+// block was closed, tokenEOF at the end of the input (or on scanner errors) and
+// tokenSemicolon otherwise.
+func (d *Decoder) skipStatement() tokenType {
+	tok := d.lastTok
+	if d.nextTok != nil {
+		tok = d.nextTok
+		d.nextTok = nil
+	}
+	depth := 0
+	for {
+		if tok != nil {
+			switch tok.t {
+			case tokenEOF, tokenError:
+				d.backupEOF(tok)
+				return tokenEOF
+			case tokenLBrace:
+				depth += 1
+			case tokenRBrace:
+				if depth == 0 {
+					return tokenRBrace
+				}
+				depth -= 1
+				if depth == 0 {
+					return tokenSemicolon
+				}
+			case tokenSemicolon:
+				if depth == 0 {
+					return tokenSemicolon
+				}
+			}
+		}
+		tok = d.scanner.Next()
+		d.lastTok = tok
+		if tok.t == tokenComment {
+			d.addComment(tok)
+		}
+	}
+}
+
+// backupEOF makes sure that the next call to next returns EOF, even if the
+// scanner stopped with an error.
+func (d *Decoder) backupEOF(tok *token) {
+	d.nextTok = &token{t: tokenEOF, line: tok.line, column: tok.column}
+}
+
 // decode multiple selectors, eg:
 //
 //	#foo, #bar[zoom=3]
@@ -332,6 +905,7 @@ func (d *Decoder) selectors(tok *token) {
 //	#foo::attachment[filter=foo][zoom>=12]
 func (d *Decoder) selector(tok *token) {
 	d.mss.pushSelector()
+	start := len(d.record) - 1
 	for {
 		switch tok.t {
 		case tokenHash:
@@ -352,6 +926,10 @@ func (d *Decoder) selector(tok *token) {
 			break
 		}
 	}
+	toks := d.recorded(start)
+	d.mss.current().currentSelector().pos = d.rangePos(toks[0], toks[len(toks)-1])
+	b := d.blocks[len(d.blocks)-1]
+	b.Selectors = append(b.Selectors, d.newSelectorNode(toks))
 }
 
 // decode multiple filters. eg:
@@ -378,6 +956,14 @@ func (d *Decoder) filter() {
 	if tok.t == tokenIdent && tok.value == "zoom" {
 		compOp := d.comp()
 		tok = d.next()
+		if tok.t == tokenAtKeyword {
+			if compOp == REGEX {
+				d.error(d.pos(tok), "regular expressions are not allowed for zoom levels")
+			}
+			d.mss.addZoomVar(compOp, varRef{name: tok.value[1:], pos: d.rangePos(tok, tok)})
+			d.expect(tokenRBracket)
+			return
+		}
 		if tok.t != tokenNumber {
 			d.error(d.pos(tok), "zoom requires num, got %v", tok)
 		}
@@ -388,6 +974,9 @@ func (d *Decoder) filter() {
 		if compOp == REGEX {
 			d.error(d.pos(tok), "regular expressions are not allowed for zoom levels")
 		}
+		if level < 0 || level > 30 {
+			d.error(d.pos(tok), "invalid zoom level %v, zoom needs to be between 0 and 30", level)
+		}
 		d.mss.addZoom(compOp, level)
 		d.expect(tokenRBracket)
 		return
@@ -403,6 +992,10 @@ func (d *Decoder) filter() {
 		d.error(d.pos(tok), "expected zoom or field name in filter, got '%s'", tok.value)
 	}
 
+	if d.listFilter(field) {
+		return
+	}
+
 	compOp := d.comp()
 	var value interface{}
 	if compOp == MODULO {
@@ -433,11 +1026,16 @@ func (d *Decoder) filter() {
 	} else {
 		// All other comparsions expect a single value.
 		tok = d.next()
+		if compOp == REGEX && tok.t != tokenString && tok.t != tokenAtKeyword {
+			d.error(d.pos(tok), "regular expression requires string, got %v", tok)
+		}
 		switch tok.t {
 		case tokenString:
-			value = tok.value[1 : len(tok.value)-1]
+			value = unquote(tok.value)
 		case tokenNumber:
 			value, _ = strconv.ParseFloat(tok.value, 64)
+		case tokenAtKeyword:
+			value = &varRef{name: tok.value[1:], pos: d.rangePos(tok, tok)}
 		case tokenIdent:
 			if tok.value == "null" {
 				value = nil
@@ -452,6 +1050,104 @@ func (d *Decoder) filter() {
 	d.mss.addFilter(field, compOp, value)
 }
 
+// unquote removes the quotes of a filter or import string and resolves
+// escaped quotes and backslashes. Other escapes are kept as-is, as they are part of
+// regular expressions (eg. '\d+').
+func unquote(s string) string {
+	s = s[1 : len(s)-1]
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case '\\', '\'', '"':
+				i++
+			}
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
+
+// decode IN or NOT IN filter, if the next tokens are an in or not in comparsion. eg:
+//
+//	[field in ('a', 'b', 'c')]
+//	[field not in (1, 2, @foo)]
+//	[field in @list]
+func (d *Decoder) listFilter(field string) bool {
+	compOp := IN
+	tok := d.next()
+	if tok.t == tokenIdent && tok.value == "not" {
+		compOp = NOTIN
+		tok = d.next()
+		if !(tok.t == tokenIdent && tok.value == "in") && !(tok.t == tokenFunction && tok.value == "in(") {
+			d.error(d.pos(tok), "expected in after not, got %v", tok)
+		}
+	} else if !(tok.t == tokenIdent && tok.value == "in") && !(tok.t == tokenFunction && tok.value == "in(") {
+		d.backup()
+		return false
+	}
+
+	if tok.t == tokenIdent {
+		tok = d.next()
+		if tok.t == tokenAtKeyword {
+			d.expect(tokenRBracket)
+			d.mss.addFilter(field, compOp, &varRef{name: tok.value[1:], pos: d.rangePos(tok, tok)})
+			return true
+		}
+		if tok.t != tokenLParen {
+			d.error(d.pos(tok), "expected list or variable after %s, got %v", compOp, tok)
+		}
+	}
+
+	values := []Value{}
+	hasVars := false
+	for {
+		tok = d.next()
+		switch tok.t {
+		case tokenString:
+			values = append(values, unquote(tok.value))
+		case tokenNumber:
+			v, _ := strconv.ParseFloat(tok.value, 64)
+			values = append(values, v)
+		case tokenAtKeyword:
+			values = append(values, &varRef{name: tok.value[1:], pos: d.rangePos(tok, tok)})
+			hasVars = true
+		case tokenIdent:
+			if tok.value != "null" {
+				d.error(d.pos(tok), "unexpected value in list '%s'", tok.value)
+			}
+			values = append(values, nil)
+		case tokenRParen:
+			if len(values) == 0 {
+				d.error(d.pos(tok), "empty list for %s", compOp)
+			}
+			d.error(d.pos(tok), "unexpected value in list '%s'", tok.value)
+		default:
+			d.error(d.pos(tok), "unexpected value in list '%s'", tok.value)
+		}
+		tok = d.next()
+		if tok.t == tokenRParen {
+			break
+		}
+		if tok.t != tokenComma {
+			d.error(d.pos(tok), "expected comma or end of list, got %v", tok)
+		}
+	}
+	d.expect(tokenRBracket)
+
+	if hasVars {
+		// normalized in evaluateSelector
+		d.mss.addFilter(field, compOp, values)
+	} else {
+		f := newListFilter(field, compOp, values)
+		d.mss.addFilter(f.Field, f.CompOp, f.Value)
+	}
+	return true
+}
+
 // decode comparision. eg:
 //
 //	= or >=
@@ -487,6 +1183,7 @@ func (d *Decoder) expectEndOfStatement() {
 }
 
 func (d *Decoder) expressionList() {
+	start := len(d.record)
 	startTok := d.next()
 	d.backup()
 
@@ -508,6 +1205,7 @@ func (d *Decoder) expressionList() {
 	d.expr.pos = position{line: startTok.line, column: startTok.column, filename: d.filename, filenum: d.filesParsed, index: d.propertyIndex}
 	d.propertyIndex += 1
 	d.lastValue = d.expr
+	d.lastExpr = d.newExpr(d.recorded(start))
 	d.expr = &expression{}
 }
 
@@ -579,6 +1277,23 @@ func (d *Decoder) value(tok *token) {
 			d.error(d.pos(tok), "invalid float %v: %s", v, err)
 		}
 		d.expr.addValue(v, typePercent)
+	case tokenDimension:
+		// only angles for the hue are supported, eg. hsl(120deg 50% 50%)
+		if !d.angle {
+			d.error(d.pos(tok), "unexpected dimension %v, angles are only supported for the hue of color functions", tok)
+		}
+		n := strings.IndexFunc(tok.value, func(r rune) bool {
+			return r != '-' && r != '+' && r != '.' && (r < '0' || r > '9')
+		})
+		v, err := strconv.ParseFloat(tok.value[:n], 64)
+		if err != nil {
+			d.error(d.pos(tok), "invalid float %v: %s", tok.value, err)
+		}
+		deg, ok := color.Degrees(v, tok.value[n:])
+		if !ok {
+			d.error(d.pos(tok), "unsupported unit in %v", tok)
+		}
+		d.expr.addValue(deg, typeNum)
 	case tokenIdent:
 		switch tok.value {
 		case "true":
@@ -616,8 +1331,18 @@ func (d *Decoder) value(tok *token) {
 		d.expr.addValue("["+tok.value+"]", typeField)
 		d.expect(tokenRBracket)
 	case tokenFunction:
-		d.expr.addValue(tok.value[:len(tok.value)-1], typeFunction) // strip lparen
-		d.functionParams()
+		name := tok.value[:len(tok.value)-1] // strip lparen
+		d.expr.addFunction(name, d.rangePos(tok, tok))
+		switch name {
+		case "hsl", "hsla", "hwb":
+			d.colorFunctionParams(0)
+		case "lch", "oklch":
+			d.colorFunctionParams(2)
+		case "rgb", "rgba", "lab", "oklab":
+			d.colorFunctionParams(-1)
+		default:
+			d.functionParams()
+		}
 	case tokenLParen:
 		d.exprPart()
 		d.expect(tokenRParen)
@@ -641,6 +1366,66 @@ func (d *Decoder) functionParams() {
 	}
 }
 
+// colorFunctionParams parses the parameters of the CSS color functions. The
+// parameters are either separated by commas, or by spaces with an optional
+// alpha value after a slash, eg. rgb(255 0 0 / 50%). Angles are only accepted
+// for the parameter at index hue.
+func (d *Decoder) colorFunctionParams(hue int) {
+	d.colorParam(hue == 0, d.exprPart)
+	tok := d.next()
+	switch tok.t {
+	case tokenRParen:
+		d.expr.addValue(nil, typeFunctionEnd)
+		return
+	case tokenComma:
+		for i := 1; ; i++ {
+			d.colorParam(i == hue, d.exprPart)
+			tok := d.next()
+			if tok.t == tokenRParen {
+				d.expr.addValue(nil, typeFunctionEnd)
+				return
+			}
+			if tok.t != tokenComma {
+				d.error(d.pos(tok), "expected end of function or comma, got %v", tok)
+			}
+		}
+	}
+	d.backup()
+	for i := 1; ; i++ {
+		tok := d.next()
+		switch tok.t {
+		case tokenRParen:
+			d.expr.addValue(nil, typeFunctionEnd)
+			return
+		case tokenDivide:
+			d.colorParam(false, d.negOrValue)
+			if tok := d.next(); tok.t != tokenRParen {
+				d.error(d.pos(tok), "expected end of function after alpha value, got %v", tok)
+			}
+			d.expr.addValue(nil, typeFunctionEnd)
+			return
+		case tokenComma:
+			d.error(d.pos(tok), "unexpected comma in space separated color function")
+		default:
+			if i >= 3 {
+				d.error(d.pos(tok), "expected / before alpha value in space separated color function, got %v", tok)
+			}
+			d.backup()
+			d.colorParam(i == hue, d.negOrValue)
+		}
+	}
+}
+
+// colorParam calls parse for a parameter of a color function, with angles
+// enabled for the hue.
+func (d *Decoder) colorParam(hue bool, parse func()) {
+	prev := d.angle
+	defer func() { d.angle = prev }()
+	d.angle = hue
+	parse()
+}
+
+// ParseError is an error in a single statement or expression.
 type ParseError struct {
 	Filename string
 	Line     int
@@ -656,6 +1441,26 @@ func (p *ParseError) Error() string {
 	return fmt.Sprintf("%s in %s line: %d col: %d", p.Err, file, p.Line, p.Column)
 }
 
+// ParseErrors contains all errors from a single ParseString, ParseFile or
+// Evaluate call, in order of appearance.
+type ParseErrors []*ParseError
+
+func (p ParseErrors) Error() string {
+	msgs := make([]string, len(p))
+	for i := range p {
+		msgs[i] = p[i].Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// err returns p as error, or nil if p is empty.
+func (p ParseErrors) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
 func (d *Decoder) pos(tok *token) position {
 	return position{
 		filename: d.filename,
@@ -665,6 +1470,14 @@ func (d *Decoder) pos(tok *token) position {
 	}
 }
 
+// rangePos returns the position from the start of the first token till the
+// end of the last token.
+func (d *Decoder) rangePos(first, last *token) position {
+	pos := d.pos(first)
+	pos.endLine, pos.endColumn = last.end()
+	return pos
+}
+
 func (d *Decoder) error(pos position, format string, args ...interface{}) {
 	panic(&ParseError{
 		Filename: pos.filename,
@@ -674,13 +1487,38 @@ func (d *Decoder) error(pos position, format string, args ...interface{}) {
 	})
 }
 
-func (d *Decoder) warn(pos position, format string, args ...interface{}) {
+// addError records err, unless the same error was already recorded (e.g. for
+// an invalid variable that is referenced multiple times).
+func (d *Decoder) addError(err *ParseError) {
+	for _, e := range d.errors {
+		if *e == *err {
+			return
+		}
+	}
+	d.errors = append(d.errors, err)
+}
+
+// catch calls fn and records any parse error.
+func (d *Decoder) catch(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*ParseError)
+			if !ok {
+				panic(r)
+			}
+			d.addError(err)
+		}
+	}()
+	fn()
+}
+
+func (d *Decoder) warn(pos position, severity Severity, code WarningCode, format string, args ...interface{}) {
 	d.warnings = append(d.warnings,
-		warning{
-			file: pos.filename,
-			line: pos.line,
-			col:  pos.column,
-			msg:  fmt.Sprintf(format, args...),
+		Warning{
+			Severity: severity,
+			Code:     code,
+			Message:  fmt.Sprintf(format, args...),
+			Range:    pos.Range(),
 		},
 	)
 }
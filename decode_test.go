@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"testing"
 
 	"github.com/flywave/go-cartocss/color"
@@ -40,8 +41,11 @@ func decodeFiles(t testing.TB) {
 		for _, layer := range d.MSS().Layers() {
 			d.MSS().LayerRules(layer)
 		}
-		for _, w := range d.warnings {
-			t.Error(w.String())
+		for _, w := range d.Warnings() {
+			// test files contain unused variables
+			if w.Severity <= SeverityWarning {
+				t.Error(w.String())
+			}
 		}
 	}
 }
@@ -93,9 +97,9 @@ func TestParseColor(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, color.Color{H: 24.0, S: 1.0, L: 0.5, A: 1.0, Perceptual: false}, d.vars.getKey(key{name: "foo"}))
 
-	_, err = decodeString(`@foo: rgb(255, 102, 0, 50);`)
+	_, err = decodeString(`@foo: rgb(255, 102);`)
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "rgb takes exactly three arguments")
+	assert.Contains(t, err.Error(), "rgb takes three or four arguments")
 
 	d, err = decodeString(`@foo: rgba(255, 102, 0, 102);`)
 	assert.NoError(t, err)
@@ -165,21 +169,80 @@ func TestParseExpression(t *testing.T) {
 		{`@foo: hue(123);`, "function hue requires color as argument", nil},
 
 		{`@foo: mix(red, blue, 0%);`, "", color.MustParse("blue")},
-		{`@foo: mix(red, blue);`, "function mix takes exactly three arguments", nil},
-		{`@foo: mix(red, blue);`, "function mix takes exactly three arguments", nil},
-		{`@foo: mix(red, blue, red, blue);`, "function mix takes exactly three arguments", nil},
+		{`@foo: mix(red, blue);`, "function mix takes three or four arguments", nil},
+		{`@foo: mix(red, blue, 0%, oklab, red);`, "function mix takes three or four arguments", nil},
+		{`@foo: mix(red, blue, 50%, foo);`, "function mix requires rgb, lab, lch, oklab or oklch as fourth argument", nil},
+		{`@foo: mix(red, blue, 50%, 1);`, "function mix requires rgb, lab, lch, oklab or oklch as fourth argument", nil},
 
 		{`@foo: mix(123, blue, 0%);`, "function mix requires color as first and second argument", nil},
 		{`@foo: mix(red, 123, 0%);`, "function mix requires color as first and second argument", nil},
 		{`@foo: mix(red, blue, red);`, "function mix requires number/percent as third argument", nil},
 
-		{`@foo: rgb(0, 0, 0, 0);`, "rgb takes exactly three arguments", nil},
-		{`@foo: rgba(0, 0, 0);`, "rgba takes exactly four arguments", nil},
+		{`@foo: rgb(0, 0);`, "rgb takes three or four arguments", nil},
+		{`@foo: rgba(0, 0, 0, 0, 0);`, "rgba takes three or four arguments", nil},
+		{`@foo: rgb(0, 0, 0, 0);`, "", color.Color{A: 0}},
+		{`@foo: rgb(255 102 0 / 40%);`, "", color.Color{H: 24.0, S: 1.0, L: 0.5, A: 0.4}},
+		{`@foo: rgba(100% 40% 0);`, "", color.Color{H: 24.0, S: 1.0, L: 0.5, A: 1.0}},
+		{`@foo: rgb(255 102 0 / 1 + 1);`, "expected end of function after alpha value", nil},
+		{`@foo: rgb(255 102, 0);`, "unexpected comma in space separated color function", nil},
+		{`@foo: rgb(255 0 0 0.5);`, "expected / before alpha value in space separated color function", nil},
+		{`@foo: hsl(120deg 50% 50% / .5);`, "", color.Color{H: 120.0, S: 0.5, L: 0.5, A: 0.5}},
+		{`@foo: hsla(0.25turn 100% 50%);`, "", color.Color{H: 90.0, S: 1.0, L: 0.5, A: 1.0}},
+		{`@foo: hsl(120px 50% 50%);`, "unsupported unit in", nil},
+		{`@foo: hsl(120deg, 50%, 50%);`, "", color.Color{H: 120.0, S: 0.5, L: 0.5, A: 1.0}},
+		{`@foo: hsl(120 50deg 50%);`, "unexpected dimension", nil},
+		{`@foo: hsl(120 50% 50% / 1deg);`, "unexpected dimension", nil},
+		{`@foo: rgb(10deg 0 0);`, "unexpected dimension", nil},
+		{`@foo: lch(50% 10deg 0);`, "unexpected dimension", nil},
+		{`@foo: 1rad;`, "unexpected dimension", nil},
+		{`@foo: hwb(120 0% 0%);`, "", color.Color{H: 120.0, S: 1.0, L: 0.5, A: 1.0}},
+		{`@foo: hwb(0 50% 50% / 20%);`, "", color.Color{H: 0, S: 0, L: 0.5, A: 0.2}},
+		{`@foo: hwb(0, 0.5, 0, 0.5);`, "", color.Color{H: 0, S: 1.0, L: 0.75, A: 0.5}},
+		{`@foo: hwb(0 0 0 0 0);`, "expected / before alpha value in space separated color function", nil},
+		{`@foo: hwb(0, 0, 0, 0, 0);`, "hwb takes three or four arguments", nil},
+		{`@foo: hwb(10% 0 0);`, "hwb takes hue and float or percent arguments only", nil},
+		{`@foo: #ff660066;`, "", color.Color{H: 24.0, S: 1.0, L: 0.5, A: 0.4}},
+		{`@foo: oklch(50% 0.1 red);`, "oklch takes float or percent arguments only", nil},
+		{`@foo: lch(50% 0 10%);`, "lch does not accept percentage as argument 3", nil},
+		{`@foo: lab(50% 0);`, "lab takes three or four arguments", nil},
+		{`@foo: #f60c;`, "", color.Color{H: 24.0, S: 1.0, L: 0.5, A: 0.8}},
 
 		{`@foo: [field1] + " " + [field2] + "!";`, "", []Value{Field("[field1]"), " ", Field("[field2]"), "!"}},
 		{`@foo: [field1] + [field2];`, "", []Value{Field("[field1]"), Field("[field2]")}},
 		{`@foo: "hello " + [field2];`, "", []Value{"hello ", Field("[field2]")}},
 
+		{`@foo: min(3, 1, 2);`, "", 1.0},
+		{`@foo: max(3, 1, 2) * 2;`, "", 6.0},
+		{`@foo: max(10%, 20%);`, "", 20.0},
+		{`@foo: min(1, 10%);`, "function min requires arguments of the same type", nil},
+		{`@foo: max(1, red);`, "function max requires number/percent as second argument", nil},
+		{`@foo: round(2.5);`, "", 3.0},
+		{`@foo: round(3.14159, 2);`, "", 3.14},
+		{`@foo: round(3.1, 0.5);`, "function round requires positive integer as second argument", nil},
+		{`@foo: round(1, 2, 3);`, "function round takes one or two arguments", nil},
+		{`@foo: floor(2.7);`, "", 2.0},
+		{`@foo: ceil(2.1);`, "", 3.0},
+		{`@foo: ceil("a");`, "function ceil requires number/percent as argument", nil},
+		{`@foo: abs(-2);`, "", 2.0},
+		{`@foo: abs(1, 2);`, "function abs takes exactly one argument, got 2", nil},
+		{`@foo: sqrt(16);`, "", 4.0},
+		{`@foo: sqrt(-1);`, "function sqrt requires a non-negative number", nil},
+		{`@foo: pow(2, 10);`, "", 1024.0},
+		{`@foo: pow(2);`, "function pow takes exactly two arguments, got 1", nil},
+		{`@foo: pow(2, "a");`, "function pow requires number as second argument", nil},
+		{`@foo: mod(7, 3);`, "", 1.0},
+		{`@foo: mod(7, 0);`, "function mod requires a non-zero divisor", nil},
+		{`@foo: percentage(0.25);`, "", 25.0},
+		{`@foo: lighten(red, percentage(0.1));`, "", color.Color{H: 0, S: 1, L: 0.6, A: 1, Perceptual: false}},
+		{`@foo: concat("a", 1, "b");`, "", "a1b"},
+		{`@foo: concat("a", red);`, "function concat requires string/number as second argument", nil},
+		{`@foo: upper("abc");`, "", "ABC"},
+		{`@foo: lower("ABC");`, "", "abc"},
+		{`@foo: lower(1);`, "function lower requires string as argument", nil},
+		{`@foo: replace("a-b-c", "-", " ");`, "", "a b c"},
+		{`@foo: replace("a-b-c", "-");`, "function replace takes exactly three arguments, got 2", nil},
+		{`@foo: replace("a-b-c", "-", 1);`, "function replace requires string as third argument", nil},
+
 		{`@foo: red * 0.5;`, "", color.Color{H: 0, S: 1.0, L: 0.25, A: 1, Perceptual: false}},
 		{`@foo: red * blue;`, "unsupported operation", nil},
 	}
@@ -199,6 +262,36 @@ func TestParseExpression(t *testing.T) {
 	}
 }
 
+func TestParseColorSpaces(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		want string
+	}{
+		{`oklch(100% 0 0)`, "#ffffff"},
+		{`oklch(62.8% 0.2577 29.23deg)`, "#ff0000"},
+		{`oklab(0 0 0 / 50%)`, "rgba(0, 0, 0, 0.50000)"},
+		{`lab(100, 0, 0)`, "#ffffff"},
+		{`lab(54.29 80.8 69.89)`, "#ff0000"},
+		{`lch(0% 0 120deg)`, "#000000"},
+		{`lch(29.57% 131.2 301.36)`, "#0000ff"},
+		{`mix(white, black, 50%, oklab)`, "#636363"},
+		{`mix(white, black, 50%, lab)`, "#777777"},
+		{`mix(red, blue, 100%, oklch)`, "#ff0000"},
+		{`mix(white, black, 50%, rgb)`, "#808080"},
+		{`mix(red, blue, 0%, lch)`, "#0000ff"},
+		{`mix(red, lime, 50%, oklch)`, "#f99500"},
+		{`darkenoklab(white, 100%)`, "#000000"},
+		{`lightenlab(black, 100%)`, "#ffffff"},
+		{`lightenoklab(#777, 10%)`, "#959595"},
+	} {
+		d, err := decodeString("@foo: " + tt.expr + ";")
+		if assert.NoError(t, err, tt.expr) {
+			c := d.vars.getKey(key{name: "foo"}).(color.Color)
+			assert.Equal(t, tt.want, c.String(), tt.expr)
+		}
+	}
+}
+
 func TestParserErrors(t *testing.T) {
 	tests := []struct {
 		expr string
@@ -211,9 +304,11 @@ func TestParserErrors(t *testing.T) {
 		{`#bar[zoom=3]{}`, ""},
 		{`#bar[zoom 3]{}`, "expected comparsion, got '3'"},
 		{`#bar[zoom=3.1]{}`, "invalid zoom level NUMBER"},
+		{`#bar[zoom>=200]{}`, "invalid zoom level 200, zoom needs to be between 0 and 30"},
 		{`#bar[zoom=~3]{}`, "regular expressions are not allowed for zoom levels"},
 		{`#bar[zoom="foo"]{}`, "zoom requires num, got STRING"},
 		{`@bar: `, "unexpected value EOF"},
+		{`#foo { line-width: 1rad; }`, "unexpected dimension"},
 		{`@bar 123`, "expected COLON found NUMBER"},
 		{`@bar:;`, "unexpected value SEMICOLON"},
 		{`@bar: "Foo`, "unclosed quotation mark"},
@@ -233,6 +328,14 @@ func TestParserErrors(t *testing.T) {
 		{`[foo=null]{}`, ""},
 		{`[foo=bar]{}`, "unexpected value in filter 'bar'"},
 		{`[foo % 2 != 1]{}`, ""},
+		{`[foo in ()]{}`, "empty list for in"},
+		{`[foo in ('a',)]{}`, "unexpected value in list ')'"},
+		{`[foo in ('a' 'b')]{}`, "expected comma or end of list"},
+		{`[foo not ('a')]{}`, "expected in after not"},
+		{`[foo in bar]{}`, "expected list or variable after in"},
+		{`@c: red; [foo in @c]{}`, "list filter requires strings, numbers or null"},
+		{`[foo =~ 1]{}`, "regular expression requires string, got NUM"},
+		{`[foo =~ null]{}`, "regular expression requires string, got IDENT"},
 		{`[foo % 2 =~ 1]{}`, "expected simple comparsion, found =~"},
 		{`[foo % 2.0 = 1]{}`, "expected integer for modulo, found NUMBER"},
 		{`[foo % 2 = 0.0]{}`, "expected integer for modulo comparsion, found NUMBER"},
@@ -262,6 +365,95 @@ func TestParserErrors(t *testing.T) {
 	}
 }
 
+func TestParserErrorRecovery(t *testing.T) {
+	d := NewDecoder()
+	err := d.ParseString(`
+@foo: ;
+#foo {
+	line-width: 2;
+	line-color: ;
+	[zoom 3] { line-width: 3; }
+	line-opacity: 0.5;
+}
+#bar, 123 { line-width: 1; }
+Foo { }
+#baz { line-width: 4 }
+#qux[zoom>=200] { line-width: 5; }
+`)
+	if assert.Error(t, err) {
+		errs, ok := err.(ParseErrors)
+		if assert.True(t, ok, "expected ParseErrors, got %T", err) && assert.Len(t, errs, 6) {
+			assert.Contains(t, errs[0].Err, "unexpected value SEMICOLON")
+			assert.Equal(t, 2, errs[0].Line)
+			assert.Contains(t, errs[1].Err, "unexpected value SEMICOLON")
+			assert.Equal(t, 5, errs[1].Line)
+			assert.Contains(t, errs[2].Err, "expected comparsion")
+			assert.Equal(t, 6, errs[2].Line)
+			assert.Contains(t, errs[3].Err, "expected layer, attachment, class or filter")
+			assert.Equal(t, 9, errs[3].Line)
+			assert.Contains(t, errs[4].Err, "only 'Map' identifier expected at top level")
+			assert.Equal(t, 10, errs[4].Line)
+			assert.Contains(t, errs[5].Err, "invalid zoom level 200")
+			assert.Equal(t, 12, errs[5].Line)
+		}
+	}
+
+	assert.NoError(t, d.Evaluate())
+	assert.Equal(t, []string{"foo", "baz"}, d.MSS().Layers())
+	rules := d.MSS().LayerRules("foo")
+	if assert.Len(t, rules, 1) {
+		w, _ := rules[0].Properties.GetFloat("line-width")
+		assert.Equal(t, 2.0, w)
+		o, _ := rules[0].Properties.GetFloat("line-opacity")
+		assert.Equal(t, 0.5, o)
+	}
+	assert.Len(t, d.MSS().LayerRules("baz"), 1)
+}
+
+func TestParserErrorRecoveryScannerError(t *testing.T) {
+	d := NewDecoder()
+	err := d.ParseString(`
+#foo { line-width: ; }
+#bar { text-name: "unclosed; }
+`)
+	if assert.Error(t, err) {
+		errs := err.(ParseErrors)
+		if assert.Len(t, errs, 2) {
+			assert.Contains(t, errs[0].Err, "unexpected value SEMICOLON")
+			assert.Contains(t, errs[1].Err, "unclosed quotation mark")
+			assert.Equal(t, 3, errs[1].Line)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	d := NewDecoder()
+	err := d.ParseString(`
+@a: @missing + 1;
+#foo {
+	line-width: @a;
+	line-color: @other;
+	line-opacity: 0.5;
+}`)
+	assert.NoError(t, err)
+	err = d.Evaluate()
+	if assert.Error(t, err) {
+		errs := err.(ParseErrors)
+		if assert.Len(t, errs, 2) {
+			// error for @a is only reported once
+			assert.Contains(t, errs[0].Error(), "missing var missing")
+			assert.Equal(t, 2, errs[0].Line)
+			assert.Contains(t, errs[1].Error(), "missing var other")
+			assert.Equal(t, 5, errs[1].Line)
+		}
+	}
+	rules := d.MSS().LayerRules("foo")
+	if assert.Len(t, rules, 1) {
+		o, _ := rules[0].Properties.GetFloat("line-opacity")
+		assert.Equal(t, 0.5, o)
+	}
+}
+
 func TestParserFilter(t *testing.T) {
 	tests := []struct {
 		expr   string
@@ -271,6 +463,13 @@ func TestParserFilter(t *testing.T) {
 		{`#foo[bar >= 3] { line-width: 1;}`, Filter{Field: "bar", CompOp: GTE, Value: 3.0}},
 		{`#foo[bar % 2 = 1] { line-width: 1;}`, Filter{Field: "bar", CompOp: MODULO, Value: ModuloComparsion{Div: 2, CompOp: EQ, Value: 1}}},
 		{`#foo[bar % 8 >=1] { line-width: 1;}`, Filter{Field: "bar", CompOp: MODULO, Value: ModuloComparsion{Div: 8, CompOp: GTE, Value: 1}}},
+		{`#foo[bar in ('b', 'a', 1)] { line-width: 1;}`, Filter{Field: "bar", CompOp: IN, Value: []Value{1.0, "a", "b"}}},
+		{`#foo[bar in('a')] { line-width: 1;}`, Filter{Field: "bar", CompOp: EQ, Value: "a"}},
+		{`#foo[bar =~ '\d+\.\d*'] { line-width: 1;}`, Filter{Field: "bar", CompOp: REGEX, Value: `\d+\.\d*`}},
+		{`#foo[bar = 'O\'Neil \\ "x"'] { line-width: 1;}`, Filter{Field: "bar", CompOp: EQ, Value: `O'Neil \ "x"`}},
+		{`#foo[bar not in ('a', null)] { line-width: 1;}`, Filter{Field: "bar", CompOp: NOTIN, Value: []Value{nil, "a"}}},
+		{`@l: "x", "y"; #foo[bar in @l] { line-width: 1;}`, Filter{Field: "bar", CompOp: IN, Value: []Value{"x", "y"}}},
+		{`@x: "x"; #foo[bar not in (@x, 'y')] { line-width: 1;}`, Filter{Field: "bar", CompOp: NOTIN, Value: []Value{"x", "y"}}},
 	}
 
 	for _, tt := range tests {
@@ -293,6 +492,47 @@ func TestParserFilter(t *testing.T) {
 	}
 }
 
+func TestSelectorVars(t *testing.T) {
+	d, err := decodeString(`
+		@min-zoom: 12;
+		@max-zoom: 12 + 2;
+		@primary: "primary";
+		@none: null;
+		#foo[zoom >= @min-zoom][zoom < @max-zoom][type = @primary][name != @none] { line-width: 1; }
+	`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	rules := d.MSS().LayerRules("foo")
+	assertRulesEq(t, rules, []Rule{
+		{Layer: "foo", Filters: []Filter{{"name", NEQ, nil}, {"type", EQ, "primary"}}, Zoom: NewZoomRange(GTE, 12) & NewZoomRange(LTE, 13), Properties: NewProperties("line-width", float64(1))},
+	})
+	for _, w := range d.Warnings() {
+		t.Error("unexpected warning", w)
+	}
+}
+
+func TestSelectorVarErrors(t *testing.T) {
+	tests := []struct {
+		mss string
+		err string
+	}{
+		{`@z: "foo"; #foo[zoom > @z] {line-width: 1}`, "zoom requires num, got foo (string) for var z"},
+		{`@z: 3.5; #foo[zoom > @z] {line-width: 1}`, "invalid zoom level 3.5 for var z"},
+		{`@z: 31; #foo[zoom > @z] {line-width: 1}`, "invalid zoom level 31 for var z"},
+		{`#foo[zoom > @z] {line-width: 1}`, "missing var z in selector"},
+		{`@c: red; #foo[type = @c] {line-width: 1}`, "filter requires string, number or null"},
+		{`#foo[zoom =~ @z] {line-width: 1}`, "regular expressions are not allowed for zoom levels"},
+		{`@r: 12; #foo[name =~ @r] {line-width: 1}`, "regular expression requires string, got 12 (float64) for var r"},
+	}
+	for _, tt := range tests {
+		_, err := decodeString(tt.mss)
+		if assert.Error(t, err, tt.mss) {
+			assert.Contains(t, err.Error(), tt.err)
+		}
+	}
+}
+
 func TestParserWarnings(t *testing.T) {
 	tests := []struct {
 		expr string
@@ -314,6 +554,196 @@ func TestParserWarnings(t *testing.T) {
 	}
 }
 
+func TestWarnings(t *testing.T) {
+	d := NewDecoder()
+	err := d.ParseString(`@unused: 1;
+@width: 2;
+#foo {
+  line-width: @width;
+  line-wi: 2;
+  line-cap: "foo";
+}`)
+	assert.NoError(t, err)
+	assert.NoError(t, d.Evaluate())
+
+	warnings := d.Warnings()
+	if !assert.Len(t, warnings, 3) {
+		return
+	}
+
+	assert.Equal(t, Warning{
+		Severity: SeverityInfo,
+		Code:     UnusedVariable,
+		Message:  "unused variable @unused",
+		Range:    Range{Start: Position{Line: 1, Column: 1}, End: Position{Line: 1, Column: 11}},
+	}, warnings[0])
+	assert.Equal(t, Warning{
+		Severity: SeverityWarning,
+		Code:     InvalidProperty,
+		Message:  "invalid property line-wi 2",
+		Range:    Range{Start: Position{Line: 5, Column: 3}, End: Position{Line: 5, Column: 13}},
+	}, warnings[1])
+	assert.Equal(t, Warning{
+		Severity: SeverityWarning,
+		Code:     InvalidValue,
+		Message:  "invalid property value for line-cap foo",
+		Range:    Range{Start: Position{Line: 6, Column: 3}, End: Position{Line: 6, Column: 18}},
+	}, warnings[2])
+}
+
+func TestDeprecatedProperty(t *testing.T) {
+	d := NewDecoder()
+	err := d.ParseString(`#foo {
+  text-name: [name];
+  text-upgright: left;
+  text-repeat-wrap-characater: true;
+}`)
+	assert.NoError(t, err)
+	assert.NoError(t, d.Evaluate())
+
+	rules := d.MSS().LayerRules("foo")
+	if assert.Len(t, rules, 1) {
+		v, _ := rules[0].Properties.GetString("text-upright")
+		assert.Equal(t, "left", v)
+		b, _ := rules[0].Properties.GetBool("text-repeat-wrap-character")
+		assert.True(t, b)
+		_, ok := rules[0].Properties.GetString("text-upgright")
+		assert.False(t, ok)
+	}
+
+	warnings := d.Warnings()
+	if assert.Len(t, warnings, 2) {
+		assert.Equal(t, Warning{
+			Severity: SeverityWarning,
+			Code:     DeprecatedProperty,
+			Message:  "property text-upgright is deprecated, use text-upright",
+			Range:    Range{Start: Position{Line: 3, Column: 3}, End: Position{Line: 3, Column: 16}},
+		}, warnings[0])
+		assert.Equal(t, "property text-repeat-wrap-characater is deprecated, use text-repeat-wrap-character", warnings[1].Message)
+	}
+}
+
+func TestWarningsSpecVersion(t *testing.T) {
+	mss := `#foo {
+  text-name: [name];
+  text-size: 10;
+  text-font-feature-settings: "liga";
+  dot-fill: red;
+}`
+	v, err := ParseSpecVersion("2.3")
+	assert.NoError(t, err)
+	d := NewDecoder(WithSpecVersion(v))
+	assert.NoError(t, d.ParseString(mss))
+	assert.NoError(t, d.Evaluate())
+
+	warnings := d.Warnings()
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].Range.Start.Line < warnings[j].Range.Start.Line
+	})
+	if assert.Len(t, warnings, 2) {
+		assert.Equal(t, UnsupportedProperty, warnings[0].Code)
+		assert.Equal(t, "property text-font-feature-settings requires Mapnik 3.0.0, target is 2.3.0", warnings[0].Message)
+		assert.Equal(t, 4, warnings[0].Range.Start.Line)
+		assert.Equal(t, "property dot-fill requires Mapnik 3.0.0, target is 2.3.0", warnings[1].Message)
+	}
+
+	for _, version := range []string{"3.0.22", ""} {
+		var d *Decoder
+		if version == "" {
+			d = NewDecoder()
+		} else {
+			v, err := ParseSpecVersion(version)
+			assert.NoError(t, err)
+			d = NewDecoder(WithSpecVersion(v))
+		}
+		assert.NoError(t, d.ParseString(mss))
+		assert.NoError(t, d.Evaluate())
+		assert.Empty(t, d.Warnings(), version)
+	}
+
+	for _, version := range []string{"3.x", "3.0.0.1", "-1", ""} {
+		_, err := ParseSpecVersion(version)
+		assert.Error(t, err, version)
+	}
+
+	// unsupported properties are validated as well
+	d = NewDecoder(WithSpecVersion(v))
+	assert.NoError(t, d.ParseString(`#foo { dot-fill: red; dot-comp-op: foo; }`))
+	assert.NoError(t, d.Evaluate())
+	warnings = d.Warnings()
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].Message < warnings[j].Message
+	})
+	if assert.Len(t, warnings, 3) {
+		assert.Equal(t, InvalidValue, warnings[0].Code)
+		assert.Equal(t, "invalid property value for dot-comp-op foo", warnings[0].Message)
+		assert.Equal(t, UnsupportedProperty, warnings[1].Code)
+		assert.Equal(t, "property dot-comp-op requires Mapnik 3.0.0, target is 2.3.0", warnings[1].Message)
+		assert.Equal(t, UnsupportedProperty, warnings[2].Code)
+	}
+}
+
+func TestLoadReference(t *testing.T) {
+	spec := propertySpecs["line-cap"]
+	assert.Equal(t, "butt", spec.Default)
+	assert.Equal(t, []string{"round", "butt", "square"}, spec.Keywords)
+	assert.Equal(t, color.MustParse("#808080"), propertySpecs["polygon-fill"].Default)
+	assert.Contains(t, propertySpecs["line-comp-op"].Keywords, "multiply")
+
+	for _, ref := range []string{
+		`{"properties": {"a": {"type": "foo", "since": "2.0.0"}}}`,
+		`{"properties": {"a": {"type": "keyword", "since": "2.0.0"}}}`,
+		`{"properties": {"a": {"type": "keyword", "keyword-set": "foo", "since": "2.0.0"}}}`,
+		`{"properties": {"a": {"type": "float", "since": "2.0.0.1"}}}`,
+		`{"properties": {"a": {"type": "color", "default-value": "foo", "since": "2.0.0"}}}`,
+	} {
+		_, err := loadReference([]byte(ref))
+		assert.Error(t, err, ref)
+	}
+}
+
+func TestPropertySpec(t *testing.T) {
+	spec, ok := PropertySpec("line-cap")
+	if assert.True(t, ok) {
+		assert.Equal(t, "line-cap", spec.Name)
+		assert.Equal(t, "keyword", spec.Type)
+		assert.Equal(t, []string{"round", "butt", "square"}, spec.Keywords)
+		assert.Equal(t, "butt", spec.Default)
+		assert.NotEmpty(t, spec.Doc)
+		assert.Equal(t, "2.0.0", spec.Since)
+		assert.True(t, spec.IsDefault("butt"))
+		assert.False(t, spec.IsDefault("round"))
+		assert.False(t, spec.IsDefault(1.0))
+
+		// keywords are copied
+		spec.Keywords[0] = "foo"
+		spec, _ = PropertySpec("line-cap")
+		assert.Equal(t, "round", spec.Keywords[0])
+	}
+
+	spec, _ = PropertySpec("line-width")
+	assert.Equal(t, "pixels", spec.Unit)
+	assert.True(t, spec.IsDefault(1.0))
+	assert.False(t, spec.IsDefault([]Value{1.0}))
+
+	spec, _ = PropertySpec("text-fill")
+	assert.True(t, spec.IsDefault(color.MustParse("#000")))
+	assert.False(t, spec.IsDefault(color.MustParse("#001")))
+
+	spec, _ = PropertySpec("line-dasharray")
+	assert.Nil(t, spec.Default)
+	assert.False(t, spec.IsDefault(nil))
+
+	_, ok = PropertySpec("line-foo")
+	assert.False(t, ok)
+
+	for _, name := range PropertyNames() {
+		spec, ok := PropertySpec(name)
+		assert.True(t, ok, name)
+		assert.NotEmpty(t, spec.Doc, name)
+	}
+}
+
 func decodeLayerProperties(t *testing.T, mss string) *Properties {
 	d, err := decodeString(mss)
 	assert.NoError(t, err)
@@ -325,6 +755,155 @@ func decodeLayerProperties(t *testing.T, mss string) *Properties {
 	return r[0].Properties
 }
 
+func TestRegisterFunction(t *testing.T) {
+	palette := map[string]color.Color{"primary": color.MustParse("#c00")}
+	d := NewDecoder()
+	err := d.RegisterFunction("brand", Function{
+		Args: []ArgType{StringArg},
+		Fn: func(args []Value) (Value, error) {
+			c, ok := palette[args[0].(string)]
+			if !ok {
+				return nil, fmt.Errorf("unknown brand color %s", args[0])
+			}
+			return c, nil
+		},
+	})
+	assert.NoError(t, err)
+	err = d.RegisterFunction("sum", Function{
+		Args:     []ArgType{NumberArg, NumberArg},
+		Variadic: true,
+		Fn: func(args []Value) (Value, error) {
+			sum := 0.0
+			for _, v := range args {
+				sum += v.(float64)
+			}
+			return sum, nil
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, d.RegisterFunction("invalid", Function{Args: []ArgType{AnyArg}, Fn: func([]Value) (Value, error) { return 1, nil }}))
+	assert.EqualError(t, d.RegisterFunction("sum", Function{Fn: func([]Value) (Value, error) { return nil, nil }}), "function sum already defined")
+	assert.EqualError(t, d.RegisterFunction("lighten", Function{Fn: func([]Value) (Value, error) { return nil, nil }}), "function lighten already defined")
+	for _, name := range []string{"rgba", "hwb", "oklch", "stop", "ramp", "hue", "round"} {
+		assert.EqualError(t, d.RegisterFunction(name, Function{Fn: func([]Value) (Value, error) { return nil, nil }}), "function "+name+" already defined")
+	}
+	assert.EqualError(t, d.RegisterFunction("foo", Function{}), "missing Fn for function foo")
+
+	assert.NoError(t, d.ParseString(`
+		@width: sum(1, 2, 3) * 2;
+		#roads {
+			line-color: darken(brand("primary"), 10%);
+			line-width: @width;
+			line-gap-width: sum(1);
+		}
+		#water {
+			polygon-fill: brand("unknown");
+			polygon-opacity: sum("a");
+			polygon-gamma: invalid(red);
+		}
+	`))
+	err = d.Evaluate()
+	if !assert.Error(t, err) {
+		return
+	}
+	errs := err.(ParseErrors)
+	if assert.Len(t, errs, 3) {
+		assert.Equal(t, ParseError{Line: 9, Column: 18, Err: "expression error: function brand: unknown brand color unknown"}, *errs[0])
+		assert.Equal(t, ParseError{Line: 10, Column: 21, Err: `expression error: function sum requires number as argument, got {" a}`}, *errs[1])
+		assert.Equal(t, ParseError{Line: 11, Column: 19, Err: "expression error: function invalid returned unsupported value 1 (int)"}, *errs[2])
+	}
+
+	rules := d.MSS().LayerRules("roads")
+	assertRulesEq(t, rules, []Rule{
+		{Layer: "roads", Zoom: AllZoom, Properties: NewProperties(
+			"line-color", color.Darken(color.MustParse("#c00"), 0.1),
+			"line-width", 12.0,
+			"line-gap-width", 1.0,
+		)},
+	})
+}
+
+func TestSetVar(t *testing.T) {
+	d := NewDecoder()
+	assert.NoError(t, d.SetVar("background", color.MustParse("#222")))
+	assert.NoError(t, d.SetVar("width", 3.0))
+	assert.NoError(t, d.SetVar("dashes", []Value{2.0, 4.0}))
+	assert.NoError(t, d.SetVar("unused", "foo"))
+	assert.EqualError(t, d.SetVar("struct", struct{}{}), "unsupported type struct {} for var struct")
+	assert.EqualError(t, d.SetVar("list", []Value{1.0, struct{}{}}), "unsupported type struct {} in list for var list")
+
+	assert.NoError(t, d.ParseString(`
+		@background: #fff;
+		@road: darken(@background, 10%);
+		@width: 1;
+		#roads {
+			line-color: @road;
+			line-width: @width * 2;
+			line-dasharray: @dashes;
+		}
+	`))
+	if !assert.NoError(t, d.Evaluate()) {
+		return
+	}
+	rules := d.MSS().LayerRules("roads")
+	assertRulesEq(t, rules, []Rule{
+		{Layer: "roads", Zoom: AllZoom, Properties: NewProperties(
+			"line-color", color.Darken(color.MustParse("#222"), 0.1),
+			"line-width", 6.0,
+			"line-dasharray", []Value{2.0, 4.0},
+		)},
+	})
+
+	warnings := d.Warnings()
+	if assert.Len(t, warnings, 1) {
+		assert.Equal(t, UnusedOverride, warnings[0].Code)
+		assert.Equal(t, SeverityWarning, warnings[0].Severity)
+		assert.Equal(t, "variable override @unused is not used by any stylesheet", warnings[0].Message)
+		assert.Equal(t, Range{}, warnings[0].Range)
+		assert.Equal(t, "variable override @unused is not used by any stylesheet", warnings[0].String())
+	}
+}
+
+func TestSetVarConversion(t *testing.T) {
+	// values as decoded from JSON or YAML
+	d := NewDecoder()
+	assert.NoError(t, d.SetVar("width", 3))
+	assert.NoError(t, d.SetVar("offset", int64(-2)))
+	assert.NoError(t, d.SetVar("road", "#ff9933"))
+	assert.NoError(t, d.SetVar("casing", "rgb(0, 0, 255)"))
+	assert.NoError(t, d.SetVar("font", "Noto Sans"))
+	assert.NoError(t, d.SetVar("tag", "#hashtag"))
+	assert.NoError(t, d.SetVar("dashes", []interface{}{2, 4.5}))
+
+	assert.NoError(t, d.ParseString(`
+		@width: 1; @offset: 0; @road: #fff; @casing: #000;
+		@font: "Arial"; @tag: "none"; @dashes: 1, 1;
+		#roads {
+			line-width: @width * 2;
+			line-offset: @offset;
+			line-color: @road;
+			casing/line-color: @casing;
+			line-dasharray: @dashes;
+			text-face-name: @font;
+			text-name: @tag;
+		}
+	`))
+	if !assert.NoError(t, d.Evaluate()) {
+		return
+	}
+	p := d.MSS().LayerRules("roads")[0].Properties
+	v, _ := p.GetFloat("line-width")
+	assert.Equal(t, 6.0, v)
+	v, _ = p.GetFloat("line-offset")
+	assert.Equal(t, -2.0, v)
+	c, _ := p.GetColor("line-color")
+	assert.Equal(t, "#ff9933", c.String())
+	assert.Equal(t, "#0000ff", d.Vars().getKey(key{name: "casing"}).(color.Color).String())
+	assert.Equal(t, []Value{2.0, 4.5}, d.Vars().getKey(key{name: "dashes"}))
+	assert.Equal(t, "Noto Sans", d.Vars().getKey(key{name: "font"}))
+	assert.Equal(t, "#hashtag", d.Vars().getKey(key{name: "tag"}))
+}
+
 func TestParseInstanceProperties(t *testing.T) {
 	p := decodeLayerProperties(t, `#foo { a/foo: 2; foo: 1 }`)
 	assert.Equal(t, 2.0, p.getKey(key{name: "foo", instance: "a"}))
@@ -386,6 +965,66 @@ func TestRecursiveDeferEval(t *testing.T) {
 	assert.Equal(t, color.MustParse("blue"), c)
 }
 
+func TestImport(t *testing.T) {
+	d := NewDecoder()
+	assert.NoError(t, d.ParseFile("tests/import/main.mss"))
+	assert.NoError(t, d.Evaluate())
+	assert.Equal(t, []string{
+		"tests/import/main.mss",
+		filepath.Join("tests/import", "colors.mss"),
+		filepath.Join("tests/import", "roads/motorways.mss"),
+	}, d.Files())
+
+	rules := d.MSS().LayerRules("roads")
+	assertRulesEq(t, rules, []Rule{
+		{Layer: "roads", Filters: []Filter{{"type", EQ, "motorway"}}, Zoom: AllZoom, Properties: NewProperties("line-width", float64(4), "line-color", color.MustParse("#f93"))},
+		{Layer: "roads", Filters: []Filter{}, Zoom: AllZoom, Properties: NewProperties("line-width", float64(1), "line-color", color.MustParse("#fff"))},
+	})
+	// properties keep the filename of the imported file
+	pos := rules[0].Properties.pos(key{name: "line-width"})
+	assert.Equal(t, filepath.Join("tests/import", "roads/motorways.mss"), pos.filename)
+	assert.Equal(t, 6, pos.line)
+
+	// files from the MML that were already imported are skipped
+	assert.NoError(t, d.ParseFile("tests/import/colors.mss"))
+	assert.NoError(t, d.ParseFile("tests/import/../import/roads/motorways.mss"))
+	assert.NoError(t, d.Evaluate())
+	assert.Len(t, d.Files(), 3)
+	assert.Len(t, d.Stylesheets(), 3)
+	assert.Len(t, d.MSS().LayerRules("roads"), 2)
+
+	// escaped quotes and backslashes of the path are resolved
+	d = NewDecoder()
+	d.skipImports = true
+	assert.NoError(t, d.ParseString(`@import "it\"s\\.mss";`))
+	assert.Equal(t, `it"s\.mss`, d.Stylesheets()[0].Nodes[0].(*Import).Path)
+}
+
+func TestImportErrors(t *testing.T) {
+	d := NewDecoder()
+	err := d.ParseFile("tests/import/cycle-a.mss")
+	if assert.Error(t, err) {
+		errs := err.(ParseErrors)
+		if assert.Len(t, errs, 1) {
+			assert.Contains(t, errs[0].Err, "import cycle")
+			assert.Equal(t, filepath.Join("tests/import", "cycle-b.mss"), errs[0].Filename)
+		}
+	}
+	// both files are parsed regardless of the cycle, b is imported before #a
+	assert.Equal(t, []string{"b", "a"}, d.MSS().Layers())
+
+	d = NewDecoder()
+	err = d.ParseString(`@import "tests/import/missing.mss";`)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to import tests/import/missing.mss")
+	}
+
+	// @import is a regular variable if not followed by a string
+	d, err = decodeString(`@import: 1; #foo { line-width: @import; }`)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, d.vars.getKey(key{name: "import"}))
+}
+
 func TestParseMissingVar(t *testing.T) {
 	var err error
 	_, err = decodeString(`@foo: @bar + 1;`)
@@ -411,6 +1050,62 @@ func TestParseStopList(t *testing.T) {
 	assert.Equal(t, []Value{Stop{50, color.Color{H: 0.0, S: 0.0, L: 1.0, A: 1.0, Perceptual: false}}, Stop{100, color.Color{H: 0.0, S: 0.0, L: 0.0, A: 1.0, Perceptual: false}}}, d.vars.getKey(key{name: "foo"}))
 }
 
+func TestParseRamp(t *testing.T) {
+	stops := func(t *testing.T, v Value) []string {
+		var result []string
+		for _, s := range v.([]Value) {
+			s := s.(Stop)
+			result = append(result, fmt.Sprintf("%v %s", s.Value, s.Color))
+		}
+		return result
+	}
+
+	d, err := decodeString(`@foo: stop(0.5, #fff) ramp(10, 20, 3, #fff, #000, rgb);`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0.5 #ffffff", "10 #ffffff", "15 #808080", "20 #000000"}, stops(t, d.vars.getKey(key{name: "foo"})))
+
+	d, err = decodeString(`@foo: ramp(0, 90, 3, #fff, #000);`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0 #ffffff", "45 #636363", "90 #000000"}, stops(t, d.vars.getKey(key{name: "foo"})))
+
+	// descending values reverse the colors
+	d, err = decodeString(`@foo: ramp(90, 0, 3, #fff, #000, rgb);`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0 #000000", "45 #808080", "90 #ffffff"}, stops(t, d.vars.getKey(key{name: "foo"})))
+
+	d, err = decodeString(`#dem { raster-colorizer-stops: ramp(0, 255, 3, rdbu); }`)
+	assert.NoError(t, err)
+	assert.NoError(t, d.Evaluate())
+	l, ok := d.MSS().LayerRules("dem")[0].Properties.GetStopList("raster-colorizer-stops")
+	assert.True(t, ok)
+	assert.Equal(t, []Stop{
+		{0, color.MustParse("#67001f")},
+		{127.5, color.MustParse("#f7f7f7")},
+		{255, color.MustParse("#053061")},
+	}, l)
+
+	for _, tt := range []struct {
+		mss string
+		err string
+	}{
+		{`@foo: ramp(0, 1, 2);`, "function ramp takes at least four arguments, got 3"},
+		{`@foo: ramp(0, "1", 2, reds);`, "function ramp requires number as second argument"},
+		{`@foo: ramp(0, 1, 2.5, reds);`, "function ramp requires an integer of at least 2 as third argument"},
+		{`@foo: ramp(0, 1, 1, reds);`, "function ramp requires an integer of at least 2 as third argument"},
+		{`@foo: ramp(0, 1, 3, unknown);`, "function ramp requires a known palette"},
+		{`@foo: ramp(0, 1, 3, #fff);`, "function ramp requires a palette or at least two colors"},
+		{`@foo: ramp(0, 1, 3, #fff, 2, lab);`, "function ramp requires color as argument 5"},
+		{`@foo: ramp(0, 0, 3, reds);`, "function ramp requires different start and end values, got 0"},
+	} {
+		t.Run(tt.mss, func(t *testing.T) {
+			_, err := decodeString(tt.mss)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
 func TestParseNull(t *testing.T) {
 	d, err := decodeString(`@foo: null;
     #foo[type!=null]{line-width: 1}
@@ -582,7 +1277,7 @@ func TestDecoderClasses(t *testing.T) {
 	var rules []Rule
 	rules = loadRules(t, "./tests/014-classes.mss", "lakes", "land")
 	assertRulesEq(t, rules, []Rule{
-		{Layer: "lakes", Class: "land", Attachment: "", Filters: []Filter{}, Zoom: AllZoom, Properties: NewProperties("line-width", float64(0.5), "line-color", color.Color{H: 0.0, S: 1.0, L: 0.5, A: 1.0, Perceptual: false}, "polygon-fill", color.Color{H: 240.0, S: 1.0, L: 0.5, A: 1.0, Perceptual: false})},
+		{Layer: "lakes", Classes: []string{"land"}, Attachment: "", Filters: []Filter{}, Zoom: AllZoom, Properties: NewProperties("line-width", float64(0.5), "line-color", color.Color{H: 0.0, S: 1.0, L: 0.5, A: 1.0, Perceptual: false}, "polygon-fill", color.Color{H: 240.0, S: 1.0, L: 0.5, A: 1.0, Perceptual: false})},
 	})
 
 	// basin class is inside water, no match
@@ -591,17 +1286,104 @@ func TestDecoderClasses(t *testing.T) {
 
 	rules = loadRules(t, "./tests/014-classes.mss", "", "water")
 	assertRulesEq(t, rules, []Rule{
-		{Layer: "", Class: "water", Attachment: "", Filters: []Filter{}, Zoom: AllZoom, Properties: NewProperties("polygon-fill", color.Color{H: 120.0, S: 1.0, L: 0.5, A: 1.0, Perceptual: false}, "line-width", float64(1))},
+		{Layer: "", Classes: []string{"water"}, Attachment: "", Filters: []Filter{}, Zoom: AllZoom, Properties: NewProperties("polygon-fill", color.Color{H: 120.0, S: 1.0, L: 0.5, A: 1.0, Perceptual: false}, "line-width", float64(1))},
 	})
 
 	// return .water.basin property regardless of requested class order
 	rules = loadRules(t, "./tests/014-classes.mss", "", "basin", "water")
 	assertRulesEq(t, rules, []Rule{
-		{Layer: "", Class: "basin", Attachment: "", Filters: []Filter{}, Zoom: AllZoom, Properties: NewProperties("polygon-fill", color.Color{H: 0.0, S: 0.0, L: 1.0, A: 1.0, Perceptual: false}, "line-width", float64(1), "polygon-opacity", float64(0.5))},
+		{Layer: "", Classes: []string{"basin", "water"}, Attachment: "", Filters: []Filter{}, Zoom: AllZoom, Properties: NewProperties("polygon-fill", color.Color{H: 0.0, S: 0.0, L: 1.0, A: 1.0, Perceptual: false}, "line-width", float64(1), "polygon-opacity", float64(0.5))},
 	})
 	rules = loadRules(t, "./tests/014-classes.mss", "", "water", "basin")
 	assertRulesEq(t, rules, []Rule{
-		{Layer: "", Class: "water", Attachment: "", Filters: []Filter{}, Zoom: AllZoom, Properties: NewProperties("polygon-fill", color.Color{H: 0.0, S: 0.0, L: 1.0, A: 1.0, Perceptual: false}, "line-width", float64(1), "polygon-opacity", float64(0.5))},
+		{Layer: "", Classes: []string{"basin", "water"}, Attachment: "", Filters: []Filter{}, Zoom: AllZoom, Properties: NewProperties("polygon-fill", color.Color{H: 0.0, S: 0.0, L: 1.0, A: 1.0, Perceptual: false}, "line-width", float64(1), "polygon-opacity", float64(0.5))},
+	})
+
+}
+
+func TestDecoderCompoundClasses(t *testing.T) {
+	d, err := decodeString(`
+		#roads { line-width: 1; line-color: #000; }
+		#roads.bridge.major { line-width: 6; }
+		#roads.major { line-width: 4; }
+		#roads.bridge { line-color: #f00; line-width: 2; }
+		.major { line-cap: round; line-width: 10; }
+	`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assertRulesEq(t, d.MSS().LayerRules("roads"), []Rule{
+		{Layer: "roads", Zoom: AllZoom, Properties: NewProperties("line-width", 1.0, "line-color", color.MustParse("#000"))},
 	})
+	assertRulesEq(t, d.MSS().LayerRules("roads", "major"), []Rule{
+		{Layer: "roads", Classes: []string{"major"}, Zoom: AllZoom, Properties: NewProperties("line-width", 4.0, "line-color", color.MustParse("#000"), "line-cap", "round")},
+	})
+	assertRulesEq(t, d.MSS().LayerRules("roads", "bridge"), []Rule{
+		{Layer: "roads", Classes: []string{"bridge"}, Zoom: AllZoom, Properties: NewProperties("line-width", 2.0, "line-color", color.MustParse("#f00"))},
+	})
+	// the compound selector is more specific, regardless of the order in the file or of the layer classes
+	for _, classes := range [][]string{{"bridge", "major"}, {"major", "bridge"}, {"tunnel", "major", "bridge"}} {
+		assertRulesEq(t, d.MSS().LayerRules("roads", classes...), []Rule{
+			{Layer: "roads", Classes: []string{"bridge", "major"}, Zoom: AllZoom, Properties: NewProperties("line-width", 6.0, "line-color", color.MustParse("#f00"), "line-cap", "round")},
+		})
+	}
+}
 
+func TestDecoderNestedAttachments(t *testing.T) {
+	d, err := decodeString(`
+		#roads {
+			line-width: 1;
+			::casing {
+				line-width: 3;
+				::glow { line-width: 5; }
+			}
+			::fill { line-width: 2; }
+			::glow { line-color: red; }
+		}
+		#roads::b, #roads::a {
+			[type='x'] { line-width: 6; }
+		}
+	`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// default first, then in order of first appearance, nested after parent
+	assertRulesEq(t, d.MSS().LayerRules("roads"), []Rule{
+		{Layer: "roads", Zoom: AllZoom, Properties: NewProperties("line-width", 1.0)},
+		{Layer: "roads", Attachment: "casing", Zoom: AllZoom, Properties: NewProperties("line-width", 3.0)},
+		{Layer: "roads", Attachment: "casing/glow", Zoom: AllZoom, Properties: NewProperties("line-width", 5.0)},
+		{Layer: "roads", Attachment: "fill", Zoom: AllZoom, Properties: NewProperties("line-width", 2.0)},
+		{Layer: "roads", Attachment: "glow", Zoom: AllZoom, Properties: NewProperties("line-color", color.MustParse("red"))},
+		{Layer: "roads", Attachment: "b", Filters: []Filter{{"type", EQ, "x"}}, Zoom: AllZoom, Properties: NewProperties("line-width", 6.0)},
+		{Layer: "roads", Attachment: "a", Filters: []Filter{{"type", EQ, "x"}}, Zoom: AllZoom, Properties: NewProperties("line-width", 6.0)},
+	})
+}
+
+func TestDecoderRuleDoc(t *testing.T) {
+	d, err := decodeString(`
+		/** @desc Roads @source osm */
+		#roads {
+			line-width: 1;
+			/** @desc Motorways */
+			[type='motorway'] { line-width: 3; }
+		}
+		#roads[zoom>=10] { line-color: red; }
+	`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	rules := d.MSS().LayerRules("roads")
+	assertRulesEq(t, rules, []Rule{
+		{Layer: "roads", Filters: []Filter{{"type", EQ, "motorway"}}, Zoom: NewZoomRange(GTE, 10), Properties: NewProperties("line-width", 3.0, "line-color", color.MustParse("red"))},
+		{Layer: "roads", Filters: []Filter{{"type", EQ, "motorway"}}, Zoom: AllZoom, Properties: NewProperties("line-width", 3.0)},
+		{Layer: "roads", Zoom: NewZoomRange(GTE, 10), Properties: NewProperties("line-width", 1.0, "line-color", color.MustParse("red"))},
+		{Layer: "roads", Zoom: AllZoom, Properties: NewProperties("line-width", 1.0)},
+	})
+	if len(rules) != 4 {
+		return
+	}
+	motorways := map[string]string{"desc": "Motorways", "source": "osm"}
+	assert.Equal(t, motorways, rules[0].Doc)
+	assert.Equal(t, motorways, rules[1].Doc)
+	assert.Equal(t, map[string]string{"desc": "Roads", "source": "osm"}, rules[3].Doc)
 }
@@ -2,6 +2,8 @@ package cartocss
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/flywave/go-cartocss/color"
 )
@@ -24,6 +26,7 @@ const (
 	typeString
 	typeList
 	typeStop
+	typeInterpolation
 
 	typeNegation
 	typeAdd
@@ -70,6 +73,8 @@ func (t codeType) String() string {
 		return "\""
 	case typeStop:
 		return "S"
+	case typeInterpolation:
+		return "I"
 	case typeUnknown:
 		return "?"
 	default:
@@ -90,12 +95,21 @@ func (e *expression) addValue(val interface{}, t codeType) {
 	e.code = append(e.code, code{T: t, Value: val})
 }
 
+func (e *expression) addFunction(name string, pos position) {
+	e.code = append(e.code, code{T: typeFunction, Value: name, pos: pos})
+}
+
 func (e *expression) Clear() {
 	e.code = e.code[:0]
 }
 
 func (e *expression) evaluate() (Value, error) {
-	codes, _, err := evaluate(e.code)
+	return e.evaluateWith(nil)
+}
+
+// evaluateWith evaluates the expression with additional custom functions.
+func (e *expression) evaluateWith(funcs map[string]Function) (Value, error) {
+	codes, _, err := evaluate(e.code, funcs)
 	if err != nil {
 		return nil, err
 	}
@@ -115,304 +129,471 @@ func (e *expression) evaluate() (Value, error) {
 
 type Field string
 
-func evaluate(codes []code) ([]code, int, error) {
-	top := 0
+// funcError is an error of a function call, with the position of the call.
+type funcError struct {
+	pos position
+	err error
+}
+
+func (e *funcError) Error() string {
+	return e.err.Error()
+}
+
+func evaluate(codes []code, funcs map[string]Function) ([]code, int, error) {
+	// results are collected in a separate stack, as functions can return
+	// more values than they take, eg. ramp
+	stack := make([]code, 0, len(codes))
 	for i := 0; i < len(codes); i++ {
 		c := codes[i]
 		switch c.T {
-		case typeNum, typeColor, typePercent, typeString, typeKeyword, typeURL, typeBool, typeField, typeList:
-			codes[top] = c
-			top++
+		case typeNum, typeColor, typePercent, typeString, typeKeyword, typeURL, typeBool, typeField, typeList, typeInterpolation:
+			stack = append(stack, c)
 			continue
 		case typeNegation:
-			a := codes[top-1]
-			a.Value = -a.Value.(float64)
-			codes[top-1] = a
+			stack[len(stack)-1].Value = -stack[len(stack)-1].Value.(float64)
 			continue
 		case typeFunction:
-			v, parsed, err := evaluate(codes[i+1:])
+			v, parsed, err := evaluate(codes[i+1:], funcs)
 			i += parsed + 1
 			if err != nil {
 				return v, 0, err
 			}
-			// TODO refactor parameter checking
-			if colorF, ok := colorFuncs[c.Value.(string)]; ok {
-				if len(v) != 2 {
-					return nil, 0, fmt.Errorf("function %s takes exactly two arguments, got %d", c.Value.(string), len(v))
-				}
-				if v[0].T != typeColor {
-					return nil, 0, fmt.Errorf("function %s requires color as first argument, got %v", c.Value.(string), v[0])
-				}
-				if v[1].T != typeNum && v[1].T != typePercent {
-					return nil, 0, fmt.Errorf("function %s requires number/percent as second argument, got %v", c.Value.(string), v[1])
-				}
-				v = []code{{Value: colorF(v[0].Value.(color.Color), v[1].Value.(float64)/100), T: typeColor}}
-			} else if colorP, ok := colorParams[c.Value.(string)]; ok {
-				if len(v) != 1 {
-					return nil, 0, fmt.Errorf("function %s takes exactly one argument, got %d", c.Value.(string), len(v))
-				}
-				if v[0].T != typeColor {
-					return nil, 0, fmt.Errorf("function %s requires color as argument, got %v", c.Value.(string), v[0])
-				}
-				v = []code{{Value: colorP(v[0].Value.(color.Color)), T: typeNum}}
-			} else if c.Value.(string) == "mix" {
-				if len(v) != 3 {
-					return nil, 0, fmt.Errorf("function mix takes exactly three arguments, got %d", len(v))
-				}
-				if v[0].T != typeColor || v[1].T != typeColor {
-					return nil, 0, fmt.Errorf("function mix requires color as first and second argument, got %v and %v", v[0], v[1])
-				}
-				if v[2].T != typeNum && v[2].T != typePercent {
-					return nil, 0, fmt.Errorf("function mix requires number/percent as third argument, got %v", v[2])
-				}
-				v = []code{{Value: color.Mix(v[0].Value.(color.Color), v[1].Value.(color.Color), v[2].Value.(float64)/100), T: typeColor}}
-			} else if c.Value.(string) == "-mc-set-hue" {
-				if len(v) != 2 {
-					return nil, 0, fmt.Errorf("function %s takes exactly two arguments, got %d", c.Value.(string), len(v))
-				}
-				if v[0].T != typeColor {
-					return nil, 0, fmt.Errorf("function %s requires color as first argument, got %v", c.Value.(string), v[0])
-				}
-				if v[1].T != typeColor {
-					return nil, 0, fmt.Errorf("function %s requires color as second argument, got %v", c.Value.(string), v[1])
-				}
-				v = []code{{Value: color.SetHue(v[0].Value.(color.Color), v[1].Value.(color.Color)), T: typeColor}}
-			} else if c.Value.(string) == "greyscale" || c.Value.(string) == "greyscalep" {
-				if len(v) != 1 {
-					return nil, 0, fmt.Errorf("function %s takes exactly one argument, got %d", c.Value.(string), len(v))
-				}
-				if v[0].T != typeColor {
-					return nil, 0, fmt.Errorf("function %s requires color as argument, got %v", c.Value.(string), v[0])
-				}
-				if c.Value.(string) == "greyscale" {
-					v = []code{{Value: color.Greyscale(v[0].Value.(color.Color)), T: typeColor}}
-				} else {
-					v = []code{{Value: color.GreyscaleP(v[0].Value.(color.Color)), T: typeColor}}
-				}
-			} else if c.Value.(string) == "rgb" || c.Value.(string) == "rgba" {
-				if c.Value.(string) == "rgb" && len(v) != 3 {
-					return nil, 0, fmt.Errorf("rgb takes exactly three arguments, got %d", len(v))
-				}
-				if c.Value.(string) == "rgba" && len(v) != 4 {
-					return nil, 0, fmt.Errorf("rgba takes exactly four arguments, got %d", len(v))
-				}
-				c := [4]float64{1, 1, 1, 1}
-				for i := range v {
-					switch v[i].T {
-					case typeNum:
-						if i < 3 {
-							c[i] = v[i].Value.(float64) / 255
-						} else {
-							c[i] = v[i].Value.(float64) // alpha value is from 0.0-1.0
-							if c[i] > 1.0 {
-								c[i] /= 255 // TODO or clamp? compat with Carto?
-							}
-						}
-					case typePercent:
-						c[i] = v[i].Value.(float64) / 100
-					default:
-						return nil, 0, fmt.Errorf("rgb/rgba takes float or percent arguments only, got %v", v[i])
-					}
-					if c[i] < 0 {
-						c[i] = 0
-					} else if c[i] > 255 {
-						c[i] = 255
-					}
-				}
-				v = []code{{Value: color.FromRgba(c[0], c[1], c[2], c[3], false), T: typeColor}}
-			} else if c.Value.(string) == "hsl" || c.Value.(string) == "hsla" {
-				if c.Value.(string) == "hsl" && len(v) != 3 {
-					return nil, 0, fmt.Errorf("hsl takes exactly three arguments, got %d", len(v))
-				}
-				if c.Value.(string) == "hsla" && len(v) != 4 {
-					return nil, 0, fmt.Errorf("hsla takes exactly four arguments, got %d", len(v))
-				}
-				c := [4]float64{1, 1, 1, 1}
-				for i := range v {
-					switch v[i].T {
-					case typeNum:
-						if i == 0 {
-							c[i] = v[i].Value.(float64)
-						} else {
-							c[i] = v[i].Value.(float64) // saturation, lightness, alpha values are from 0.0-1.0
-							if c[i] > 1.0 {
-								c[i] = 1.0
-							} else if c[i] < 0 {
-								c[i] = 0
-							}
-						}
-					case typePercent:
-						if i == 0 {
-							c[i] = v[i].Value.(float64) / 360
-							if c[i] < 0 {
-								c[i] = 0
-							} else if c[i] > 100 {
-								c[i] = 1.0
-							}
-						} else {
-							c[i] = v[i].Value.(float64) / 100
-							if c[i] < 0 {
-								c[i] = 0
-							} else if c[i] > 100 {
-								c[i] = 1.0
-							}
-						}
-					default:
-						return nil, 0, fmt.Errorf("hsl/hsla takes float or percent arguments only, got %v", v[i])
-					}
-				}
-				v = []code{{Value: color.FromHsla(c[0], c[1], c[2], c[3]), T: typeColor}}
-			} else if c.Value.(string) == "husl" || c.Value.(string) == "husla" {
-				if c.Value.(string) == "husl" && len(v) != 3 {
-					return nil, 0, fmt.Errorf("husl takes exactly three arguments, got %d", len(v))
-				}
-				if c.Value.(string) == "husla" && len(v) != 4 {
-					return nil, 0, fmt.Errorf("husla takes exactly four arguments, got %d", len(v))
-				}
-				c := [4]float64{1, 1, 1, 1}
-				for i := range v {
-					switch v[i].T {
-					case typeNum:
-						if i == 0 {
-							c[i] = v[i].Value.(float64)
-						} else {
-							c[i] = v[i].Value.(float64) // saturation, lightness, alpha values are from 0.0-1.0
-							if c[i] > 1.0 {
-								c[i] = 1.0
-							} else if c[i] < 0 {
-								c[i] = 0
-							}
-						}
-					case typePercent:
-						if i == 0 {
-							c[i] = v[i].Value.(float64) / 360
-							if c[i] < 0 {
-								c[i] = 0
-							} else if c[i] > 100 {
-								c[i] = 1.0
-							}
-						} else {
-							c[i] = v[i].Value.(float64) / 100
-							if c[i] < 0 {
-								c[i] = 0
-							} else if c[i] > 100 {
-								c[i] = 1.0
-							}
-						}
-					default:
-						return nil, 0, fmt.Errorf("husl/husla takes float or percent arguments only, got %v", v[i])
-					}
-				}
-				v = []code{{Value: color.FromHusl(c[0], c[1], c[2], c[3]), T: typeColor}}
-			} else if c.Value.(string) == "stop" {
-				if len(v) != 2 {
-					return nil, 0, fmt.Errorf("stop takes exactly two arguments, got %d", len(v))
-				}
-				if v[0].T != typeNum {
-					return nil, 0, fmt.Errorf("stop takes int as first argument only, got %v", v[i])
-				}
-				if v[1].T != typeColor {
-					return nil, 0, fmt.Errorf("stop takes color as second argument only, got %v", v[i])
-				}
-				val := int(v[0].Value.(float64))
-				c := v[1].Value.(color.Color)
-				v = []code{{
-					Value: Stop{Value: val, Color: c},
-					T:     typeStop},
-				}
-			} else if c.Value.(string) == "__echo__" {
-				// pass
-			} else {
-				return nil, 0, fmt.Errorf("unknown function %s", c.Value.(string))
-			}
-			for i, v := range v {
-				codes[top+i] = v
+			v, err = evalFunction(c, v, funcs)
+			if err != nil {
+				return nil, 0, &funcError{pos: c.pos, err: err}
 			}
-			top += len(v)
+			stack = append(stack, v...)
 		case typeFunctionEnd:
-			return codes[0:top], i, nil
+			return stack, i, nil
 		case typeAdd, typeSubtract, typeMultiply, typeDivide:
-			a, b := codes[top-2], codes[top-1]
-			top -= 2
+			a, b := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-2]
+			var result code
 			if a.T == typeNum && b.T == typeNum {
 				switch c.T {
 				case typeAdd:
-					codes[top] = code{T: typeNum, Value: a.Value.(float64) + b.Value.(float64)}
+					result = code{T: typeNum, Value: a.Value.(float64) + b.Value.(float64)}
 				case typeSubtract:
-					codes[top] = code{T: typeNum, Value: a.Value.(float64) - b.Value.(float64)}
+					result = code{T: typeNum, Value: a.Value.(float64) - b.Value.(float64)}
 				case typeMultiply:
-					codes[top] = code{T: typeNum, Value: a.Value.(float64) * b.Value.(float64)}
+					result = code{T: typeNum, Value: a.Value.(float64) * b.Value.(float64)}
 				case typeDivide:
-					codes[top] = code{T: typeNum, Value: a.Value.(float64) / b.Value.(float64)}
+					result = code{T: typeNum, Value: a.Value.(float64) / b.Value.(float64)}
 				}
 			} else if c.T == typeAdd && a.T == typeString && b.T == typeString {
 				// string concatenation
-				codes[top] = code{T: typeString, Value: a.Value.(string) + b.Value.(string)}
+				result = code{T: typeString, Value: a.Value.(string) + b.Value.(string)}
 			} else if c.T == typeAdd && a.T == typeString && b.T == typeField {
-				codes[top] = code{T: typeFieldExpr, Value: []Value{a.Value.(string), Field(b.Value.(string))}}
+				result = code{T: typeFieldExpr, Value: []Value{a.Value.(string), Field(b.Value.(string))}}
 			} else if c.T == typeAdd && a.T == typeField && b.T == typeString {
-				codes[top] = code{T: typeFieldExpr, Value: []Value{Field(a.Value.(string)), b.Value.(string)}}
+				result = code{T: typeFieldExpr, Value: []Value{Field(a.Value.(string)), b.Value.(string)}}
 			} else if c.T == typeAdd && a.T == typeField && b.T == typeField {
-				codes[top] = code{T: typeFieldExpr, Value: []Value{Field(a.Value.(string)), Field(b.Value.(string))}}
+				result = code{T: typeFieldExpr, Value: []Value{Field(a.Value.(string)), Field(b.Value.(string))}}
 			} else if c.T == typeAdd && a.T == typeFieldExpr && b.T == typeField {
-				codes[top] = code{T: typeFieldExpr, Value: append(a.Value.([]Value), Field(b.Value.(string)))}
+				result = code{T: typeFieldExpr, Value: append(a.Value.([]Value), Field(b.Value.(string)))}
 			} else if c.T == typeAdd && a.T == typeFieldExpr && b.T == typeString {
-				codes[top] = code{T: typeFieldExpr, Value: append(a.Value.([]Value), b.Value.(string))}
+				result = code{T: typeFieldExpr, Value: append(a.Value.([]Value), b.Value.(string))}
 			} else if c.T == typeMultiply && a.T == typeColor && b.T == typeNum {
 				c := a.Value.(color.Color)
 				f := b.Value.(float64)
 				c = color.Multiply(c, f)
-				codes[top] = code{T: typeColor, Value: c}
+				result = code{T: typeColor, Value: c}
 			} else {
 				return nil, 0, fmt.Errorf("unsupported operation %v for %v and %v", c, a, b)
 			}
-			top++
+			stack = append(stack, result)
+		}
+	}
+	return stack, 0, nil
+}
+
+// evalFunction returns the result of the function c for the arguments v.
+func evalFunction(c code, v []code, funcs map[string]Function) ([]code, error) {
+	name := c.Value.(string)
+	if f, ok := funcs[name]; ok {
+		return f.call(name, v)
+	}
+	if f, ok := builtins[name]; ok {
+		return f(name, v)
+	}
+	return nil, fmt.Errorf("unknown function %s", name)
+}
+
+// mixFunc mixes two colors, optionally in the color space of the fourth
+// argument (see mixSpaces).
+func mixFunc(name string, v []code) ([]code, error) {
+	if len(v) != 3 && len(v) != 4 {
+		return nil, fmt.Errorf("function mix takes three or four arguments, got %d", len(v))
+	}
+	if v[0].T != typeColor || v[1].T != typeColor {
+		return nil, fmt.Errorf("function mix requires color as first and second argument, got %v and %v", v[0], v[1])
+	}
+	if v[2].T != typeNum && v[2].T != typePercent {
+		return nil, fmt.Errorf("function mix requires number/percent as third argument, got %v", v[2])
+	}
+	mix := color.Mix
+	if len(v) == 4 {
+		var ok bool
+		if v[3].T == typeKeyword {
+			mix, ok = mixSpaces[v[3].Value.(string)]
+		}
+		if !ok {
+			return nil, fmt.Errorf("function mix requires rgb, lab, lch, oklab or oklch as fourth argument, got %v", v[3])
+		}
+	}
+	return []code{{Value: mix(v[0].Value.(color.Color), v[1].Value.(color.Color), v[2].Value.(float64)/100), T: typeColor}}, nil
+}
+
+// setHueFunc returns the first color with the hue of the second color.
+func setHueFunc(name string, v []code) ([]code, error) {
+	if len(v) != 2 {
+		return nil, fmt.Errorf("function %s takes exactly two arguments, got %d", name, len(v))
+	}
+	if v[0].T != typeColor {
+		return nil, fmt.Errorf("function %s requires color as first argument, got %v", name, v[0])
+	}
+	if v[1].T != typeColor {
+		return nil, fmt.Errorf("function %s requires color as second argument, got %v", name, v[1])
+	}
+	return []code{{Value: color.SetHue(v[0].Value.(color.Color), v[1].Value.(color.Color)), T: typeColor}}, nil
+}
+
+// greyscaleFunc removes the saturation of a color.
+func greyscaleFunc(name string, v []code) ([]code, error) {
+	if len(v) != 1 {
+		return nil, fmt.Errorf("function %s takes exactly one argument, got %d", name, len(v))
+	}
+	if v[0].T != typeColor {
+		return nil, fmt.Errorf("function %s requires color as argument, got %v", name, v[0])
+	}
+	if name == "greyscalep" {
+		return []code{{Value: color.GreyscaleP(v[0].Value.(color.Color)), T: typeColor}}, nil
+	}
+	return []code{{Value: color.Greyscale(v[0].Value.(color.Color)), T: typeColor}}, nil
+}
+
+// rgbFunc returns a color of rgb or rgba, they are aliases in CSS Color
+// Level 4.
+func rgbFunc(name string, v []code) ([]code, error) {
+	if len(v) != 3 && len(v) != 4 {
+		return nil, fmt.Errorf("%s takes three or four arguments, got %d", name, len(v))
+	}
+	c := [4]float64{1, 1, 1, 1}
+	for i := range v {
+		switch v[i].T {
+		case typeNum:
+			if i < 3 {
+				c[i] = v[i].Value.(float64) / 255
+			} else {
+				c[i] = v[i].Value.(float64) // alpha value is from 0.0-1.0
+				if c[i] > 1.0 {
+					c[i] /= 255 // TODO or clamp? compat with Carto?
+				}
+			}
+		case typePercent:
+			c[i] = v[i].Value.(float64) / 100
+		default:
+			return nil, fmt.Errorf("rgb/rgba takes float or percent arguments only, got %v", v[i])
+		}
+		if c[i] < 0 {
+			c[i] = 0
+		} else if c[i] > 255 {
+			c[i] = 255
+		}
+	}
+	return []code{{Value: color.FromRgba(c[0], c[1], c[2], c[3], false), T: typeColor}}, nil
+}
+
+// hslFunc returns a color of hsl or hsla, they are aliases in CSS Color
+// Level 4.
+func hslFunc(name string, v []code) ([]code, error) {
+	if len(v) != 3 && len(v) != 4 {
+		return nil, fmt.Errorf("%s takes three or four arguments, got %d", name, len(v))
+	}
+	c := [4]float64{1, 1, 1, 1}
+	for i := range v {
+		switch v[i].T {
+		case typeNum:
+			if i == 0 {
+				c[i] = v[i].Value.(float64)
+			} else {
+				c[i] = v[i].Value.(float64) // saturation, lightness, alpha values are from 0.0-1.0
+				if c[i] > 1.0 {
+					c[i] = 1.0
+				} else if c[i] < 0 {
+					c[i] = 0
+				}
+			}
+		case typePercent:
+			if i == 0 {
+				c[i] = v[i].Value.(float64) / 360
+				if c[i] < 0 {
+					c[i] = 0
+				} else if c[i] > 100 {
+					c[i] = 1.0
+				}
+			} else {
+				c[i] = v[i].Value.(float64) / 100
+				if c[i] < 0 {
+					c[i] = 0
+				} else if c[i] > 100 {
+					c[i] = 1.0
+				}
+			}
+		default:
+			return nil, fmt.Errorf("hsl/hsla takes float or percent arguments only, got %v", v[i])
+		}
+	}
+	return []code{{Value: color.FromHsla(c[0], c[1], c[2], c[3]), T: typeColor}}, nil
+}
+
+// hwbFunc returns a color of hue, whiteness and blackness.
+func hwbFunc(name string, v []code) ([]code, error) {
+	if len(v) != 3 && len(v) != 4 {
+		return nil, fmt.Errorf("hwb takes three or four arguments, got %d", len(v))
+	}
+	c := [4]float64{1, 1, 1, 1}
+	for i := range v {
+		switch {
+		case v[i].T == typeNum:
+			c[i] = v[i].Value.(float64) // whiteness, blackness, alpha values are from 0.0-1.0
+		case v[i].T == typePercent && i > 0:
+			c[i] = v[i].Value.(float64) / 100
+		default:
+			return nil, fmt.Errorf("hwb takes hue and float or percent arguments only, got %v", v[i])
+		}
+		if i > 0 && c[i] > 1.0 {
+			c[i] = 1.0
+		} else if i > 0 && c[i] < 0 {
+			c[i] = 0
 		}
 	}
-	return codes[:top], 0, nil
+	return []code{{Value: color.FromHwba(c[0], c[1], c[2], c[3]), T: typeColor}}, nil
 }
 
+// huslFunc returns a perceptual color of husl or husla.
+func huslFunc(name string, v []code) ([]code, error) {
+	if name == "husl" && len(v) != 3 {
+		return nil, fmt.Errorf("husl takes exactly three arguments, got %d", len(v))
+	}
+	if name == "husla" && len(v) != 4 {
+		return nil, fmt.Errorf("husla takes exactly four arguments, got %d", len(v))
+	}
+	c := [4]float64{1, 1, 1, 1}
+	for i := range v {
+		switch v[i].T {
+		case typeNum:
+			if i == 0 {
+				c[i] = v[i].Value.(float64)
+			} else {
+				c[i] = v[i].Value.(float64) // saturation, lightness, alpha values are from 0.0-1.0
+				if c[i] > 1.0 {
+					c[i] = 1.0
+				} else if c[i] < 0 {
+					c[i] = 0
+				}
+			}
+		case typePercent:
+			if i == 0 {
+				c[i] = v[i].Value.(float64) / 360
+				if c[i] < 0 {
+					c[i] = 0
+				} else if c[i] > 100 {
+					c[i] = 1.0
+				}
+			} else {
+				c[i] = v[i].Value.(float64) / 100
+				if c[i] < 0 {
+					c[i] = 0
+				} else if c[i] > 100 {
+					c[i] = 1.0
+				}
+			}
+		default:
+			return nil, fmt.Errorf("husl/husla takes float or percent arguments only, got %v", v[i])
+		}
+	}
+	return []code{{Value: color.FromHusl(c[0], c[1], c[2], c[3]), T: typeColor}}, nil
+}
+
+// labFunc returns a color of the lab, lch, oklab or oklch function.
+func labFunc(name string, v []code) ([]code, error) {
+	args := make([]color.Channel, len(v))
+	for i := range v {
+		if v[i].T != typeNum && v[i].T != typePercent {
+			return nil, fmt.Errorf("%s takes float or percent arguments only, got %v", name, v[i])
+		}
+		args[i] = color.Channel{Value: v[i].Value.(float64), Percent: v[i].T == typePercent}
+	}
+	c, err := color.FromFunction(name, args)
+	if err != nil {
+		return nil, err
+	}
+	return []code{{Value: c, T: typeColor}}, nil
+}
+
+// stopFunc returns a stop of a raster colorizer.
+func stopFunc(name string, v []code) ([]code, error) {
+	if len(v) != 2 {
+		return nil, fmt.Errorf("stop takes exactly two arguments, got %d", len(v))
+	}
+	if v[0].T != typeNum {
+		return nil, fmt.Errorf("stop takes number as first argument only, got %v", v[0])
+	}
+	if v[1].T != typeColor {
+		return nil, fmt.Errorf("stop takes color as second argument only, got %v", v[1])
+	}
+	return []code{{
+		Value: Stop{Value: v[0].Value.(float64), Color: v[1].Value.(color.Color)},
+		T:     typeStop},
+	}, nil
+}
+
+// interpolateFunc returns a zoom interpolation (see Interpolation).
+func interpolateFunc(name string, v []code) ([]code, error) {
+	interpolation, err := newInterpolation(v)
+	if err != nil {
+		return nil, err
+	}
+	return []code{{Value: interpolation, T: typeInterpolation}}, nil
+}
+
+// Stop is a value with a color of a raster colorizer.
 type Stop struct {
-	Value int
+	Value float64
 	Color color.Color
 }
 
 type Functype func(args []code) ([]code, error)
 
-var colorFuncs map[string]colorFunc
-var colorParams map[string]colorParam
+// mixSpaces contains the color spaces for the optional fourth argument of mix
+// and the optional last argument of ramp.
+var mixSpaces = map[string]func(c1, c2 color.Color, weight float64) color.Color{
+	"rgb":   color.Mix,
+	"lab":   color.MixLab,
+	"lch":   color.MixLch,
+	"oklab": color.MixOklab,
+	"oklch": color.MixOklch,
+}
+
+// builtinFunc is a function of MSS expressions. It is called with its name,
+// for functions with aliases like rgb and rgba.
+type builtinFunc func(name string, args []code) ([]code, error)
 
-type colorFunc func(color.Color, float64) color.Color
-type colorParam func(color.Color) float64
+// builtins contains all functions of MSS expressions, besides the functions
+// of Decoder.RegisterFunction. It is the only table that is used for the
+// evaluation and to check the names of RegisterFunction.
+var builtins map[string]builtinFunc
 
 func init() {
-	colorFuncs = map[string]colorFunc{
-		"lighten":     color.Lighten,
-		"lightenp":    color.LightenP,
-		"darken":      color.Darken,
-		"darkenp":     color.DarkenP,
-		"saturate":    color.Saturate,
-		"saturatep":   color.SaturateP,
-		"desaturate":  color.Desaturate,
-		"desaturatep": color.DesaturateP,
-		"fadein":      color.FadeIn,
-		"fadeout":     color.FadeOut,
-		"spin":        color.Spin,
-		"spinp":       color.SpinP,
-	}
-
-	colorParams = map[string]colorParam{
-		"hue":         color.Hue,
-		"huep":        color.HueP,
-		"lightness":   color.Lightness,
-		"lightnessp":  color.LightnessP,
-		"saturation":  color.Saturation,
-		"saturationp": color.SaturationP,
-		"alpha":       color.Alpha,
+	builtins = map[string]builtinFunc{
+		"mix":         mixFunc,
+		"-mc-set-hue": setHueFunc,
+		"greyscale":   greyscaleFunc,
+		"greyscalep":  greyscaleFunc,
+		"rgb":         rgbFunc,
+		"rgba":        rgbFunc,
+		"hsl":         hslFunc,
+		"hsla":        hslFunc,
+		"hwb":         hwbFunc,
+		"husl":        huslFunc,
+		"husla":       huslFunc,
+		"lab":         labFunc,
+		"lch":         labFunc,
+		"oklab":       labFunc,
+		"oklch":       labFunc,
+		"stop":        stopFunc,
+		"interpolate": interpolateFunc,
+		"__echo__":    func(name string, args []code) ([]code, error) { return args, nil },
+
+		"lighten":      colorFunc(color.Lighten),
+		"lightenp":     colorFunc(color.LightenP),
+		"darken":       colorFunc(color.Darken),
+		"darkenp":      colorFunc(color.DarkenP),
+		"lightenlab":   colorFunc(color.LightenLab),
+		"darkenlab":    colorFunc(color.DarkenLab),
+		"lightenoklab": colorFunc(color.LightenOklab),
+		"darkenoklab":  colorFunc(color.DarkenOklab),
+		"saturate":     colorFunc(color.Saturate),
+		"saturatep":    colorFunc(color.SaturateP),
+		"desaturate":   colorFunc(color.Desaturate),
+		"desaturatep":  colorFunc(color.DesaturateP),
+		"fadein":       colorFunc(color.FadeIn),
+		"fadeout":      colorFunc(color.FadeOut),
+		"spin":         colorFunc(color.Spin),
+		"spinp":        colorFunc(color.SpinP),
+
+		"hue":         colorParam(color.Hue),
+		"huep":        colorParam(color.HueP),
+		"lightness":   colorParam(color.Lightness),
+		"lightnessp":  colorParam(color.LightnessP),
+		"saturation":  colorParam(color.Saturation),
+		"saturationp": colorParam(color.SaturationP),
+		"alpha":       colorParam(color.Alpha),
+
+		"min":        withoutName(minMaxFunc("min", math.Min)),
+		"max":        withoutName(minMaxFunc("max", math.Max)),
+		"round":      withoutName(roundFunc),
+		"floor":      withoutName(mathFunc("floor", math.Floor)),
+		"ceil":       withoutName(mathFunc("ceil", math.Ceil)),
+		"abs":        withoutName(mathFunc("abs", math.Abs)),
+		"sqrt":       withoutName(sqrtFunc),
+		"pow":        withoutName(powFunc),
+		"mod":        withoutName(modFunc),
+		"percentage": withoutName(percentageFunc),
+		"concat":     withoutName(concatFunc),
+		"upper":      withoutName(stringFunc("upper", strings.ToUpper)),
+		"lower":      withoutName(stringFunc("lower", strings.ToLower)),
+		"replace":    withoutName(replaceFunc),
+		"ramp":       withoutName(rampFunc),
+	}
+}
+
+// colorFunc returns a builtin function that changes a color by a number or
+// percent, eg. lighten(red, 10%).
+func colorFunc(f func(color.Color, float64) color.Color) builtinFunc {
+	return func(name string, v []code) ([]code, error) {
+		if len(v) != 2 {
+			return nil, fmt.Errorf("function %s takes exactly two arguments, got %d", name, len(v))
+		}
+		if v[0].T != typeColor {
+			return nil, fmt.Errorf("function %s requires color as first argument, got %v", name, v[0])
+		}
+		if v[1].T != typeNum && v[1].T != typePercent {
+			return nil, fmt.Errorf("function %s requires number/percent as second argument, got %v", name, v[1])
+		}
+		return []code{{Value: f(v[0].Value.(color.Color), v[1].Value.(float64)/100), T: typeColor}}, nil
+	}
+}
+
+// colorParam returns a builtin function that returns a parameter of a color,
+// eg. hue(red).
+func colorParam(f func(color.Color) float64) builtinFunc {
+	return func(name string, v []code) ([]code, error) {
+		if len(v) != 1 {
+			return nil, fmt.Errorf("function %s takes exactly one argument, got %d", name, len(v))
+		}
+		if v[0].T != typeColor {
+			return nil, fmt.Errorf("function %s requires color as argument, got %v", name, v[0])
+		}
+		return []code{{Value: f(v[0].Value.(color.Color)), T: typeNum}}, nil
+	}
+}
+
+// withoutName returns a builtin function for the math, string and raster
+// functions, they are created with their name for error messages.
+func withoutName(f Functype) builtinFunc {
+	return func(_ string, args []code) ([]code, error) {
+		return f(args)
 	}
 }
 
 type code struct {
 	T     codeType
 	Value interface{}
+	pos   position // only for typeFunction
+}
+
+func (c code) String() string {
+	return fmt.Sprintf("{%v %v}", c.T, c.Value)
 }
@@ -0,0 +1,242 @@
+package cartocss
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FormatOptions configures the output of Format.
+type FormatOptions struct {
+	// Indent is used for each nesting level, four spaces if empty.
+	Indent string
+	// SortProperties sorts consecutive declarations by property name and
+	// instance. Note that this can change the style, as the order of the
+	// properties defines the order of the symbolizers.
+	SortProperties bool
+}
+
+// Format parses the MSS src and returns it in canonical form. Imports are not
+// followed. Returns ParseErrors if src is invalid, as invalid statements would
+// be dropped from the output.
+func Format(src []byte, opts FormatOptions) ([]byte, error) {
+	d := NewDecoder()
+	d.skipImports = true
+	if err := d.ParseString(string(src)); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := d.Stylesheets()[0].Format(&buf, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Format writes the stylesheet in canonical form to w: one statement per
+// line, indented by nesting level, with normalized whitespace, colors and
+// numbers. Comments are kept before the following statement, or at the end
+// of the line for comments that follow a statement on the same line. Single
+// empty lines between statements are kept.
+func (s *Stylesheet) Format(w io.Writer, opts FormatOptions) error {
+	if opts.Indent == "" {
+		opts.Indent = "    "
+	}
+	p := &printer{opts: opts}
+	p.nodes(s.Nodes, s.Comments, 0)
+	_, err := io.WriteString(w, p.buf.String())
+	return err
+}
+
+type printer struct {
+	buf  strings.Builder
+	opts FormatOptions
+}
+
+// formatItem is a node with all attached comments.
+type formatItem struct {
+	node     Node
+	leading  []*Comment // comments before the node
+	inner    []*Comment // comments inside of blocks
+	trailing []*Comment // comments at the end of the last line of the node
+	blank    bool       // empty line before this item
+}
+
+// nodes writes all nodes and comments of a stylesheet or block.
+func (p *printer) nodes(nodes []Node, comments []*Comment, depth int) {
+	items := make([]formatItem, 0, len(nodes))
+	lastLine := 0
+	for _, n := range nodes {
+		item := formatItem{node: n}
+		r := n.Range()
+		for len(comments) > 0 && comments[0].Range().Start.Before(r.Start) {
+			item.leading = append(item.leading, comments[0])
+			comments = comments[1:]
+		}
+		for len(comments) > 0 && comments[0].Range().Start.Before(r.End) {
+			// comments inside of other statements are moved to the end
+			if _, ok := n.(*Block); ok {
+				item.inner = append(item.inner, comments[0])
+			} else {
+				item.trailing = append(item.trailing, comments[0])
+			}
+			comments = comments[1:]
+		}
+		for len(comments) > 0 && comments[0].Range().Start.Line == r.End.Line {
+			item.trailing = append(item.trailing, comments[0])
+			comments = comments[1:]
+		}
+
+		start := r.Start.Line
+		if len(item.leading) > 0 {
+			start = item.leading[0].Range().Start.Line
+		}
+		item.blank = len(items) > 0 && start > lastLine+1
+		lastLine = r.End.Line
+		if len(item.trailing) > 0 {
+			lastLine = item.trailing[len(item.trailing)-1].Range().End.Line
+		}
+		items = append(items, item)
+	}
+	if p.opts.SortProperties {
+		sortDeclarations(items)
+	}
+
+	for _, item := range items {
+		if item.blank {
+			p.buf.WriteString("\n")
+		}
+		for i, c := range item.leading {
+			p.comment(c, depth)
+			next := item.node.Range().Start.Line
+			if i+1 < len(item.leading) {
+				next = item.leading[i+1].Range().Start.Line
+			}
+			if next > c.Range().End.Line+1 {
+				p.buf.WriteString("\n")
+			}
+		}
+		p.indent(depth)
+		p.node(item, depth)
+		for _, c := range item.trailing {
+			p.buf.WriteString(" " + c.Text)
+		}
+		p.buf.WriteString("\n")
+	}
+
+	// comments after the last statement
+	for i, c := range comments {
+		if (i > 0 || len(items) > 0) && c.Range().Start.Line > lastLine+1 {
+			p.buf.WriteString("\n")
+		}
+		p.comment(c, depth)
+		lastLine = c.Range().End.Line
+	}
+}
+
+func (p *printer) comment(c *Comment, depth int) {
+	p.indent(depth)
+	p.buf.WriteString(c.Text)
+	p.buf.WriteString("\n")
+}
+
+func (p *printer) indent(depth int) {
+	for i := 0; i < depth; i++ {
+		p.buf.WriteString(p.opts.Indent)
+	}
+}
+
+func (p *printer) node(item formatItem, depth int) {
+	switch n := item.node.(type) {
+	case *Import:
+		p.buf.WriteString(`@import "` + importEscaper.Replace(n.Path) + `";`)
+	case *VarDecl:
+		p.buf.WriteString("@" + n.Name + ": " + formatTokens(n.Value.toks, normalizeToken) + ";")
+	case *Declaration:
+		if n.Instance != "" {
+			p.buf.WriteString(n.Instance + "/")
+		}
+		p.buf.WriteString(n.Property + ": " + formatTokens(n.Value.toks, normalizeToken) + ";")
+	case *Block:
+		if n.Map {
+			p.buf.WriteString("Map")
+		}
+		for i, s := range n.Selectors {
+			if i > 0 {
+				p.buf.WriteString(",\n")
+				p.indent(depth)
+			}
+			p.buf.WriteString(selectorText(s.toks, normalizeToken))
+		}
+		p.buf.WriteString(" {\n")
+		p.nodes(n.Nodes, item.inner, depth+1)
+		p.indent(depth)
+		p.buf.WriteString("}")
+	}
+}
+
+// sortDeclarations sorts all runs of consecutive declarations by property and
+// instance. Empty lines are only kept before each run.
+func sortDeclarations(items []formatItem) {
+	for start := 0; start < len(items); start++ {
+		if _, ok := items[start].node.(*Declaration); !ok {
+			continue
+		}
+		end := start + 1
+		for end < len(items) {
+			if _, ok := items[end].node.(*Declaration); !ok {
+				break
+			}
+			items[end].blank = false
+			end++
+		}
+		run := items[start:end]
+		sort.SliceStable(run, func(i, j int) bool {
+			a, b := run[i].node.(*Declaration), run[j].node.(*Declaration)
+			if a.Property != b.Property {
+				return a.Property < b.Property
+			}
+			return a.Instance < b.Instance
+		})
+		blank := false
+		for i := range run {
+			blank = blank || run[i].blank
+			run[i].blank = false
+		}
+		run[0].blank = blank
+		start = end
+	}
+}
+
+// importEscaper escapes the unquoted path of an import (see unquote).
+var importEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// normalizeToken returns the canonical text of numbers and colors.
+func normalizeToken(tok *token) string {
+	switch tok.t {
+	case tokenNumber:
+		return normalizeNumber(tok.value)
+	case tokenPercentage:
+		return normalizeNumber(tok.value[:len(tok.value)-1]) + "%"
+	case tokenDimension:
+		n := strings.IndexFunc(tok.value, func(r rune) bool {
+			return r != '-' && r != '+' && r != '.' && (r < '0' || r > '9')
+		})
+		if n > 0 {
+			return normalizeNumber(tok.value[:n]) + tok.value[n:]
+		}
+	case tokenHash:
+		return strings.ToLower(tok.value)
+	}
+	return tok.value
+}
+
+// normalizeNumber removes redundant zeros and signs, eg. `+.50` is `0.5`.
+func normalizeNumber(s string) string {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return s
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,171 @@
+package cartocss
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		opts FormatOptions
+		want string
+	}{
+		{``, FormatOptions{}, ``},
+		{
+			`@import 'colors.mss';@w:.50*2;@c:#ABCDEF;`,
+			FormatOptions{},
+			"@import \"colors.mss\";\n@w: 0.5 * 2;\n@c: #abcdef;\n",
+		},
+		{
+			`#roads::casing.major[zoom>=12][type='motorway'],#bridges{line-width:max(@w,2.0);line-dasharray:2,4;
+			  [zoom>13]{a/line-color:fadeout(#FFF,50.0%);}}`,
+			FormatOptions{Indent: "  "},
+			`#roads::casing.major[zoom >= 12][type = 'motorway'],
+#bridges {
+  line-width: max(@w, 2);
+  line-dasharray: 2, 4;
+  [zoom > 13] {
+    a/line-color: fadeout(#fff, 50%);
+  }
+}
+`,
+		},
+		{
+			`Map { background-color: -@a; }
+#a { raster-colorizer-stops: stop(0, #000) stop(10, #fff); line-width: [width] * -1 - -@a; }`,
+			FormatOptions{},
+			`Map {
+    background-color: -@a;
+}
+#a {
+    raster-colorizer-stops: stop(0, #000) stop(10, #fff);
+    line-width: [width] * -1 - -@a;
+}
+`,
+		},
+		{
+			`#a{line-color:rgb(255 0 0/50%);polygon-fill:hsl(120deg 50% 50%/.5);}`,
+			FormatOptions{},
+			`#a {
+    line-color: rgb(255 0 0 / 50%);
+    polygon-fill: hsl(120deg 50% 50% / 0.5);
+}
+`,
+		},
+		{
+			// comments and empty lines
+			`/* header */
+
+// water
+@water: blue; // trailing
+
+
+#a { // after brace
+	line-width: 1 /* inside */;
+	/* before */
+	line-color: red;
+
+	// end of block
+} /* after block */
+// end`,
+			FormatOptions{},
+			`/* header */
+
+// water
+@water: blue; // trailing
+
+#a {
+    // after brace
+    line-width: 1; /* inside */
+    /* before */
+    line-color: red;
+
+    // end of block
+} /* after block */
+// end
+`,
+		},
+		{
+			`#a {
+				line-width: 1;
+				b/line-color: red;
+				line-color: blue;
+				[zoom > 1] { line-opacity: 1; line-cap: round; }
+				line-join: round;
+			}`,
+			FormatOptions{SortProperties: true},
+			`#a {
+    line-color: blue;
+    b/line-color: red;
+    line-width: 1;
+    [zoom > 1] {
+        line-cap: round;
+        line-opacity: 1;
+    }
+    line-join: round;
+}
+`,
+		},
+	} {
+		got, err := Format([]byte(tt.src), tt.opts)
+		if assert.NoError(t, err, tt.src) {
+			assert.Equal(t, tt.want, string(got), tt.src)
+		}
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	_, err := Format([]byte(`#a { line-width: ; }`), FormatOptions{})
+	assert.Error(t, err)
+
+	// imports are not resolved
+	_, err = Format([]byte(`@import "missing.mss";`), FormatOptions{})
+	assert.NoError(t, err)
+}
+
+func TestFormatImportEscape(t *testing.T) {
+	src := `@import "it\"s.mss"; @import 'a\\b.mss';`
+	formatted, err := Format([]byte(src), FormatOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "@import \"it\\\"s.mss\";\n@import \"a\\\\b.mss\";\n", string(formatted))
+		again, err := Format(formatted, FormatOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, string(formatted), string(again))
+	}
+}
+
+func TestFormatFiles(t *testing.T) {
+	files, err := filepath.Glob("tests/*.mss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := Format(src, FormatOptions{})
+		if !assert.NoError(t, err, f) {
+			continue
+		}
+		again, err := Format(formatted, FormatOptions{})
+		if assert.NoError(t, err, f) {
+			assert.Equal(t, string(formatted), string(again), f)
+		}
+
+		// formatted style decodes to the same rules
+		orig := NewDecoder()
+		assert.NoError(t, orig.ParseString(string(src)))
+		assert.NoError(t, orig.Evaluate())
+		d := NewDecoder()
+		assert.NoError(t, d.ParseString(string(formatted)))
+		assert.NoError(t, d.Evaluate())
+		for _, l := range orig.MSS().Layers() {
+			assertRulesEq(t, d.MSS().LayerRules(l), orig.MSS().LayerRules(l))
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package cartocss
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/flywave/go-cartocss/color"
+)
+
+var ordinals = []string{"first", "second", "third", "fourth"}
+
+func argCountError(name string, n int, args []code) error {
+	switch n {
+	case 1:
+		return fmt.Errorf("function %s takes exactly one argument, got %d", name, len(args))
+	case 2:
+		return fmt.Errorf("function %s takes exactly two arguments, got %d", name, len(args))
+	case 3:
+		return fmt.Errorf("function %s takes exactly three arguments, got %d", name, len(args))
+	default:
+		return fmt.Errorf("function %s takes exactly %d arguments, got %d", name, n, len(args))
+	}
+}
+
+func argTypeError(name string, typ string, i int, args []code) error {
+	if len(args) == 1 {
+		return fmt.Errorf("function %s requires %s as argument, got %v", name, typ, args[i])
+	}
+	if i < len(ordinals) {
+		return fmt.Errorf("function %s requires %s as %s argument, got %v", name, typ, ordinals[i], args[i])
+	}
+	return fmt.Errorf("function %s requires %s as argument %d, got %v", name, typ, i+1, args[i])
+}
+
+// numArgs checks that all args are numbers or percentages of the same type
+// and returns their values and type.
+func numArgs(name string, args []code) ([]float64, codeType, error) {
+	values := make([]float64, len(args))
+	for i, a := range args {
+		if a.T != typeNum && a.T != typePercent {
+			return nil, typeUnknown, argTypeError(name, "number/percent", i, args)
+		}
+		if a.T != args[0].T {
+			return nil, typeUnknown, fmt.Errorf("function %s requires arguments of the same type, got %v and %v", name, args[0], a)
+		}
+		values[i] = a.Value.(float64)
+	}
+	if len(args) == 0 {
+		return values, typeNum, nil
+	}
+	return values, args[0].T, nil
+}
+
+func mathFunc(name string, f func(float64) float64) Functype {
+	return func(args []code) ([]code, error) {
+		if len(args) != 1 {
+			return nil, argCountError(name, 1, args)
+		}
+		v, t, err := numArgs(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return []code{{Value: f(v[0]), T: t}}, nil
+	}
+}
+
+func minMaxFunc(name string, f func(float64, float64) float64) Functype {
+	return func(args []code) ([]code, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("function %s takes at least one argument, got 0", name)
+		}
+		v, t, err := numArgs(name, args)
+		if err != nil {
+			return nil, err
+		}
+		r := v[0]
+		for _, v := range v[1:] {
+			r = f(r, v)
+		}
+		return []code{{Value: r, T: t}}, nil
+	}
+}
+
+// roundFunc rounds to the nearest integer, or to the number of decimal places
+// of the optional second argument, eg. round(3.14159, 2) = 3.14
+func roundFunc(args []code) ([]code, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("function round takes one or two arguments, got %d", len(args))
+	}
+	v, t, err := numArgs("round", args[:1])
+	if err != nil {
+		return nil, err
+	}
+	places := 0.0
+	if len(args) == 2 {
+		if args[1].T != typeNum || args[1].Value.(float64) != math.Trunc(args[1].Value.(float64)) || args[1].Value.(float64) < 0 {
+			return nil, argTypeError("round", "positive integer", 1, args)
+		}
+		places = args[1].Value.(float64)
+	}
+	f := math.Pow(10, places)
+	return []code{{Value: math.Round(v[0]*f) / f, T: t}}, nil
+}
+
+func sqrtFunc(args []code) ([]code, error) {
+	if len(args) != 1 {
+		return nil, argCountError("sqrt", 1, args)
+	}
+	if args[0].T != typeNum {
+		return nil, argTypeError("sqrt", "number", 0, args)
+	}
+	if args[0].Value.(float64) < 0 {
+		return nil, fmt.Errorf("function sqrt requires a non-negative number, got %v", args[0].Value)
+	}
+	return []code{{Value: math.Sqrt(args[0].Value.(float64)), T: typeNum}}, nil
+}
+
+func powFunc(args []code) ([]code, error) {
+	if len(args) != 2 {
+		return nil, argCountError("pow", 2, args)
+	}
+	for i := range args {
+		if args[i].T != typeNum {
+			return nil, argTypeError("pow", "number", i, args)
+		}
+	}
+	return []code{{Value: math.Pow(args[0].Value.(float64), args[1].Value.(float64)), T: typeNum}}, nil
+}
+
+func modFunc(args []code) ([]code, error) {
+	if len(args) != 2 {
+		return nil, argCountError("mod", 2, args)
+	}
+	if args[0].T != typeNum && args[0].T != typePercent {
+		return nil, argTypeError("mod", "number/percent", 0, args)
+	}
+	if args[1].T != typeNum {
+		return nil, argTypeError("mod", "number", 1, args)
+	}
+	if args[1].Value.(float64) == 0 {
+		return nil, fmt.Errorf("function mod requires a non-zero divisor")
+	}
+	return []code{{Value: math.Mod(args[0].Value.(float64), args[1].Value.(float64)), T: args[0].T}}, nil
+}
+
+// percentageFunc converts a fraction to a percentage, eg. percentage(0.5) = 50%
+func percentageFunc(args []code) ([]code, error) {
+	if len(args) != 1 {
+		return nil, argCountError("percentage", 1, args)
+	}
+	if args[0].T != typeNum {
+		return nil, argTypeError("percentage", "number", 0, args)
+	}
+	return []code{{Value: args[0].Value.(float64) * 100, T: typePercent}}, nil
+}
+
+// concatFunc joins all strings and numbers, eg. concat("a", 1, "b") = "a1b"
+func concatFunc(args []code) ([]code, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("function concat takes at least one argument, got 0")
+	}
+	var b strings.Builder
+	for i, a := range args {
+		switch a.T {
+		case typeString:
+			b.WriteString(a.Value.(string))
+		case typeNum:
+			b.WriteString(strconv.FormatFloat(a.Value.(float64), 'f', -1, 64))
+		default:
+			return nil, argTypeError("concat", "string/number", i, args)
+		}
+	}
+	return []code{{Value: b.String(), T: typeString}}, nil
+}
+
+func stringFunc(name string, f func(string) string) Functype {
+	return func(args []code) ([]code, error) {
+		if len(args) != 1 {
+			return nil, argCountError(name, 1, args)
+		}
+		if args[0].T != typeString {
+			return nil, argTypeError(name, "string", 0, args)
+		}
+		return []code{{Value: f(args[0].Value.(string)), T: typeString}}, nil
+	}
+}
+
+// replaceFunc replaces all occurrences of the second with the third argument,
+// eg. replace("a-b-c", "-", " ") = "a b c"
+func replaceFunc(args []code) ([]code, error) {
+	if len(args) != 3 {
+		return nil, argCountError("replace", 3, args)
+	}
+	for i := range args {
+		if args[i].T != typeString {
+			return nil, argTypeError("replace", "string", i, args)
+		}
+	}
+	return []code{{Value: strings.Replace(args[0].Value.(string), args[1].Value.(string), args[2].Value.(string), -1), T: typeString}}, nil
+}
+
+// rampFunc returns count stops evenly spaced from start to end, with colors
+// interpolated between the anchor colors or the colors of a named palette,
+// eg. ramp(0, 255, 6, #47443e, rgb(217,222,170)) or ramp(0, 90, 5, reds).
+// The optional last argument is the color space of the interpolation (rgb,
+// lab, lch, oklab or oklch, see mixSpaces), oklab by default. Stops are
+// ordered by value.
+func rampFunc(args []code) ([]code, error) {
+	if len(args) < 4 {
+		return nil, fmt.Errorf("function ramp takes at least four arguments, got %d", len(args))
+	}
+	for i := range args[:3] {
+		if args[i].T != typeNum {
+			return nil, argTypeError("ramp", "number", i, args)
+		}
+	}
+	start, end := args[0].Value.(float64), args[1].Value.(float64)
+	if start == end {
+		return nil, fmt.Errorf("function ramp requires different start and end values, got %v", start)
+	}
+	n := args[2].Value.(float64)
+	if n != math.Trunc(n) || n < 2 {
+		return nil, fmt.Errorf("function ramp requires an integer of at least 2 as third argument, got %v", args[2])
+	}
+
+	mix := color.MixOklab
+	anchors := args[3:]
+	if last := anchors[len(anchors)-1]; last.T == typeKeyword {
+		if f, ok := mixSpaces[last.Value.(string)]; ok {
+			mix = f
+			anchors = anchors[:len(anchors)-1]
+		}
+	}
+
+	var colors []color.Color
+	if len(anchors) == 1 && anchors[0].T == typeKeyword {
+		p, ok := color.NamedPalette(anchors[0].Value.(string))
+		if !ok {
+			return nil, fmt.Errorf("function ramp requires a known palette, got %v", anchors[0])
+		}
+		colors = p.Colors
+	} else {
+		if len(anchors) < 2 {
+			return nil, fmt.Errorf("function ramp requires a palette or at least two colors")
+		}
+		for i, a := range anchors {
+			if a.T != typeColor {
+				return nil, argTypeError("ramp", "color", i+3, args)
+			}
+			colors = append(colors, a.Value.(color.Color))
+		}
+	}
+
+	colors = color.Ramp(colors, int(n), mix)
+	result := make([]code, len(colors))
+	for i, c := range colors {
+		v := start + (end-start)*float64(i)/(n-1)
+		j := i
+		if start > end {
+			j = len(colors) - 1 - i
+		}
+		result[j] = code{Value: Stop{Value: v, Color: c}, T: typeStop}
+	}
+	return result, nil
+}
+
+// isBuiltinFunction returns whether name is a function of builtins.
+func isBuiltinFunction(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// ArgType is the type of an argument for custom functions.
+type ArgType int
+
+const (
+	AnyArg     ArgType = iota // any value
+	NumberArg                 // float64
+	PercentArg                // float64, eg. 50 for 50%
+	StringArg                 // string
+	ColorArg                  // color.Color
+	BoolArg                   // bool
+	FieldArg                  // Field, eg. "[name]"
+)
+
+func (t ArgType) String() string {
+	switch t {
+	case NumberArg:
+		return "number"
+	case PercentArg:
+		return "percent"
+	case StringArg:
+		return "string"
+	case ColorArg:
+		return "color"
+	case BoolArg:
+		return "bool"
+	case FieldArg:
+		return "field"
+	default:
+		return "value"
+	}
+}
+
+func (t ArgType) accepts(c code) bool {
+	switch t {
+	case AnyArg:
+		return true
+	case NumberArg:
+		return c.T == typeNum
+	case PercentArg:
+		return c.T == typePercent
+	case StringArg:
+		return c.T == typeString
+	case ColorArg:
+		return c.T == typeColor
+	case BoolArg:
+		return c.T == typeBool
+	case FieldArg:
+		return c.T == typeField
+	default:
+		return false
+	}
+}
+
+// Function is a custom function for MSS expressions, see
+// Decoder.RegisterFunction.
+type Function struct {
+	// Args are the types of all arguments. Calls with other types or with a
+	// different number of arguments are reported as errors.
+	Args []ArgType
+	// Variadic allows to repeat the last argument zero or more times.
+	Variadic bool
+	// Fn returns the result of the function with arguments as defined by
+	// Args. The result needs to be a float64, string, color.Color, bool or
+	// []Value. Errors are reported with the position of the function call.
+	Fn func(args []Value) (Value, error)
+}
+
+func (f Function) call(name string, args []code) ([]code, error) {
+	if f.Variadic && len(f.Args) > 0 {
+		if len(args) < len(f.Args)-1 {
+			return nil, fmt.Errorf("function %s takes at least %d arguments, got %d", name, len(f.Args)-1, len(args))
+		}
+	} else if len(args) != len(f.Args) {
+		return nil, argCountError(name, len(f.Args), args)
+	}
+	values := make([]Value, len(args))
+	for i, a := range args {
+		t := f.Args[len(f.Args)-1]
+		if i < len(f.Args) {
+			t = f.Args[i]
+		}
+		if !t.accepts(a) {
+			return nil, argTypeError(name, t.String(), i, args)
+		}
+		values[i] = a.Value
+		if a.T == typeField {
+			values[i] = Field(a.Value.(string))
+		}
+	}
+	v, err := f.Fn(values)
+	if err != nil {
+		return nil, fmt.Errorf("function %s: %v", name, err)
+	}
+	t := valueType(v)
+	if t == typeUnknown {
+		return nil, fmt.Errorf("function %s returned unsupported value %v (%T)", name, v, v)
+	}
+	return []code{{Value: v, T: t}}, nil
+}
+
+// valueType returns the code type for evaluated values.
+func valueType(v interface{}) codeType {
+	switch v.(type) {
+	case string:
+		return typeString
+	case float64:
+		return typeNum
+	case color.Color:
+		return typeColor
+	case bool:
+		return typeBool
+	case []Value:
+		return typeList // TODO convert v to typeList?
+	case *Interpolation:
+		return typeInterpolation
+	default:
+		return typeUnknown
+	}
+}
@@ -0,0 +1,39 @@
+// Package decodetest contains helpers for the tests of packages that check
+// decoded styles.
+package decodetest
+
+import (
+	"testing"
+
+	cartocss "github.com/flywave/go-cartocss"
+)
+
+// Colors is a style with labels, fills and lines of different layers, for
+// tests of checks of the colors. It is relative to the package directories.
+const Colors = "../tests/080-colors.mss"
+
+// Decode parses and evaluates mss and stops the test on errors.
+func Decode(t testing.TB, mss string) *cartocss.Decoder {
+	t.Helper()
+	d := cartocss.NewDecoder()
+	if err := d.ParseString(mss); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Evaluate(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// DecodeFile parses and evaluates the file and stops the test on errors.
+func DecodeFile(t testing.TB, filename string) *cartocss.Decoder {
+	t.Helper()
+	d := cartocss.NewDecoder()
+	if err := d.ParseFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Evaluate(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
@@ -0,0 +1,180 @@
+package cartocss
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/flywave/go-cartocss/color"
+)
+
+// Interpolation is a zoom dependent property value, eg:
+//
+//	line-width: interpolate(linear, [zoom], 10, 1, 15, 4, 18, 12);
+//	line-color: interpolate(exponential, 1.5, [zoom], 5, #fff, 12, #f00);
+//
+// LayerZoomRules replaces all interpolations with the actual value for each
+// zoom level.
+type Interpolation struct {
+	// Base of the exponential interpolation, 1 for linear interpolation.
+	Base  float64
+	Stops []InterpolationStop
+}
+
+// InterpolationStop is a single zoom level with the value at this zoom. Value
+// is either a float64 or a color.Color.
+type InterpolationStop struct {
+	Zoom  float64
+	Value Value
+}
+
+// At returns the interpolated value for the zoom level. Levels before the first
+// or after the last stop use the value of that stop.
+func (i *Interpolation) At(zoom int) Value {
+	z := float64(zoom)
+	first, last := i.Stops[0], i.Stops[len(i.Stops)-1]
+	if z <= first.Zoom {
+		return first.Value
+	}
+	if z >= last.Zoom {
+		return last.Value
+	}
+	n := 1
+	for z > i.Stops[n].Zoom {
+		n++
+	}
+	a, b := i.Stops[n-1], i.Stops[n]
+	t := (z - a.Zoom) / (b.Zoom - a.Zoom)
+	if i.Base != 1 {
+		t = (math.Pow(i.Base, z-a.Zoom) - 1) / (math.Pow(i.Base, b.Zoom-a.Zoom) - 1)
+	}
+	switch av := a.Value.(type) {
+	case float64:
+		return av + t*(b.Value.(float64)-av)
+	case color.Color:
+		return color.Mix(b.Value.(color.Color), av, t)
+	default:
+		panic(fmt.Sprintf("unsupported interpolation value %v", av))
+	}
+}
+
+// newInterpolation creates an interpolation from the arguments of the
+// interpolate function: type, optional exponential base, [zoom] and the stops.
+func newInterpolation(args []code) (*Interpolation, error) {
+	if len(args) == 0 || args[0].T != typeKeyword {
+		return nil, fmt.Errorf("interpolate requires linear or exponential as first argument")
+	}
+	i := &Interpolation{Base: 1}
+	switch args[0].Value {
+	case "linear":
+		args = args[1:]
+	case "exponential":
+		i.Base = 2
+		args = args[1:]
+		if len(args) > 0 && args[0].T == typeNum {
+			i.Base = args[0].Value.(float64)
+			if i.Base <= 0 {
+				return nil, fmt.Errorf("interpolate requires a positive base, got %v", i.Base)
+			}
+			args = args[1:]
+		}
+	default:
+		return nil, fmt.Errorf("interpolate requires linear or exponential as first argument, got %v", args[0].Value)
+	}
+
+	if len(args) == 0 || args[0].T != typeField || args[0].Value != "[zoom]" {
+		return nil, fmt.Errorf("interpolate only supports [zoom] as input")
+	}
+	args = args[1:]
+	if len(args)%2 != 0 {
+		return nil, fmt.Errorf("interpolate requires pairs of zoom level and value")
+	}
+	if len(args) < 4 {
+		return nil, fmt.Errorf("interpolate requires at least two stops")
+	}
+	for n := 0; n < len(args); n += 2 {
+		z, v := args[n], args[n+1]
+		if z.T != typeNum {
+			return nil, fmt.Errorf("interpolate requires number as zoom level, got %v", z)
+		}
+		if v.T != typeNum && v.T != typeColor {
+			return nil, fmt.Errorf("interpolate requires number or color as value, got %v", v)
+		}
+		if n > 0 {
+			prev := i.Stops[len(i.Stops)-1]
+			if z.Value.(float64) <= prev.Zoom {
+				return nil, fmt.Errorf("interpolate requires ascending zoom levels, got %v after %v", z.Value, prev.Zoom)
+			}
+			if v.T != args[1].T {
+				return nil, fmt.Errorf("interpolate requires values of the same type, got %v and %v", args[1], v)
+			}
+		}
+		i.Stops = append(i.Stops, InterpolationStop{Zoom: z.Value.(float64), Value: v.Value})
+	}
+	return i, nil
+}
+
+// expandInterpolations replaces each rule with interpolated properties by
+// rules for each zoom range with the same values.
+func expandInterpolations(rules []Rule) []Rule {
+	result := make([]Rule, 0, len(rules))
+	for _, r := range rules {
+		var interpolated []key
+		if r.Properties != nil {
+			for k, v := range r.Properties.values {
+				if _, ok := v.value.(*Interpolation); ok {
+					interpolated = append(interpolated, k)
+				}
+			}
+		}
+		if len(interpolated) == 0 {
+			result = append(result, r)
+			continue
+		}
+
+		current := -1 // index of the rule for the previous level
+		var values []Value
+		for l := 0; l <= 30; l++ {
+			if !r.Zoom.ValidFor(l) {
+				current = -1
+				continue
+			}
+			levelValues := make([]Value, len(interpolated))
+			for i, k := range interpolated {
+				levelValues[i] = r.Properties.values[k].value.(*Interpolation).At(l)
+			}
+			if current != -1 && equalValues(values, levelValues) {
+				result[current].Zoom |= 1 << uint(l)
+				continue
+			}
+
+			props := &Properties{
+				values:          make(map[key]attr, len(r.Properties.values)),
+				defaultInstance: r.Properties.defaultInstance,
+			}
+			for k, v := range r.Properties.values {
+				props.values[k] = v
+			}
+			for i, k := range interpolated {
+				a := props.values[k]
+				a.value = levelValues[i]
+				props.values[k] = a
+			}
+			level := r
+			level.Zoom = NewZoomRange(EQ, int64(l))
+			level.Properties = props
+			result = append(result, level)
+			current = len(result) - 1
+			values = levelValues
+		}
+	}
+	return result
+}
+
+func equalValues(a, b []Value) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package cartocss
+
+import (
+	"testing"
+
+	"github.com/flywave/go-cartocss/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolationAt(t *testing.T) {
+	linear := &Interpolation{Base: 1, Stops: []InterpolationStop{{10, 1.0}, {15, 6.0}, {18, 12.0}}}
+	for _, tt := range []struct {
+		zoom  int
+		value float64
+	}{
+		{0, 1}, {10, 1}, {11, 2}, {14, 5}, {15, 6}, {16, 8}, {18, 12}, {20, 12},
+	} {
+		assert.InDelta(t, tt.value, linear.At(tt.zoom), 1e-9, "zoom %d", tt.zoom)
+	}
+
+	exp := &Interpolation{Base: 2, Stops: []InterpolationStop{{10, 0.0}, {12, 3.0}}}
+	assert.InDelta(t, 1.0, exp.At(11), 1e-9)
+
+	colors := &Interpolation{Base: 1, Stops: []InterpolationStop{{0, color.MustParse("#000")}, {10, color.MustParse("#fff")}}}
+	assert.Equal(t, color.MustParse("#000"), colors.At(0))
+	assert.Equal(t, "#808080", colors.At(5).(color.Color).String())
+	assert.Equal(t, color.MustParse("#fff"), colors.At(10))
+}
+
+func TestInterpolateRules(t *testing.T) {
+	d, err := decodeString(`
+		@width: interpolate(linear, [zoom], 10, 1, 12, 3);
+		#roads { line-width: @width; }
+		#roads[zoom >= 14][zoom <= 15] {
+			line-color: interpolate(linear, [zoom], 14, #000, 15, #fff);
+		}
+	`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	rules := d.MSS().LayerRules("roads")
+	assertRulesEq(t, rules, []Rule{
+		{Layer: "roads", Zoom: NewZoomRange(EQ, 14), Properties: NewProperties("line-width", 3.0, "line-color", color.MustParse("#000"))},
+		{Layer: "roads", Zoom: NewZoomRange(EQ, 15), Properties: NewProperties("line-width", 3.0, "line-color", color.MustParse("#fff"))},
+		{Layer: "roads", Zoom: NewZoomRange(LTE, 10), Properties: NewProperties("line-width", 1.0)},
+		{Layer: "roads", Zoom: NewZoomRange(EQ, 11), Properties: NewProperties("line-width", 2.0)},
+		{Layer: "roads", Zoom: NewZoomRange(GTE, 12), Properties: NewProperties("line-width", 3.0)},
+	})
+	for _, w := range d.Warnings() {
+		if w.Severity <= SeverityWarning {
+			t.Error("unexpected warning", w)
+		}
+	}
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	for _, tt := range []struct {
+		mss string
+		err string
+	}{
+		{`#a { line-width: interpolate(cubic, [zoom], 1, 1, 2, 2); }`, "requires linear or exponential as first argument, got cubic"},
+		{`#a { line-width: interpolate(linear, [foo], 1, 1, 2, 2); }`, "only supports [zoom] as input"},
+		{`#a { line-width: interpolate(exponential, -1, [zoom], 1, 1, 2, 2); }`, "requires a positive base"},
+		{`#a { line-width: interpolate(linear, [zoom], 1, 1, 2); }`, "requires pairs of zoom level and value"},
+		{`#a { line-width: interpolate(linear, [zoom], 1, 1); }`, "requires at least two stops"},
+		{`#a { line-width: interpolate(linear, [zoom], 2, 1, 1, 2); }`, "requires ascending zoom levels"},
+		{`#a { line-width: interpolate(linear, [zoom], 1, 1, 2, red); }`, "requires values of the same type"},
+		{`#a { line-width: interpolate(linear, [zoom], 1, "a", 2, "b"); }`, "requires number or color as value"},
+	} {
+		_, err := decodeString(tt.mss)
+		if assert.Error(t, err, tt.mss) {
+			assert.Contains(t, err.Error(), tt.err)
+		}
+	}
+
+	d, err := decodeString(`#a { line-width: interpolate(linear, [zoom], 1, red, 2, blue); }`)
+	assert.NoError(t, err)
+	if assert.Len(t, d.Warnings(), 1) {
+		assert.Equal(t, InvalidValue, d.Warnings()[0].Code)
+	}
+}
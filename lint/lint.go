@@ -0,0 +1,288 @@
+// Package lint reports common problems in CartoCSS styles.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	cartocss "github.com/flywave/go-cartocss"
+)
+
+// RuleID identifies the check of a Finding. The values are stable and can be
+// used to filter findings.
+type RuleID string
+
+const (
+	// UnknownProperty is a declaration of a property that is not supported,
+	// eg. a misspelled property name.
+	UnknownProperty RuleID = "unknown-property"
+	// DeprecatedProperty is a declaration of a deprecated property name,
+	// eg. text-upgright instead of text-upright.
+	DeprecatedProperty RuleID = "deprecated-property"
+	// UnusedVariable is a variable that is not referenced by any rule or
+	// other variable.
+	UnusedVariable RuleID = "unused-variable"
+	// NoEffect is a property that is never rendered, as the required
+	// property of the symbolizer is missing, eg. line-color without
+	// line-width.
+	NoEffect RuleID = "no-effect"
+	// DuplicateDeclaration is a property that is declared more than once in
+	// the same block. Only the last declaration is used.
+	DuplicateDeclaration RuleID = "duplicate-declaration"
+	// MissingLayer is a selector for a layer that is not defined in the MML.
+	MissingLayer RuleID = "missing-layer"
+)
+
+// Finding is a single problem in a style.
+type Finding struct {
+	Rule     RuleID
+	Severity cartocss.Severity
+	Message  string
+	Range    cartocss.Range
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s (%s) in %s", f.Severity, f.Message, f.Rule, f.Range.Start)
+}
+
+// symbolizers contains the property that is required for each symbolizer, as
+// serialized by the mapnik package. Longer prefixes come first.
+var symbolizers = []struct {
+	prefix   string
+	required string
+}{
+	{"line-pattern-", "line-pattern-file"},
+	{"line-", "line-width"},
+	{"polygon-pattern-", "polygon-pattern-file"},
+	{"polygon-", "polygon-fill"},
+	{"text-", "text-size"},
+	{"shield-", "shield-file"},
+	{"point-", "point-file"},
+	{"building-", "building-fill"},
+	{"dot-", "dot-fill"},
+}
+
+// Lint checks the style of the decoder and returns all findings, ordered by
+// their position. The rules of all layers are checked (see
+// cartocss.MSS.EachLayer), selectors are checked against the MML, if mml is
+// not nil.
+func Lint(d *cartocss.Decoder, mml *cartocss.MML) []Finding {
+	var findings []Finding
+	for _, s := range d.Stylesheets() {
+		findings = append(findings, lintNodes(s.Nodes, mml)...)
+	}
+	findings = append(findings, unusedVariables(d)...)
+	findings = append(findings, noEffect(d, mml)...)
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Range.Start.Before(findings[j].Range.Start)
+	})
+	return findings
+}
+
+// lintNodes checks all declarations and selectors of the nodes.
+func lintNodes(nodes []cartocss.Node, mml *cartocss.MML) []Finding {
+	var findings []Finding
+	declared := map[string]*cartocss.Declaration{}
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *cartocss.Declaration:
+			name := n.Property
+			if n.Instance != "" {
+				name = n.Instance + "/" + n.Property
+			}
+			if alias, ok := cartocss.PropertyAlias(n.Property); ok {
+				findings = append(findings, Finding{
+					Rule:     DeprecatedProperty,
+					Severity: cartocss.SeverityWarning,
+					Message:  fmt.Sprintf("property %s is deprecated, use %s", n.Property, alias),
+					Range:    n.Range(),
+				})
+			} else if !isProperty(n.Property) {
+				msg := fmt.Sprintf("unknown property %s", n.Property)
+				if s := suggest(n.Property); s != "" {
+					msg += fmt.Sprintf(", did you mean %s?", s)
+				}
+				findings = append(findings, Finding{
+					Rule:     UnknownProperty,
+					Severity: cartocss.SeverityError,
+					Message:  msg,
+					Range:    n.Range(),
+				})
+			}
+			if prev, ok := declared[name]; ok {
+				findings = append(findings, Finding{
+					Rule:     DuplicateDeclaration,
+					Severity: cartocss.SeverityWarning,
+					Message:  fmt.Sprintf("duplicate declaration of %s, already declared in line %d", name, prev.Range().Start.Line),
+					Range:    n.Range(),
+				})
+			}
+			declared[name] = n
+		case *cartocss.Block:
+			if mml != nil {
+				for _, s := range n.Selectors {
+					if s.Layer != "" && !hasLayer(mml, s.Layer) {
+						findings = append(findings, Finding{
+							Rule:     MissingLayer,
+							Severity: cartocss.SeverityWarning,
+							Message:  fmt.Sprintf("layer #%s is not defined in the MML", s.Layer),
+							Range:    s.Range(),
+						})
+					}
+				}
+			}
+			findings = append(findings, lintNodes(n.Nodes, mml)...)
+		}
+	}
+	return findings
+}
+
+func unusedVariables(d *cartocss.Decoder) []Finding {
+	var findings []Finding
+	for _, w := range d.Warnings() {
+		if w.Code == cartocss.UnusedVariable {
+			findings = append(findings, Finding{
+				Rule:     UnusedVariable,
+				Severity: cartocss.SeverityWarning,
+				Message:  w.Message,
+				Range:    w.Range,
+			})
+		}
+	}
+	return findings
+}
+
+// noEffect checks the properties of all rules of all layers. A declaration
+// has no effect if the required property of the symbolizer is missing in all
+// rules that contain the declaration.
+func noEffect(d *cartocss.Decoder, mml *cartocss.MML) []Finding {
+	type declaration struct {
+		name     string
+		required string
+		effect   bool
+	}
+	declarations := map[cartocss.Range]*declaration{}
+	var order []cartocss.Range
+
+	prefixes := make([]string, len(symbolizers))
+	for i, s := range symbolizers {
+		prefixes[i] = s.prefix
+	}
+
+	check := func(_ string, rules []cartocss.Rule) {
+		for _, r := range rules {
+			for _, p := range cartocss.SortedPrefixes(r.Properties, prefixes) {
+				props := r.Properties.WithInstance(p.Instance)
+				for _, name := range props.Names() {
+					prefix, required := symbolizer(name)
+					if prefix != p.Name || !isProperty(name) {
+						continue
+					}
+					pos, _ := props.Range(name)
+					if pos.Start.Line == 0 {
+						continue
+					}
+					decl, ok := declarations[pos]
+					if !ok {
+						decl = &declaration{name: name, required: required}
+						if p.Instance != "" {
+							decl.name = p.Instance + "/" + name
+							decl.required = p.Instance + "/" + required
+						}
+						declarations[pos] = decl
+						order = append(order, pos)
+					}
+					if _, ok := props.Range(required); ok {
+						decl.effect = true
+					}
+				}
+			}
+		}
+	}
+	d.MSS().EachLayer(mml, cartocss.InvalidZoom, check)
+
+	var findings []Finding
+	for _, pos := range order {
+		decl := declarations[pos]
+		if decl.effect {
+			continue
+		}
+		findings = append(findings, Finding{
+			Rule:     NoEffect,
+			Severity: cartocss.SeverityWarning,
+			Message:  fmt.Sprintf("%s has no effect without %s", decl.name, decl.required),
+			Range:    pos,
+		})
+	}
+	return findings
+}
+
+// symbolizer returns the prefix and the required property of the symbolizer
+// for the property. Returns empty strings for properties of symbolizers
+// without required property.
+func symbolizer(property string) (string, string) {
+	for _, s := range symbolizers {
+		if strings.HasPrefix(property, s.prefix) {
+			return s.prefix, s.required
+		}
+	}
+	return "", ""
+}
+
+func hasLayer(mml *cartocss.MML, id string) bool {
+	for _, l := range mml.Layers {
+		if l.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+var properties map[string]struct{}
+
+func init() {
+	properties = map[string]struct{}{}
+	for _, p := range cartocss.PropertyNames() {
+		properties[p] = struct{}{}
+	}
+}
+
+func isProperty(name string) bool {
+	_, ok := properties[name]
+	return ok
+}
+
+// suggest returns the most similar known property, or an empty string if no
+// property is similar enough.
+func suggest(name string) string {
+	best, bestDist := "", 3 // allow up to two edits
+	for _, p := range cartocss.PropertyNames() {
+		if d := distance(name, p); d < bestDist {
+			best, bestDist = p, d
+		}
+	}
+	return best
+}
+
+// distance returns the Levenshtein distance of a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,96 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+
+	cartocss "github.com/flywave/go-cartocss"
+	"github.com/flywave/go-cartocss/internal/decodetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	d := decodetest.Decode(t, `
+@unused: 1;
+@width: 2;
+#roads {
+	text-upgright: auto;
+	text-repeat-wrap-characater: true; text-size: 10;
+	line-width: 3;
+	line-color: red;
+	line-width: @width;
+	a/line-color: blue;
+	polygon-opacity: 0.5; polygon-gama: 1;
+	[type='path'] { polygon-fill: green; }
+}
+#water, #missing {
+	line-color: blue;
+	[zoom>=10] { line-width: 1; }
+}
+`)
+	mml := &cartocss.MML{Layers: []cartocss.Layer{{ID: "roads"}, {ID: "water"}}}
+	findings := Lint(d, mml)
+
+	type result struct {
+		rule RuleID
+		line int
+		msg  string
+	}
+	var got []result
+	for _, f := range findings {
+		got = append(got, result{f.Rule, f.Range.Start.Line, f.Message})
+	}
+	assert.Equal(t, []result{
+		{UnusedVariable, 2, "unused variable @unused"},
+		{DeprecatedProperty, 5, "property text-upgright is deprecated, use text-upright"},
+		{DeprecatedProperty, 6, "property text-repeat-wrap-characater is deprecated, use text-repeat-wrap-character"},
+		{DuplicateDeclaration, 9, "duplicate declaration of line-width, already declared in line 7"},
+		{NoEffect, 10, "a/line-color has no effect without a/line-width"},
+		{UnknownProperty, 11, "unknown property polygon-gama, did you mean polygon-gamma?"},
+		{MissingLayer, 14, "layer #missing is not defined in the MML"},
+	}, got)
+
+	assert.Equal(t, cartocss.SeverityError, findings[5].Severity)
+	assert.True(t, strings.HasPrefix(findings[0].String(), "warning: unused variable @unused (unused-variable) in ? line: 2"), findings[0].String())
+}
+
+func TestLintWithoutMML(t *testing.T) {
+	d := decodetest.Decode(t, `
+#roads { line-color: red; }
+#roads[zoom>=12] { line-width: 1; }
+#water { line-color: blue; }
+`)
+	findings := Lint(d, nil)
+	if assert.Len(t, findings, 1) {
+		assert.Equal(t, NoEffect, findings[0].Rule)
+		assert.Equal(t, 4, findings[0].Range.Start.Line)
+	}
+}
+
+func TestLintUnknownProperty(t *testing.T) {
+	d := decodetest.Decode(t, `#roads { line-widht: 1; line-color: red; }`)
+	findings := Lint(d, nil)
+	if assert.Len(t, findings, 2) {
+		assert.Equal(t, UnknownProperty, findings[0].Rule)
+		assert.Equal(t, "unknown property line-widht, did you mean line-width?", findings[0].Message)
+		assert.Equal(t, NoEffect, findings[1].Rule)
+	}
+}
+
+func TestLintKeepsInstance(t *testing.T) {
+	d := decodetest.Decode(t, `#roads { a/line-width: 1; line-color: red; }`)
+	rules := d.MSS().LayerRules("roads")
+	if assert.Len(t, rules, 1) {
+		rules[0].Properties.SetDefaultInstance("a")
+		Lint(d, nil)
+		_, ok := rules[0].Properties.GetFloat("line-width")
+		assert.True(t, ok)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	assert.Equal(t, 0, distance("line-cap", "line-cap"))
+	assert.Equal(t, 1, distance("text-upgright", "text-upright"))
+	assert.Equal(t, 3, distance("", "abc"))
+	assert.Equal(t, "", suggest("foo"))
+}
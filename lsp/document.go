@@ -0,0 +1,256 @@
+package lsp
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	cartocss "github.com/flywave/go-cartocss"
+)
+
+// document is an open text document of the client.
+type document struct {
+	uri      string
+	filename string // empty for documents that are not files, eg. untitled:
+	version  int
+	text     string
+	lines    []string
+
+	// result of the last analysis
+	decoder *cartocss.Decoder
+	sheet   *cartocss.Stylesheet
+}
+
+func newDocument(uri string, version int, text string) *document {
+	doc := &document{uri: uri, filename: uriFilename(uri), version: version}
+	doc.setText(text)
+	return doc
+}
+
+func (d *document) setText(text string) {
+	d.text = text
+	d.lines = strings.Split(text, "\n")
+}
+
+// applyChange replaces the range of the change, or the whole text if the
+// change has no range.
+func (d *document) applyChange(change TextDocumentContentChangeEvent) {
+	if change.Range == nil {
+		d.setText(change.Text)
+		return
+	}
+	start, end := d.offset(change.Range.Start), d.offset(change.Range.End)
+	if end < start {
+		start, end = end, start
+	}
+	d.setText(d.text[:start] + change.Text + d.text[end:])
+}
+
+// analyze parses and evaluates the document and returns all errors and
+// warnings as diagnostics.
+func (d *document) analyze() []Diagnostic {
+	dec := cartocss.NewDecoder()
+	var err error
+	if d.filename != "" {
+		err = dec.ParseFileContent(d.filename, d.text)
+	} else {
+		err = dec.ParseString(d.text)
+	}
+	diags := d.errorDiagnostics(err)
+	diags = append(diags, d.errorDiagnostics(dec.Evaluate())...)
+
+	for _, w := range dec.Warnings() {
+		if w.Range.Start.Line == 0 || w.Range.Start.Filename != d.filename {
+			continue
+		}
+		diags = append(diags, Diagnostic{
+			Range:    d.lspRange(w.Range),
+			Severity: severity(w.Severity),
+			Code:     string(w.Code),
+			Source:   "cartocss",
+			Message:  w.Message,
+		})
+	}
+
+	d.decoder = dec
+	d.sheet = nil
+	if sheets := dec.Stylesheets(); len(sheets) > 0 {
+		d.sheet = sheets[0]
+	}
+	return diags
+}
+
+// errorDiagnostics converts parse and evaluation errors. Errors in imported
+// files are reported at the start of the document.
+func (d *document) errorDiagnostics(err error) []Diagnostic {
+	if err == nil {
+		return nil
+	}
+	var errs cartocss.ParseErrors
+	if !errors.As(err, &errs) {
+		var perr *cartocss.ParseError
+		if !errors.As(err, &perr) {
+			return []Diagnostic{{Severity: SeverityError, Source: "cartocss", Message: err.Error()}}
+		}
+		errs = cartocss.ParseErrors{perr}
+	}
+
+	var diags []Diagnostic
+	for _, e := range errs {
+		diag := Diagnostic{Severity: SeverityError, Source: "cartocss", Message: e.Err}
+		if e.Filename == d.filename && e.Line > 0 {
+			start := d.position(e.Line, e.Column)
+			end := d.position(e.Line, e.Column+1)
+			diag.Range = Range{Start: start, End: end}
+		} else {
+			diag.Message = e.Error()
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
+
+func severity(s cartocss.Severity) DiagnosticSeverity {
+	switch s {
+	case cartocss.SeverityError:
+		return SeverityError
+	case cartocss.SeverityWarning:
+		return SeverityWarning
+	default:
+		return SeverityInformation
+	}
+}
+
+// position converts a position of the decoder (starting at 1, columns in
+// runes) to an LSP position.
+func (d *document) position(line, column int) Position {
+	return linePosition(d.lines, line, column)
+}
+
+func (d *document) lspRange(r cartocss.Range) Range {
+	return Range{
+		Start: d.position(r.Start.Line, r.Start.Column),
+		End:   d.position(r.End.Line, r.End.Column),
+	}
+}
+
+func linePosition(lines []string, line, column int) Position {
+	if line < 1 {
+		return Position{}
+	}
+	if line > len(lines) {
+		return Position{Line: line - 1}
+	}
+	char := 0
+	col := 1
+	for _, r := range lines[line-1] {
+		if col >= column {
+			break
+		}
+		char += utf16.RuneLen(r)
+		col++
+	}
+	return Position{Line: line - 1, Character: char}
+}
+
+// offset returns the byte offset of the position in the text. Positions
+// after the end of a line or the text are moved to the end.
+func (d *document) offset(p Position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset := 0
+	for _, l := range d.lines[:p.Line] {
+		offset += len(l) + 1
+	}
+	return offset + lineOffset(d.lines[p.Line], p.Character)
+}
+
+// lineOffset returns the byte offset of the UTF-16 character offset in line.
+func lineOffset(line string, char int) int {
+	n := 0
+	for i, r := range line {
+		if n >= char {
+			return i
+		}
+		n += utf16.RuneLen(r)
+	}
+	return len(line)
+}
+
+// linePrefix returns the text of the line before the position.
+func (d *document) linePrefix(p Position) string {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return ""
+	}
+	line := d.lines[p.Line]
+	return line[:lineOffset(line, p.Character)]
+}
+
+// wordAt returns the word at the position, including a leading @ for
+// variables, and the range of the word.
+func (d *document) wordAt(p Position) (string, Range) {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return "", Range{}
+	}
+	line := d.lines[p.Line]
+	offset := lineOffset(line, p.Character)
+	start, end := offset, offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(line[:start])
+		if !isWordRune(r) {
+			break
+		}
+		start -= size
+	}
+	for end < len(line) {
+		r, size := utf8.DecodeRuneInString(line[end:])
+		if !isWordRune(r) {
+			break
+		}
+		end += size
+	}
+	r := Range{
+		Start: Position{Line: p.Line, Character: utf16Len(line[:start])},
+		End:   Position{Line: p.Line, Character: utf16Len(line[:end])},
+	}
+	return line[start:end], r
+}
+
+func isWordRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		r == '-' || r == '_' || r == '@' || r == '/'
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// uriFilename returns the filename of file:// URIs, or an empty string for
+// all other URIs.
+func uriFilename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// fileURI returns the file:// URI of the file.
+func fileURI(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}
+	return u.String()
+}
@@ -0,0 +1,322 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// client is an in-process client that communicates with a Server over pipes.
+type client struct {
+	t        *testing.T
+	w        io.WriteCloser
+	id       int
+	messages chan *message
+	done     chan error
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{
+		t:        t,
+		w:        clientOut,
+		messages: make(chan *message, 100),
+		done:     make(chan error, 1),
+	}
+	go func() {
+		c.done <- NewServer(serverIn, serverOut).Run()
+		serverOut.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			data, err := readMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			var msg message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Error(err)
+			}
+			c.messages <- &msg
+		}
+	}()
+	t.Cleanup(func() { clientOut.Close() })
+
+	var result InitializeResult
+	c.call("initialize", map[string]interface{}{}, &result)
+	assert.True(t, result.Capabilities.HoverProvider)
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+func (c *client) send(msg *message) {
+	if err := writeMessage(c.w, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) next() *message {
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("connection closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timeout")
+	}
+	return nil
+}
+
+// call sends a request and decodes the result into result. It returns the
+// error of the response.
+func (c *client) call(method string, params, result interface{}) *ResponseError {
+	c.id++
+	id, _ := json.Marshal(c.id)
+	data, err := json.Marshal(params)
+	require.NoError(c.t, err)
+	c.send(&message{ID: id, Method: method, Params: data})
+	for {
+		msg := c.next()
+		if msg.Method != "" {
+			continue // skip notifications
+		}
+		require.Equal(c.t, string(id), string(msg.ID))
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			require.NoError(c.t, json.Unmarshal(msg.Result, result))
+		}
+		return nil
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	data, err := json.Marshal(params)
+	require.NoError(c.t, err)
+	c.send(&message{Method: method, Params: data})
+}
+
+// diagnostics returns the next published diagnostics.
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	for {
+		msg := c.next()
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var p PublishDiagnosticsParams
+			require.NoError(c.t, json.Unmarshal(msg.Params, &p))
+			return p
+		}
+	}
+}
+
+func (c *client) open(uri, text string) PublishDiagnosticsParams {
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "carto", Version: 1, Text: text},
+	})
+	return c.diagnostics()
+}
+
+func pos(uri string, line, char int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: char},
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	diags := c.open("untitled:a.mss", `@unused: 1;
+#roads {
+  line-widht: 2;
+  line-color: ;
+}`)
+	assert.Equal(t, "untitled:a.mss", diags.URI)
+	assert.Equal(t, 1, diags.Version)
+	if assert.Len(t, diags.Diagnostics, 3) {
+		assert.Equal(t, SeverityError, diags.Diagnostics[0].Severity)
+		assert.Equal(t, Range{Start: Position{3, 14}, End: Position{3, 15}}, diags.Diagnostics[0].Range)
+		assert.Equal(t, "unused-variable", diags.Diagnostics[1].Code)
+		assert.Equal(t, SeverityInformation, diags.Diagnostics[1].Severity)
+		assert.Equal(t, "invalid-property", diags.Diagnostics[2].Code)
+		assert.Equal(t, Range{Start: Position{2, 2}, End: Position{2, 15}}, diags.Diagnostics[2].Range)
+	}
+
+	// incremental change fixes the error
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{URI: "untitled:a.mss", Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{
+			{Range: &Range{Start: Position{3, 14}, End: Position{3, 14}}, Text: "red"},
+			{Range: &Range{Start: Position{2, 2}, End: Position{2, 12}}, Text: "line-width"},
+		},
+	})
+	diags = c.diagnostics()
+	assert.Equal(t, 2, diags.Version)
+	if assert.Len(t, diags.Diagnostics, 1) {
+		assert.Equal(t, "unused-variable", diags.Diagnostics[0].Code)
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: "untitled:a.mss"}})
+	assert.Empty(t, c.diagnostics().Diagnostics)
+
+	err := c.call("textDocument/hover", pos("untitled:a.mss", 0, 0), nil)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, codeInvalidParams, err.Code)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c := newClient(t)
+	uri := "untitled:a.mss"
+	c.open(uri, `@water: #a0c8f0;
+#roads {
+  line-
+  line-cap: r
+  a/line-join:
+  line-color: @w
+  text-clip: 
+}
+#roads::
+`)
+	labels := func(p TextDocumentPositionParams) []string {
+		var items []CompletionItem
+		assert.Nil(t, c.call("textDocument/completion", p, &items))
+		var labels []string
+		for _, item := range items {
+			labels = append(labels, item.Label)
+		}
+		return labels
+	}
+	props := labels(pos(uri, 2, 7))
+	assert.Contains(t, props, "line-width")
+	assert.Contains(t, props, "text-size")
+	assert.Equal(t, []string{"round", "butt", "square"}, labels(pos(uri, 3, 13)))
+	assert.Equal(t, []string{"miter", "miter-revert", "round", "bevel"}, labels(pos(uri, 4, 14)))
+	assert.Equal(t, []string{"@water"}, labels(pos(uri, 5, 16)))
+	assert.Equal(t, []string{"true", "false"}, labels(pos(uri, 6, 13)))
+	assert.Empty(t, labels(pos(uri, 8, 8)))
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	uri := "untitled:a.mss"
+	c.open(uri, `@water: #a0c8f0;
+#water { polygon-fill: @water; b/line-cap: round; }`)
+
+	var h Hover
+	assert.Nil(t, c.call("textDocument/hover", pos(uri, 1, 12), &h))
+	assert.Equal(t, "markdown", h.Contents.Kind)
+	assert.True(t, strings.HasPrefix(h.Contents.Value, "**polygon-fill** (color)\n\nFill color of the polygon."), h.Contents.Value)
+	assert.Equal(t, &Range{Start: Position{1, 9}, End: Position{1, 21}}, h.Range)
+
+	assert.Nil(t, c.call("textDocument/hover", pos(uri, 1, 40), &h))
+	assert.Contains(t, h.Contents.Value, "**line-cap** (keyword)")
+	assert.Contains(t, h.Contents.Value, "Values: `round`, `butt`, `square`")
+	assert.Contains(t, h.Contents.Value, "Default: `butt`")
+
+	assert.Nil(t, c.call("textDocument/hover", pos(uri, 1, 25), &h))
+	assert.Equal(t, "```\n@water: #a0c8f0;\n```", h.Contents.Value)
+
+	var none *Hover
+	assert.Nil(t, c.call("textDocument/hover", pos(uri, 1, 2), &none))
+	assert.Nil(t, none)
+}
+
+func TestDefinition(t *testing.T) {
+	dir := t.TempDir()
+	colors := filepath.Join(dir, "colors.mss")
+	require.NoError(t, os.WriteFile(colors, []byte("// colors\n@water: #a0c8f0;\n"), 0644))
+	main := filepath.Join(dir, "main.mss")
+	uri := fileURI(main)
+
+	c := newClient(t)
+	diags := c.open(uri, `@import "colors.mss";
+@width: 2;
+#water { polygon-fill: @water; line-width: @width; }`)
+	assert.Empty(t, diags.Diagnostics)
+
+	var locs []Location
+	assert.Nil(t, c.call("textDocument/definition", pos(uri, 2, 25), &locs))
+	assert.Equal(t, []Location{{
+		URI:   fileURI(colors),
+		Range: Range{Start: Position{1, 0}, End: Position{1, 15}},
+	}}, locs)
+
+	assert.Nil(t, c.call("textDocument/definition", pos(uri, 2, 45), &locs))
+	assert.Equal(t, []Location{{
+		URI:   uri,
+		Range: Range{Start: Position{1, 0}, End: Position{1, 9}},
+	}}, locs)
+
+	assert.Nil(t, c.call("textDocument/definition", pos(uri, 2, 2), &locs))
+	assert.Empty(t, locs)
+}
+
+func TestDocumentColor(t *testing.T) {
+	c := newClient(t)
+	uri := "untitled:a.mss"
+	c.open(uri, `@water: #A0C8F0;
+#a { /* ä */ line-color: red; polygon-fill: lighten(@water, 10%); text-name: "red"; }`)
+
+	var colors []ColorInformation
+	assert.Nil(t, c.call("textDocument/documentColor", DocumentColorParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &colors))
+	if assert.Len(t, colors, 2) {
+		assert.Equal(t, Range{Start: Position{0, 8}, End: Position{0, 15}}, colors[0].Range)
+		assert.InDelta(t, 0xa0/255.0, colors[0].Color.Red, 1e-9)
+		assert.InDelta(t, 0xf0/255.0, colors[0].Color.Blue, 1e-9)
+		assert.Equal(t, 1.0, colors[0].Color.Alpha)
+		assert.Equal(t, Range{Start: Position{1, 25}, End: Position{1, 28}}, colors[1].Range)
+	}
+
+	var presentations []ColorPresentation
+	r := Range{Start: Position{1, 25}, End: Position{1, 28}}
+	assert.Nil(t, c.call("textDocument/colorPresentation", ColorPresentationParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Color:        Color{Red: 1, Alpha: 0.5},
+		Range:        r,
+	}, &presentations))
+	assert.Equal(t, []ColorPresentation{{
+		Label:    "rgba(255, 0, 0, 0.50000)",
+		TextEdit: &TextEdit{Range: r, NewText: "rgba(255, 0, 0, 0.50000)"},
+	}}, presentations)
+}
+
+func TestLifecycle(t *testing.T) {
+	c := newClient(t)
+	err := c.call("textDocument/foo", map[string]interface{}{}, nil)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, codeMethodNotFound, err.Code)
+	}
+	assert.Nil(t, c.call("shutdown", nil, nil))
+	c.notify("exit", nil)
+	assert.NoError(t, <-c.done)
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	c := newClient(t)
+	c.notify("exit", nil)
+	assert.Equal(t, ErrExitWithoutShutdown, <-c.done)
+}
+
+func TestReadMessage(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("Content-Type: foo\r\ncontent-length: 2\r\n\r\n{}Content-Length: 5\r\n\r\n{}"))
+	data, err := readMessage(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+	_, err = readMessage(r)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = readMessage(bufio.NewReader(strings.NewReader("\r\n{}")))
+	assert.EqualError(t, err, "missing Content-Length header")
+}
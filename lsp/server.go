@@ -0,0 +1,463 @@
+// Package lsp implements a Language Server Protocol server for CartoCSS
+// (.mss) files.
+//
+// The server provides diagnostics for parse errors and warnings, completion
+// of property names, keywords and variables, hover documentation for
+// properties and variables, go-to-definition for variables and color swatches.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	cartocss "github.com/flywave/go-cartocss"
+	"github.com/flywave/go-cartocss/color"
+)
+
+// ErrExitWithoutShutdown is returned by Server.Run if the client sent the exit
+// notification without a shutdown request.
+var ErrExitWithoutShutdown = errors.New("exit without shutdown request")
+
+// Server is a language server that communicates with a single client. The
+// messages are handled one after another.
+type Server struct {
+	r    *bufio.Reader
+	w    io.Writer
+	wmu  sync.Mutex
+	docs map[string]*document
+
+	initialized bool
+	shutdown    bool
+}
+
+// NewServer returns a server that reads messages from r and writes messages
+// to w, eg. stdin and stdout.
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		r:    bufio.NewReader(r),
+		w:    w,
+		docs: map[string]*document{},
+	}
+}
+
+// Run handles all messages until the client sends the exit notification or
+// closes the connection.
+func (s *Server) Run() error {
+	for {
+		data, err := readMessage(s.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			if err := s.reply(json.RawMessage("null"), nil, &ResponseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrExitWithoutShutdown
+			}
+			return nil
+		}
+		result, err := s.handle(msg.Method, msg.Params)
+		if msg.ID == nil {
+			continue // notification
+		}
+		if err := s.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(id json.RawMessage, result interface{}, err error) error {
+	msg := &message{ID: id}
+	if err != nil {
+		var rerr *ResponseError
+		if !errors.As(err, &rerr) {
+			rerr = &ResponseError{Code: codeInternalError, Message: err.Error()}
+		}
+		msg.Error = rerr
+	} else {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		msg.Result = data
+	}
+	return s.write(msg)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.write(&message{Method: method, Params: data})
+}
+
+func (s *Server) write(msg *message) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return writeMessage(s.w, msg)
+}
+
+// handle calls the handler of the method. Unknown notifications are ignored.
+func (s *Server) handle(method string, params json.RawMessage) (interface{}, error) {
+	if !s.initialized && method != "initialize" {
+		return nil, &ResponseError{Code: codeServerNotInitialized, Message: "server not initialized"}
+	}
+	if s.shutdown {
+		return nil, &ResponseError{Code: codeInvalidRequest, Message: "server is shut down"}
+	}
+
+	switch method {
+	case "initialize":
+		s.initialized = true
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:   textDocumentSyncFull,
+				CompletionProvider: &CompletionOptions{TriggerCharacters: []string{"@", ":"}},
+				HoverProvider:      true,
+				DefinitionProvider: true,
+				ColorProvider:      true,
+			},
+			ServerInfo: &ServerInfo{Name: "cartocss-lsp"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		doc := newDocument(p.TextDocument.URI, p.TextDocument.Version, p.TextDocument.Text)
+		s.docs[doc.uri] = doc
+		return nil, s.publishDiagnostics(doc)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(p.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range p.ContentChanges {
+			doc.applyChange(c)
+		}
+		doc.version = p.TextDocument.Version
+		return nil, s.publishDiagnostics(doc)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return nil, s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	case "textDocument/completion":
+		var p TextDocumentPositionParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(p.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return completion(doc, p.Position), nil
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(p.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return hover(doc, p.Position), nil
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(p.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.definition(doc, p.Position), nil
+	case "textDocument/documentColor":
+		var p DocumentColorParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(p.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return documentColors(doc), nil
+	case "textDocument/colorPresentation":
+		var p ColorPresentationParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return colorPresentations(p.Color, p.Range), nil
+	default:
+		if strings.HasPrefix(method, "$/") {
+			return nil, nil
+		}
+		return nil, &ResponseError{Code: codeMethodNotFound, Message: "method not found: " + method}
+	}
+}
+
+func unmarshal(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &ResponseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &ResponseError{Code: codeInvalidParams, Message: "document not open: " + uri}
+	}
+	return doc, nil
+}
+
+func (s *Server) publishDiagnostics(doc *document) error {
+	diags := doc.analyze()
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         doc.uri,
+		Version:     doc.version,
+		Diagnostics: diags,
+	})
+}
+
+var (
+	// variable before the cursor, eg. `line-width: @wi`
+	variableRe = regexp.MustCompile(`@[\w-]*$`)
+	// value of a declaration before the cursor, eg. `line-cap: ro`
+	valueRe = regexp.MustCompile(`(?:^|[{;\s])(?:[a-z][\w-]*/)?([a-z][a-z-]*)\s*:[^;{}:]*$`)
+	// property name before the cursor, eg. `#a { line-`
+	propertyRe = regexp.MustCompile(`(?:^|[{;])\s*(?:[a-z][\w-]*/)?[a-z-]*$`)
+)
+
+// completion returns the variables, keywords or properties, depending on the
+// text before the position.
+func completion(doc *document, p Position) []CompletionItem {
+	prefix := doc.linePrefix(p)
+	items := []CompletionItem{}
+	switch {
+	case variableRe.MatchString(prefix):
+		seen := map[string]struct{}{}
+		for _, v := range variables(doc) {
+			if _, ok := seen[v.Name]; ok {
+				continue
+			}
+			seen[v.Name] = struct{}{}
+			items = append(items, CompletionItem{
+				Label:  "@" + v.Name,
+				Kind:   CompletionVariable,
+				Detail: v.Value.Text,
+			})
+		}
+	case valueRe.MatchString(prefix):
+		property := valueRe.FindStringSubmatch(prefix)[1]
+		spec, ok := cartocss.PropertySpec(property)
+		if !ok {
+			break
+		}
+		keywords := spec.Keywords
+		if spec.Type == "boolean" {
+			keywords = []string{"true", "false"}
+		}
+		for _, k := range keywords {
+			items = append(items, CompletionItem{Label: k, Kind: CompletionValue, Detail: property})
+		}
+	case propertyRe.MatchString(prefix):
+		for _, name := range cartocss.PropertyNames() {
+			spec, _ := cartocss.PropertySpec(name)
+			items = append(items, CompletionItem{
+				Label:         name,
+				Kind:          CompletionProperty,
+				Detail:        spec.Type,
+				Documentation: &MarkupContent{Kind: "markdown", Value: specDoc(spec)},
+			})
+		}
+	}
+	return items
+}
+
+// hover returns the documentation of the property or the value of the
+// variable at the position.
+func hover(doc *document, p Position) *Hover {
+	word, r := doc.wordAt(p)
+	if strings.HasPrefix(word, "@") {
+		vars := variables(doc)
+		for i := len(vars) - 1; i >= 0; i-- {
+			if "@"+vars[i].Name == word {
+				return &Hover{
+					Contents: MarkupContent{Kind: "markdown", Value: fmt.Sprintf("```\n%s: %s;\n```", word, vars[i].Value.Text)},
+					Range:    &r,
+				}
+			}
+		}
+		return nil
+	}
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		word = word[i+1:]
+	}
+	spec, ok := cartocss.PropertySpec(word)
+	if !ok {
+		return nil
+	}
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: specDoc(spec)},
+		Range:    &r,
+	}
+}
+
+// specDoc returns the documentation of the property as markdown.
+func specDoc(spec cartocss.Spec) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s** (%s", spec.Name, spec.Type)
+	if spec.Unit != "" {
+		fmt.Fprintf(&b, ", %s", spec.Unit)
+	}
+	fmt.Fprintf(&b, ")\n\n%s\n", spec.Doc)
+	if len(spec.Keywords) > 0 {
+		fmt.Fprintf(&b, "\nValues: `%s`\n", strings.Join(spec.Keywords, "`, `"))
+	}
+	if spec.Default != nil {
+		fmt.Fprintf(&b, "\nDefault: `%v`\n", spec.Default)
+	}
+	fmt.Fprintf(&b, "\nSince Mapnik %s\n", spec.Since)
+	return b.String()
+}
+
+// variable is a variable definition in a stylesheet.
+type variable struct {
+	*cartocss.VarDecl
+	filename string
+}
+
+// variables returns all variable definitions of the document and all
+// imported files, in order of parsing.
+func variables(doc *document) []variable {
+	if doc.decoder == nil {
+		return nil
+	}
+	var vars []variable
+	for _, sheet := range doc.decoder.Stylesheets() {
+		for _, n := range sheet.Nodes {
+			if v, ok := n.(*cartocss.VarDecl); ok {
+				vars = append(vars, variable{VarDecl: v, filename: sheet.Filename})
+			}
+		}
+	}
+	return vars
+}
+
+// definition returns the locations of all definitions of the variable at
+// the position.
+func (s *Server) definition(doc *document, p Position) []Location {
+	word, _ := doc.wordAt(p)
+	locations := []Location{}
+	if !strings.HasPrefix(word, "@") {
+		return locations
+	}
+	for _, v := range variables(doc) {
+		if "@"+v.Name != word {
+			continue
+		}
+		r := v.Range()
+		if v.filename == doc.filename {
+			locations = append(locations, Location{URI: doc.uri, Range: doc.lspRange(r)})
+			continue
+		}
+		lines := s.fileLines(v.filename)
+		locations = append(locations, Location{
+			URI: fileURI(v.filename),
+			Range: Range{
+				Start: linePosition(lines, r.Start.Line, r.Start.Column),
+				End:   linePosition(lines, r.End.Line, r.End.Column),
+			},
+		})
+	}
+	return locations
+}
+
+// fileLines returns the lines of the file, from an open document if
+// available.
+func (s *Server) fileLines(filename string) []string {
+	if doc, ok := s.docs[fileURI(filename)]; ok {
+		return doc.lines
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(string(content), "\n")
+}
+
+// documentColors returns all color literals in declarations and variable
+// definitions of the document.
+func documentColors(doc *document) []ColorInformation {
+	infos := []ColorInformation{}
+	if doc.sheet == nil {
+		return infos
+	}
+	var walk func(nodes []cartocss.Node)
+	add := func(e *cartocss.Expr) {
+		for _, c := range e.Colors() {
+			r, g, b := c.Color.ToRgb()
+			infos = append(infos, ColorInformation{
+				Range: doc.lspRange(c.Range),
+				Color: Color{Red: r, Green: g, Blue: b, Alpha: c.Color.A},
+			})
+		}
+	}
+	walk = func(nodes []cartocss.Node) {
+		for _, n := range nodes {
+			switch n := n.(type) {
+			case *cartocss.VarDecl:
+				add(n.Value)
+			case *cartocss.Declaration:
+				add(n.Value)
+			case *cartocss.Block:
+				walk(n.Nodes)
+			}
+		}
+	}
+	walk(doc.sheet.Nodes)
+	sort.SliceStable(infos, func(i, j int) bool {
+		a, b := infos[i].Range.Start, infos[j].Range.Start
+		return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+	})
+	return infos
+}
+
+// colorPresentations returns the MSS notation of the color, eg. #ff0000 or
+// rgba(255, 0, 0, 0.50000).
+func colorPresentations(c Color, r Range) []ColorPresentation {
+	label := color.FromRgba(c.Red, c.Green, c.Blue, c.Alpha, false).String()
+	return []ColorPresentation{{Label: label, TextEdit: &TextEdit{Range: r, NewText: label}}}
+}
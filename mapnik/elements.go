@@ -37,6 +37,7 @@ type Style struct {
 }
 
 type Rule struct {
+	Doc           string `xml:",comment"`
 	Zoom          string `xml:",comment"`
 	MaxScaleDenom int    `xml:"MaxScaleDenominator,omitempty"`
 	MinScaleDenom int    `xml:"MinScaleDenominator,omitempty"`
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -23,8 +24,11 @@ type Map struct {
 	locator        config.Locator
 	scaleFactor    float64
 	autoTypeFilter bool
+	skipDefaults   bool
 	zoomScales     []int
 	proj4          bool
+	// styleNames contains all style names, to make them unique
+	styleNames map[string]struct{}
 }
 
 type maker struct {
@@ -45,6 +49,7 @@ var Maker3Proj4 = maker{proj4: true}
 func New(locator config.Locator) *Map {
 	return &Map{
 		fontSets:    make(map[string]string),
+		styleNames:  make(map[string]struct{}),
 		XML:         &XMLMap{SRS: "epsg:3857"},
 		locator:     locator,
 		scaleFactor: 1.0,
@@ -56,6 +61,12 @@ func (m *Map) SetAutoTypeFilter(enable bool) {
 	m.autoTypeFilter = enable
 }
 
+// SetSkipDefaults enables or disables the output of properties that are set
+// to the default value of Mapnik.
+func (m *Map) SetSkipDefaults(enable bool) {
+	m.skipDefaults = enable
+}
+
 func (m *Map) SetBackgroundColor(c color.Color) {
 	m.XML.BgColor = fmtColor(c, true)
 }
@@ -235,26 +246,21 @@ func (m *Map) newStyles(rules []cartocss.Rule) []Style {
 	styles := []Style{}
 	style := Style{FilterMode: "first"}
 
-	for _, r := range rules {
+	for i, r := range rules {
 		mr := m.newRule(r)
 
-		styleName := r.Layer
-		if r.Attachment != "" {
-			styleName += "-" + r.Attachment
-		}
-
-		if style.Name != styleName {
+		if i == 0 || r.Layer != rules[i-1].Layer || r.Attachment != rules[i-1].Attachment {
 			if len(style.Rules) > 0 {
 				styles = append(styles, style)
 			}
-			style = Style{Name: styleName, FilterMode: "first"}
+			style = Style{Name: m.styleName(r), FilterMode: "first"}
 			// apply style-level properties
 			for _, rr := range rules {
-				if r.Attachment == rr.Attachment {
-					if v, ok := r.Properties.GetString("comp-op"); ok {
+				if r.Layer == rr.Layer && r.Attachment == rr.Attachment {
+					if v, ok := rr.Properties.GetString("comp-op"); ok {
 						style.CompOp = &v
 					}
-					if v, ok := r.Properties.GetFloat("opacity"); ok {
+					if v, ok := rr.Properties.GetFloat("opacity"); ok {
 						style.Opacity = &v
 					}
 				}
@@ -269,8 +275,29 @@ func (m *Map) newStyles(rules []cartocss.Rule) []Style {
 	return styles
 }
 
+// styleName returns a unique name for the style of the layer and attachment
+// of the rule, eg. roads-casing for #roads::casing, or roads-casing-glow for
+// the nested attachments ::casing { ::glow {} }. A number is appended if the
+// name is already used, eg. for a literal ::casing-glow attachment or if a
+// layer is added twice.
+func (m *Map) styleName(r cartocss.Rule) string {
+	name := r.Layer
+	if r.Attachment != "" {
+		name += "-" + strings.ReplaceAll(r.Attachment, "/", "-")
+	}
+	unique := name
+	for i := 2; ; i++ {
+		if _, ok := m.styleNames[unique]; !ok {
+			break
+		}
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	m.styleNames[unique] = struct{}{}
+	return unique
+}
+
 func (m *Map) newRule(r cartocss.Rule) *Rule {
-	result := &Rule{}
+	result := &Rule{Doc: fmtDoc(r.Doc)}
 
 	if r.Zoom != cartocss.AllZoom {
 		result.Zoom = r.Zoom.String()
@@ -287,6 +314,11 @@ func (m *Map) newRule(r cartocss.Rule) *Rule {
 
 	result.Filter = fmtFilters(r.Filters)
 	prefixes := cartocss.SortedPrefixes(r.Properties, []string{"line-", "polygon-", "polygon-pattern-", "text-", "shield-", "marker-", "point-", "building-", "raster-"})
+	// prefixes are collected before defaults are removed, markers and rasters
+	// are created for any property, even if all are set to the default value
+	if m.skipDefaults {
+		r.Properties = r.Properties.WithoutDefaults(m.keepDefault)
+	}
 
 	for _, p := range prefixes {
 		r.Properties.SetDefaultInstance(p.Instance)
@@ -321,6 +353,34 @@ func (m *Map) newRule(r cartocss.Rule) *Rule {
 	return result
 }
 
+// symbolizerProperties decide whether a symbolizer is created, they are kept
+// even if they are set to the default value.
+var symbolizerProperties = map[string]struct{}{
+	"line-width":        {},
+	"polygon-fill":      {},
+	"text-size":         {},
+	"building-fill":     {},
+	"dot-fill":          {},
+	"marker-fill":       {},
+	"marker-line-color": {},
+	"marker-line-width": {},
+	"marker-type":       {},
+	"raster-opacity":    {},
+}
+
+// keepDefault returns whether the property is serialized, even if it is set to
+// the default value. Pixel values differ from the default if they are scaled.
+func (m *Map) keepDefault(property string) bool {
+	if _, ok := symbolizerProperties[property]; ok {
+		return true
+	}
+	if m.scaleFactor != 1.0 {
+		spec, _ := cartocss.PropertySpec(property)
+		return spec.Unit == "pixels"
+	}
+	return false
+}
+
 func (m *Map) addLineSymbolizer(result *Rule, r cartocss.Rule) {
 	if width, ok := r.Properties.GetFloat("line-width"); ok && width != 0.0 {
 		symb := LineSymbolizer{}
@@ -446,14 +506,14 @@ func (m *Map) addTextSymbolizer(result *Rule, r cartocss.Rule) {
 		symb.HaloOpacity = fmtFloat(r.Properties.GetFloat("text-halo-opacity"))
 		symb.HaloTransform = fmtString(r.Properties.GetString("text-halo-transform"))
 		symb.HaloCompOp = fmtString(r.Properties.GetString("text-halo-comp-op"))
-		symb.RepeatWrapCharacter = fmtBool(r.Properties.GetBool("text-repeat-wrap-characater"))
+		symb.RepeatWrapCharacter = fmtBool(r.Properties.GetBool("text-repeat-wrap-character"))
 		symb.Margin = fmtFloatProp(r.Properties, "text-margin", m.scaleFactor)
 		symb.Simplify = fmtFloat(r.Properties.GetFloat("text-simplify"))
 		symb.SimplifyAlgorithm = fmtString(r.Properties.GetString("text-simplify-algorithm"))
 		symb.Smooth = fmtFloat(r.Properties.GetFloat("text-smooth"))
 		symb.RotateDisplacement = fmtBool(r.Properties.GetBool("text-rotate-displacement"))
-		symb.Upright = fmtString(r.Properties.GetString("text-upgright"))
-		symb.FontFeatureSettings = fmtString(r.Properties.GetString("font-feature-settings"))
+		symb.Upright = fmtString(r.Properties.GetString("text-upright"))
+		symb.FontFeatureSettings = fmtString(r.Properties.GetString("text-font-feature-settings"))
 		symb.LargestBboxOnly = fmtBool(r.Properties.GetBool("text-largest-bbox-only"))
 		symb.RepeatDistance = fmtFloatProp(r.Properties, "text-repeat-distance", m.scaleFactor)
 
@@ -640,7 +700,7 @@ func (m *Map) addRasterSymbolizer(result *Rule, r cartocss.Rule) {
 		for _, stop := range stops {
 			symb.Stops = append(symb.Stops,
 				Stop{
-					Value: strconv.FormatInt(int64(stop.Value), 10),
+					Value: strconv.FormatFloat(stop.Value, 'f', -1, 64),
 					Color: *fmtColor(stop.Color, true),
 				},
 			)
@@ -751,30 +811,28 @@ func fmtColor(v color.Color, ok bool) *string {
 func fmtFilters(filters []cartocss.Filter) string {
 	parts := []string{}
 	for _, f := range filters {
-		var value string
-		switch v := f.Value.(type) {
-		case nil:
-			value = "null"
-		case string:
-			// TODO quote " in string?!
-			value = `'` + v + `'`
-		case float64:
-			value = string(*fmtFloat(v, true))
-		case cartocss.ModuloComparsion:
-			value = fmt.Sprintf("%d %s %d", v.Div, v.CompOp, v.Value)
-		default:
-			log.Printf("unknown type of filter value: %s", v)
-			value = ""
-		}
 		field := f.Field
 		if len(field) > 2 && field[0] == '"' && field[len(field)-1] == '"' {
 			// strip quotes from field name
 			field = field[1 : len(field)-1]
 		}
-		if f.CompOp == cartocss.REGEX {
-			parts = append(parts, "(["+field+"].match("+value+"))")
-		} else {
-			parts = append(parts, "(["+field+"] "+f.CompOp.String()+" "+value+")")
+		switch f.CompOp {
+		case cartocss.REGEX:
+			parts = append(parts, "(["+field+"].match("+fmtFilterValue(f.Value)+"))")
+		case cartocss.IN, cartocss.NOTIN:
+			// Mapnik has no IN operator, use OR/AND of single comparisons
+			op, join := " = ", " or "
+			if f.CompOp == cartocss.NOTIN {
+				op, join = " != ", " and "
+			}
+			values, _ := f.Value.([]cartocss.Value)
+			list := make([]string, 0, len(values))
+			for _, v := range values {
+				list = append(list, "["+field+"]"+op+fmtFilterValue(v))
+			}
+			parts = append(parts, "("+strings.Join(list, join)+")")
+		default:
+			parts = append(parts, "(["+field+"] "+f.CompOp.String()+" "+fmtFilterValue(f.Value)+")")
 		}
 	}
 
@@ -785,6 +843,63 @@ func fmtFilters(filters []cartocss.Filter) string {
 	return s
 }
 
+// fmtDoc returns the doc comment annotations for an XML comment, eg.
+// "Motorway casing @casing true". The description comes first, followed by
+// all other annotations in sorted order.
+func fmtDoc(doc map[string]string) string {
+	if len(doc) == 0 {
+		return ""
+	}
+	parts := []string{}
+	if desc := doc["desc"]; desc != "" {
+		parts = append(parts, desc)
+	}
+	tags := make([]string, 0, len(doc))
+	for k := range doc {
+		if k != "desc" {
+			tags = append(tags, k)
+		}
+	}
+	sort.Strings(tags)
+	for _, k := range tags {
+		if doc[k] == "" {
+			parts = append(parts, "@"+k)
+		} else {
+			parts = append(parts, "@"+k+" "+doc[k])
+		}
+	}
+	// XML comments must not contain -- or end with -
+	s := strings.Join(parts, " ")
+	for strings.Contains(s, "--") {
+		s = strings.Replace(s, "--", "- -", -1)
+	}
+	if strings.HasSuffix(s, "-") {
+		s += " "
+	}
+	return s
+}
+
+// filterStringEscaper escapes strings for single quoted Mapnik expression
+// strings. Mapnik resolves \\ and \' escapes, so regular expressions
+// like '\d+' arrive unchanged.
+var filterStringEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+func fmtFilterValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return `'` + filterStringEscaper.Replace(v) + `'`
+	case float64:
+		return string(*fmtFloat(v, true))
+	case cartocss.ModuloComparsion:
+		return fmt.Sprintf("%d %s %d", v.Div, v.CompOp, v.Value)
+	default:
+		log.Printf("unknown type of filter value: %s", v)
+		return ""
+	}
+}
+
 var webmercZoomScales = []int{
 	500000000,
 	200000000,
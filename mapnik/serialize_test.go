@@ -0,0 +1,125 @@
+package mapnik
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	cartocss "github.com/flywave/go-cartocss"
+	"github.com/flywave/go-cartocss/config"
+	"github.com/flywave/go-cartocss/internal/decodetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeXML decodes the style and returns the XML of a map with all layers.
+// setup is called before the layers are added, if not nil.
+func writeXML(t *testing.T, mss string, setup func(m *Map)) string {
+	d := decodetest.Decode(t, mss)
+	m := New(&config.LookupLocator{})
+	if setup != nil {
+		setup(m)
+	}
+	for _, l := range d.MSS().Layers() {
+		m.AddLayer(cartocss.Layer{ID: l, SRS: "epsg:3857", Active: true}, d.MSS().LayerRules(l))
+	}
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestListFilters(t *testing.T) {
+	assert.Equal(t, `([type] = 'primary' or [type] = 'it\'s')`, fmtFilters([]cartocss.Filter{
+		{Field: "type", CompOp: cartocss.IN, Value: []cartocss.Value{"primary", "it's"}},
+	}))
+	assert.Equal(t, `(([type] != 1 and [type] != 2) and ([name] != null))`, fmtFilters([]cartocss.Filter{
+		{Field: "type", CompOp: cartocss.NOTIN, Value: []cartocss.Value{1.0, 2.0}},
+		{Field: "name", CompOp: cartocss.NEQ, Value: nil},
+	}))
+	assert.Equal(t, `([name with space] = 'a')`, fmtFilters([]cartocss.Filter{
+		{Field: `"name with space"`, CompOp: cartocss.IN, Value: []cartocss.Value{"a"}},
+	}))
+
+	xml := writeXML(t, `#roads[type in ('primary', 'secondary')] { line-width: 1; }`, nil)
+	assert.Contains(t, xml, `<Filter>([type] = &#39;primary&#39; or [type] = &#39;secondary&#39;)</Filter>`)
+}
+
+func TestRuleDoc(t *testing.T) {
+	xml := writeXML(t, `
+		/** @desc Roads -- all of them @since 1.0- */
+		#roads { line-width: 1; }
+		#water { polygon-fill: blue; }
+	`, nil)
+	assert.Contains(t, xml, "<!--Roads - - all of them @since 1.0- -->")
+	assert.Equal(t, 1, strings.Count(xml, "<!--"))
+
+	assert.Equal(t, "", fmtDoc(nil))
+	assert.Equal(t, "Roads @casing @source osm", fmtDoc(map[string]string{"desc": "Roads", "source": "osm", "casing": ""}))
+	assert.Equal(t, "a- - - -b", fmtDoc(map[string]string{"desc": "a----b"}))
+	assert.Equal(t, "a- - -b", fmtDoc(map[string]string{"desc": "a---b"}))
+}
+
+func TestStyleCompOpOpacity(t *testing.T) {
+	xml := writeXML(t, `
+		#roads[type='a'] { line-width: 1; opacity: 0.5; }
+		#roads[type='b'] { line-width: 2; comp-op: multiply; }
+		#roads::casing { line-width: 3; }
+	`, nil)
+	assert.Contains(t, xml, `<Style name="roads" filter-mode="first" comp-op="multiply" opacity="0.5">`)
+	assert.Contains(t, xml, `<Style name="roads-casing" filter-mode="first">`)
+}
+
+func TestStyleNames(t *testing.T) {
+	xml := writeXML(t, `
+		#roads::casing { line-width: 1; ::glow { line-width: 2; } }
+		#roads::casing-glow { line-width: 3; }
+		#roads-casing { line-width: 4; }
+	`, nil)
+	for _, name := range []string{"roads-casing", "roads-casing-glow", "roads-casing-glow-2", "roads-casing-2"} {
+		assert.Contains(t, xml, `<Style name="`+name+`" filter-mode="first">`)
+	}
+	assert.Contains(t, xml, "<Layer name=\"roads-casing\" srs=\"epsg:3857\">\n    <StyleName>roads-casing-2</StyleName>")
+	assert.Equal(t, 4, strings.Count(xml, "<Style "))
+	assert.Contains(t, xml, "<Style name=\"roads-casing-glow\" filter-mode=\"first\">\n    <Rule>\n      <LineSymbolizer stroke-width=\"2\">")
+
+	// layers that are added twice have their own styles
+	d := cartocss.NewDecoder()
+	assert.NoError(t, d.ParseString(`#roads { line-width: 1; }`))
+	assert.NoError(t, d.Evaluate())
+	m := New(&config.LookupLocator{})
+	m.AddLayer(cartocss.Layer{ID: "roads"}, d.MSS().LayerRules("roads"))
+	m.AddLayer(cartocss.Layer{ID: "roads"}, d.MSS().LayerRules("roads"))
+	assert.Equal(t, []string{"roads"}, m.XML.Layers[0].StyleNames)
+	assert.Equal(t, []string{"roads-2"}, m.XML.Layers[1].StyleNames)
+}
+
+func TestSkipDefaults(t *testing.T) {
+	mss := `
+		#dem { raster-opacity: 1; }
+		#hillshade { raster-scaling: near; }
+		#roads { line-width: 1; line-color: black; }
+		#water { polygon-fill: #808080; polygon-gamma: 1; }
+		#poi { marker-fill: blue; marker-width: 10; }
+		#labels { text-name: [name]; text-size: 10; }
+	`
+	symbolizers := func(xml string) []string {
+		return regexp.MustCompile(`<\w+Symbolizer`).FindAllString(xml, -1)
+	}
+	all := writeXML(t, mss, nil)
+	skipped := writeXML(t, mss, func(m *Map) { m.SetSkipDefaults(true) })
+
+	assert.Len(t, symbolizers(all), 6)
+	assert.Equal(t, symbolizers(all), symbolizers(skipped))
+	assert.Contains(t, all, `scaling="near"`)
+	assert.NotContains(t, skipped, `scaling="near"`)
+	assert.NotContains(t, skipped, `gamma="1"`)
+}
+
+func TestRasterStops(t *testing.T) {
+	xml := writeXML(t, `#dem { raster-colorizer-stops: stop(0.5, #fff) stop(2, #000) ramp(10, 12.5, 2, #fff, #000); }`, nil)
+	assert.Contains(t, xml, `<stop value="0.5" color="#ffffff"></stop>`)
+	assert.Contains(t, xml, `<stop value="2" color="#000000"></stop>`)
+	assert.Contains(t, xml, `<stop value="12.5" color="#000000"></stop>`)
+}
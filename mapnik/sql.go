@@ -1,6 +1,7 @@
 package mapnik
 
 import (
+	"sort"
 	"strings"
 
 	cartocss "github.com/flywave/go-cartocss"
@@ -15,18 +16,31 @@ func filterItems(rules []cartocss.Rule) map[string]map[string]struct{} {
 
 		found := false
 		for _, f := range r.Filters {
-			if f.CompOp != cartocss.EQ {
+			var values []cartocss.Value
+			switch f.CompOp {
+			case cartocss.EQ:
+				values = []cartocss.Value{f.Value}
+			case cartocss.IN:
+				values, _ = f.Value.([]cartocss.Value)
+			default:
 				continue
 			}
-			v, ok := f.Value.(string)
-			if !ok {
+			strs := make([]string, 0, len(values))
+			for _, v := range values {
+				if s, ok := v.(string); ok {
+					strs = append(strs, s)
+				}
+			}
+			if len(strs) == 0 || len(strs) != len(values) {
 				continue
 			}
 			found = true
 			if result[f.Field] == nil {
 				result[f.Field] = make(map[string]struct{})
 			}
-			result[f.Field][v] = struct{}{}
+			for _, s := range strs {
+				result[f.Field][s] = struct{}{}
+			}
 		}
 		if !found {
 			return nil
@@ -35,16 +49,26 @@ func filterItems(rules []cartocss.Rule) map[string]map[string]struct{} {
 	return result
 }
 
+// FilterString returns an SQL condition that matches all features of the
+// rules, eg. ("type" IN ('primary', 'secondary')). Returns an empty string if
+// any rule matches features that are not limited by EQ or IN filters of
+// strings. Fields and values are sorted.
 func FilterString(rules []cartocss.Rule) string {
-	var vals, parts []string
+	var parts []string
 
 	items := filterItems(rules)
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-	for key, values := range items {
-		vals = vals[:0]
-		for v := range values {
-			vals = append(vals, "'"+v+"'")
+	for _, key := range keys {
+		vals := make([]string, 0, len(items[key]))
+		for v := range items[key] {
+			vals = append(vals, "'"+strings.Replace(v, "'", "''", -1)+"'")
 		}
+		sort.Strings(vals)
 		parts = append(parts, "\""+key+"\" IN ("+strings.Join(vals, ", ")+")")
 	}
 	if parts == nil {
@@ -0,0 +1,63 @@
+package mapnik
+
+import (
+	"testing"
+
+	cartocss "github.com/flywave/go-cartocss"
+	"github.com/stretchr/testify/assert"
+)
+
+func filterRules(filters ...[]cartocss.Filter) []cartocss.Rule {
+	var result []cartocss.Rule
+	for _, f := range filters {
+		result = append(result, cartocss.Rule{Filters: f, Properties: cartocss.NewProperties()})
+	}
+	return result
+}
+
+func TestFilterString(t *testing.T) {
+	assert.Equal(t, `("type" IN ('motorway', 'primary', 'secondary'))`, FilterString(filterRules(
+		[]cartocss.Filter{{Field: "type", CompOp: cartocss.IN, Value: []cartocss.Value{"secondary", "primary"}}},
+		[]cartocss.Filter{{Field: "type", CompOp: cartocss.EQ, Value: "motorway"}},
+	)))
+	assert.Equal(t, `("class" IN ('it''s') OR "type" IN ('a'))`, FilterString(filterRules(
+		[]cartocss.Filter{{Field: "type", CompOp: cartocss.EQ, Value: "a"}, {Field: "class", CompOp: cartocss.EQ, Value: "it's"}},
+	)))
+
+	// NOT IN, numbers and rules without filters match other features
+	assert.Equal(t, "", FilterString(filterRules(
+		[]cartocss.Filter{{Field: "type", CompOp: cartocss.NOTIN, Value: []cartocss.Value{"a"}}},
+	)))
+	assert.Equal(t, "", FilterString(filterRules(
+		[]cartocss.Filter{{Field: "type", CompOp: cartocss.IN, Value: []cartocss.Value{"a", 1.0}}},
+	)))
+	assert.Equal(t, "", FilterString(filterRules(
+		[]cartocss.Filter{{Field: "type", CompOp: cartocss.EQ, Value: "a"}},
+		nil,
+	)))
+}
+
+func TestWrapWhere(t *testing.T) {
+	assert.Equal(t, "roads", WrapWhere("roads", ""))
+	assert.Equal(t, `(SELECT * FROM roads WHERE ("type" IN ('a'))) as filtered`, WrapWhere("roads", `("type" IN ('a'))`))
+}
+
+func TestAutoTypeFilter(t *testing.T) {
+	rules := filterRules([]cartocss.Filter{{Field: "type", CompOp: cartocss.IN, Value: []cartocss.Value{"b", "a"}}})
+	for _, enable := range []bool{true, false} {
+		m := New(nil)
+		m.SetAutoTypeFilter(enable)
+		m.AddLayer(cartocss.Layer{ID: "roads", Datasource: &cartocss.PostGIS{Query: "roads"}}, rules)
+		var table string
+		for _, p := range *m.XML.Layers[0].Datasource {
+			if p.Name == "table" {
+				table = p.Value
+			}
+		}
+		if enable {
+			assert.Equal(t, `(SELECT * FROM roads WHERE ("type" IN ('a', 'b'))) as filtered`, table)
+		} else {
+			assert.Equal(t, "roads", table)
+		}
+	}
+}
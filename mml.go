@@ -44,7 +44,7 @@ func newLayer(l auxLayer) (*Layer, error) {
 		isActive = false
 	}
 
-	classes := strings.Split(l.Class, " ")
+	classes := strings.Fields(l.Class)
 	groupBy, _ := l.Properties["group-by"].(string)
 	clearLabelCache, _ := l.Properties["clear-label-cache"].(string)
 	cacheFeatures, _ := l.Properties["cache-features"].(string)
@@ -1,7 +1,5 @@
 package cartocss
 
-import "math"
-
 type Value interface{}
 
 type MSS struct {
@@ -39,8 +37,7 @@ func (m *MSS) addAttachment(attachment string) {
 }
 
 func (m *MSS) addClass(class string) {
-	s := m.current().currentSelector()
-	s.Class = class
+	m.current().currentSelector().addClass(class)
 }
 
 func (m *MSS) addFilter(field string, compOp CompOp, value interface{}) {
@@ -50,16 +47,12 @@ func (m *MSS) addFilter(field string, compOp CompOp, value interface{}) {
 }
 
 func (m *MSS) addZoom(comp CompOp, level int64) {
-	if level > math.MaxInt8 || level < 0 {
-		// TODO
-		panic("zoom not between 0 and 30")
-	}
+	m.current().currentSelector().addZoom(comp, level)
+}
+
+func (m *MSS) addZoomVar(comp CompOp, ref varRef) {
 	s := m.current().currentSelector()
-	if s.Zoom != InvalidZoom {
-		s.Zoom = s.Zoom.add(comp, int8(level))
-	} else {
-		s.Zoom = NewZoomRange(comp, level)
-	}
+	s.zoomVars = append(s.zoomVars, zoomVar{comp: comp, ref: ref})
 }
 
 func (m *MSS) pushSelector() {
@@ -95,6 +88,7 @@ type block struct {
 	properties *Properties
 	instance   string
 	blocks     []*block
+	doc        map[string]string
 }
 
 func (b *block) addProperty(property string, val Value, pos position) {
@@ -105,6 +99,23 @@ func (b *block) addProperty(property string, val Value, pos position) {
 	b.instance = ""
 }
 
+// varRef is a reference to a variable inside a selector, eg. [zoom>=@min].
+// References are replaced by the actual value in Decoder.Evaluate.
+type varRef struct {
+	name string
+	pos  position
+}
+
+func (r *varRef) String() string {
+	return "@" + r.name
+}
+
+// zoomVar is a zoom condition with a level from a variable.
+type zoomVar struct {
+	comp CompOp
+	ref  varRef
+}
+
 func (b *block) currentSelector() *Selector {
 	return b.selectors[len(b.selectors)-1]
 }
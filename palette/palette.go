@@ -0,0 +1,146 @@
+// Package palette lists the colors of CartoCSS styles and groups similar
+// colors, eg. to consolidate them into variables.
+package palette
+
+import (
+	"sort"
+
+	cartocss "github.com/flywave/go-cartocss"
+	"github.com/flywave/go-cartocss/color"
+)
+
+// Usage is a declaration of a property with a color.
+type Usage struct {
+	// Layer is empty for properties of the Map block.
+	Layer string
+	// Property name, with instance name if defined, eg. casing/line-color.
+	Property string
+	// Zoom contains the zoom levels of all rules with this declaration.
+	Zoom cartocss.ZoomRange
+	// Range of the declaration. The range is empty for properties that
+	// were not decoded from a file or string.
+	Range cartocss.Range
+}
+
+// Entry is a distinct color with all its usages, ordered by position.
+type Entry struct {
+	Color  color.Color
+	Usages []Usage
+}
+
+// Extract returns all distinct colors of the Map block and of the rules of
+// all layers (see cartocss.MSS.EachLayer).
+//
+// Colors are distinct if their hex or rgba notation differs. Entries are
+// ordered by the number of usages, most used first, and then by the position
+// of the first usage.
+func Extract(d *cartocss.Decoder, mml *cartocss.MML) []Entry {
+	e := extractor{entries: map[string]*Entry{}, usages: map[usageKey]int{}}
+	e.properties("", cartocss.AllZoom, d.MSS().Map())
+	d.MSS().EachLayer(mml, cartocss.InvalidZoom, func(layer string, rules []cartocss.Rule) {
+		for _, r := range rules {
+			e.properties(layer, r.Zoom, r.Properties)
+		}
+	})
+
+	entries := make([]Entry, 0, len(e.entries))
+	for _, entry := range e.entries {
+		sort.SliceStable(entry.Usages, func(i, j int) bool {
+			return entry.Usages[i].Range.Start.Before(entry.Usages[j].Range.Start)
+		})
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if len(a.Usages) != len(b.Usages) {
+			return len(a.Usages) > len(b.Usages)
+		}
+		if a.Usages[0].Range.Start != b.Usages[0].Range.Start {
+			return a.Usages[0].Range.Start.Before(b.Usages[0].Range.Start)
+		}
+		return a.Color.String() < b.Color.String()
+	})
+	return entries
+}
+
+type usageKey struct {
+	color    string
+	layer    string
+	property string
+	r        cartocss.Range
+}
+
+type extractor struct {
+	entries map[string]*Entry
+	// usages contains the index of each usage in the entry, the same
+	// declaration is part of multiple rules
+	usages map[usageKey]int
+}
+
+// properties adds the colors of all properties and of all instances.
+func (e *extractor) properties(layer string, zoom cartocss.ZoomRange, props *cartocss.Properties) {
+	for _, p := range cartocss.SortedPrefixes(props, []string{""}) {
+		props := props.WithInstance(p.Instance)
+		for _, name := range props.Names() {
+			r, _ := props.Range(name)
+			property := name
+			if p.Instance != "" {
+				property = p.Instance + "/" + name
+			}
+			if c, ok := props.GetColor(name); ok {
+				e.add(c, Usage{Layer: layer, Property: property, Zoom: zoom, Range: r})
+			} else if stops, ok := props.GetStopList(name); ok {
+				for _, s := range stops {
+					e.add(s.Color, Usage{Layer: layer, Property: property, Zoom: zoom, Range: r})
+				}
+			}
+		}
+	}
+}
+
+func (e *extractor) add(c color.Color, u Usage) {
+	k := usageKey{color: c.String(), layer: u.Layer, property: u.Property, r: u.Range}
+	entry, ok := e.entries[k.color]
+	if !ok {
+		entry = &Entry{Color: c}
+		e.entries[k.color] = entry
+	}
+	if i, ok := e.usages[k]; ok {
+		entry.Usages[i].Zoom |= u.Zoom
+		return
+	}
+	e.usages[k] = len(entry.Usages)
+	entry.Usages = append(entry.Usages, u)
+}
+
+// Group contains similar colors. The first entry is the most used color of
+// the group.
+type Group struct {
+	Entries []Entry
+	// Distances contains the perceptual distance (see color.Distance) of
+	// each entry to the first entry.
+	Distances []float64
+}
+
+// GroupSimilar groups all entries with a perceptual distance (see
+// color.Distance) below maxDistance to the most used color of the group.
+// Entries are added to the group with the closest color. The order of entries
+// is kept, entries are expected to be ordered like the result of Extract.
+func GroupSimilar(entries []Entry, maxDistance float64) []Group {
+	var groups []Group
+	for _, e := range entries {
+		best, bestDist := -1, maxDistance
+		for i := range groups {
+			if d := color.Distance(groups[i].Entries[0].Color, e.Color); d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+		if best == -1 {
+			groups = append(groups, Group{Entries: []Entry{e}, Distances: []float64{0}})
+			continue
+		}
+		groups[best].Entries = append(groups[best].Entries, e)
+		groups[best].Distances = append(groups[best].Distances, bestDist)
+	}
+	return groups
+}
@@ -0,0 +1,83 @@
+package palette
+
+import (
+	"testing"
+
+	cartocss "github.com/flywave/go-cartocss"
+	"github.com/flywave/go-cartocss/internal/decodetest"
+	"github.com/stretchr/testify/assert"
+)
+
+type usage struct {
+	layer    string
+	property string
+	zoom     cartocss.ZoomRange
+	line     int
+}
+
+// layers of the shared style without labels and landuse
+var layers = &cartocss.MML{Layers: []cartocss.Layer{{ID: "roads"}, {ID: "water"}, {ID: "dem"}}}
+
+func usages(e Entry) []usage {
+	var result []usage
+	for _, u := range e.Usages {
+		result = append(result, usage{u.Layer, u.Property, u.Zoom, u.Range.Start.Line})
+	}
+	return result
+}
+
+func TestExtract(t *testing.T) {
+	d := decodetest.DecodeFile(t, decodetest.Colors)
+	entries := Extract(d, layers)
+
+	var colors []string
+	for _, e := range entries {
+		colors = append(colors, e.Color.String())
+	}
+	assert.Equal(t, []string{"#f8f8f8", "#e0e0e0", "#e1e1e1", "#ff0000", "#e2e2e2"}, colors)
+
+	assert.Equal(t, []usage{
+		{"", "background-color", cartocss.AllZoom, 2},
+		{"dem", "raster-colorizer-stops", cartocss.AllZoom, 30},
+	}, usages(entries[0]))
+	assert.Equal(t, []usage{
+		{"roads", "line-color", cartocss.AllZoom, 24},
+		{"water", "polygon-fill", cartocss.NewZoomRange(cartocss.GTE, 5), 29},
+	}, usages(entries[1]))
+	assert.Equal(t, []usage{
+		{"roads", "casing/line-color", cartocss.NewZoomRange(cartocss.GTE, 10), 26},
+	}, usages(entries[2]))
+
+	// all layers without MML
+	assert.Len(t, Extract(d, nil), 10)
+
+	// classes from the MML, line-color of .hidden overrides all other line
+	// colors of the layer
+	mml := &cartocss.MML{Layers: []cartocss.Layer{{ID: "roads", Classes: []string{"hidden"}}}}
+	colors = nil
+	for _, e := range Extract(d, mml) {
+		colors = append(colors, e.Color.String())
+	}
+	assert.Equal(t, []string{"#f8f8f8", "#e1e1e1", "#0000ff"}, colors)
+}
+
+func TestGroupSimilar(t *testing.T) {
+	d := decodetest.DecodeFile(t, decodetest.Colors)
+	groups := GroupSimilar(Extract(d, layers), 0.02)
+
+	var got [][]string
+	for _, g := range groups {
+		var colors []string
+		for _, e := range g.Entries {
+			colors = append(colors, e.Color.String())
+		}
+		got = append(got, colors)
+		assert.Equal(t, 0.0, g.Distances[0])
+		assert.Len(t, g.Distances, len(g.Entries))
+	}
+	assert.Equal(t, [][]string{{"#f8f8f8"}, {"#e0e0e0", "#e1e1e1", "#e2e2e2"}, {"#ff0000"}}, got)
+	assert.InDelta(t, 0.003, groups[1].Distances[1], 0.001)
+
+	assert.Len(t, GroupSimilar(Extract(d, layers), 0), 5)
+	assert.Len(t, GroupSimilar(nil, 0.02), 0)
+}
@@ -116,6 +116,20 @@ func (p *Properties) clone() *Properties {
 	return &result
 }
 
+// WithoutDefaults returns a copy of the properties without the properties
+// that are set to their default value (see Spec.IsDefault). Properties are
+// always kept if keep returns true.
+func (p *Properties) WithoutDefaults(keep func(property string) bool) *Properties {
+	result := &Properties{values: make(map[key]attr, len(p.values)), defaultInstance: p.defaultInstance}
+	for k, v := range p.values {
+		if spec, ok := propertySpecs[k.name]; ok && spec.IsDefault(v.value) && !keep(k.name) {
+			continue
+		}
+		result.values[k] = v
+	}
+	return result
+}
+
 func (p *Properties) MinPos() int {
 	index := math.MaxInt32
 	for _, v := range p.values {
@@ -202,6 +216,39 @@ func (p *Properties) SetDefaultInstance(instance string) {
 	p.defaultInstance = instance
 }
 
+// WithInstance returns a copy of the properties with instance as default
+// instance for all following GetXXX calls (see SetDefaultInstance), without
+// changing p. The values are shared with p.
+func (p *Properties) WithInstance(instance string) *Properties {
+	return &Properties{values: p.values, defaultInstance: instance}
+}
+
+// Names returns the sorted names of all properties of the default instance.
+func (p *Properties) Names() []string {
+	names := []string{}
+	for k := range p.values {
+		if k.instance == p.defaultInstance {
+			names = append(names, k.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Range returns the position of the property of the default instance in the
+// .mss file. The range is empty for properties that were not decoded from a
+// file or string.
+func (p *Properties) Range(property string) (Range, bool) {
+	v, ok := p.values[key{name: property, instance: p.defaultInstance}]
+	if !ok {
+		return Range{}, false
+	}
+	if v.pos.line == 0 {
+		return Range{}, true
+	}
+	return v.pos.Range(), true
+}
+
 func (p *Properties) GetBool(property string) (bool, bool) {
 	v, ok := p.get(property)
 	if !ok {
@@ -2,7 +2,7 @@ package cartocss
 
 import (
 	"reflect"
-
+	"sort"
 	"testing"
 
 	"github.com/flywave/go-cartocss/color"
@@ -132,3 +132,27 @@ func TestPropertiesGet(t *testing.T) {
 	}
 
 }
+
+func TestPropertiesWithoutDefaults(t *testing.T) {
+	p := &Properties{}
+	p.setPos(key{name: "line-width"}, 1.0, position{})
+	p.setPos(key{name: "line-cap"}, "butt", position{})
+	p.setPos(key{name: "line-join"}, "round", position{})
+	p.setPos(key{name: "line-color"}, color.MustParse("black"), position{})
+	p.setPos(key{name: "line-color", instance: "a"}, color.MustParse("red"), position{})
+	p.setPos(key{name: "line-dasharray"}, []Value{1.0, 2.0}, position{})
+	p.setPos(key{name: "foo"}, 1.0, position{})
+
+	r := p.WithoutDefaults(func(property string) bool { return property == "line-width" })
+	var names []string
+	for _, k := range r.keys() {
+		names = append(names, k.instance+"/"+k.name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"/foo", "/line-dasharray", "/line-join", "/line-width", "a/line-color"}) {
+		t.Error(names)
+	}
+	if len(p.values) != 7 {
+		t.Error("original properties modified", p)
+	}
+}
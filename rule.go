@@ -4,25 +4,44 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash/fnv"
-	"math"
 	"os"
+	"regexp"
 	"sort"
+	"sync"
 )
 
 var debugRules = 0
 
 type Selector struct {
-	Layer      string
-	Class      string
+	Layer string
+	// Classes are all classes of the selector (sorted), eg. bridge and major
+	// for #roads.major.bridge. The selector only matches layers with all classes.
+	Classes    []string
 	Attachment string
 	Zoom       ZoomRange
 	Filters    []Filter
+	zoomVars   []zoomVar
+	pos        position
+}
+
+func (s *Selector) addClass(class string) {
+	s.Classes = unionClasses(s.Classes, []string{class})
+}
+
+// addZoom adds a zoom filter. The level is validated by the decoder and
+// needs to be between 0 and 30.
+func (s *Selector) addZoom(comp CompOp, level int64) {
+	// selectors start with AllZoom, InvalidZoom is an empty intersection,
+	// eg. [zoom>10][zoom<5]
+	s.Zoom = s.Zoom.add(comp, int8(level))
 }
 
 // Filter contains a single condition. A style is only applied if the Field
 // compares to the Value. Value can be a number or string for all comparsions
-// with one exception. Filter with modulo comparsion will have ModuloComparsion
+// with two exceptions. Filter with modulo comparsion will have ModuloComparsion
 // as a value, to store the division the comparsion and the value.
+// IN and NOTIN filters have a sorted []Value with all numbers and strings of
+// the list as a value.
 type Filter struct {
 	Field  string
 	CompOp CompOp
@@ -50,6 +69,10 @@ type byField []Filter
 func (f byField) Len() int      { return len(f) }
 func (f byField) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
 func (f byField) Less(i, j int) bool {
+	if f[i].Field == f[j].Field {
+		// multiple filters for the same field, eg. two regular expressions
+		return f[i].String() < f[j].String()
+	}
 	return f[i].Field < f[j].Field
 }
 
@@ -84,9 +107,7 @@ func (r *Rule) specificity() specificity {
 		s.layer += 1
 	}
 	// XXX attachments?
-	if r.Class != "" {
-		s.class += 1
-	}
+	s.class += len(r.Classes)
 	s.filters += len(r.Filters)
 	if r.Zoom != AllZoom {
 		s.filters += 1
@@ -108,6 +129,9 @@ func (s bySpecifity) Less(i, j int) bool {
 	if len(s.rules[i].Filters) != len(s.rules[j].Filters) {
 		return len(s.rules[i].Filters) < len(s.rules[j].Filters)
 	}
+	if c := compareGenerality(s.rules[i].Filters, s.rules[j].Filters); c != 0 {
+		return c > 0
+	}
 	if s.rules[i].Zoom != s.rules[j].Zoom {
 		if s.rules[i].Zoom.Levels() != s.rules[j].Zoom.Levels() {
 			return s.rules[i].Zoom.Levels() > s.rules[j].Zoom.Levels()
@@ -136,7 +160,7 @@ func filterIsSubset(a, b []Filter) bool {
 				return false
 			}
 
-			if a[ia].CompOp == b[ib].CompOp && a[ia].Value == b[ib].Value {
+			if a[ia].CompOp == b[ib].CompOp && valueEqual(a[ia].Value, b[ib].Value) {
 				found = true
 				break
 			}
@@ -144,6 +168,10 @@ func filterIsSubset(a, b []Filter) bool {
 				found = true
 				break
 			}
+			if ib+1 < len(b) && b[ib+1].Field == a[ia].Field {
+				// check next filter for the same field
+				continue
+			}
 			return false
 		}
 		if !found {
@@ -154,6 +182,10 @@ func filterIsSubset(a, b []Filter) bool {
 }
 
 func filterContains(a, b Filter) bool {
+	if isValueSetOp(a.CompOp) || isValueSetOp(b.CompOp) {
+		return setFilterContains(a, b)
+	}
+
 	var av, bv float64
 	var ok bool
 
@@ -230,18 +262,24 @@ func filterContains(a, b Filter) bool {
 	return false
 }
 
-// filterOverlap returns true if a does not contain any filters that conflicts with filters of b
+// filterOverlap returns true if a does not contain any filters that conflicts with filters of b.
+// Filters with IN, NOTIN or REGEX conflict only if they have no value in common,
+// they overlap if this can not be determined.
 func filterOverlap(a, b []Filter) bool {
 	for ia := range a {
 		for ib := range b {
 			if a[ia].Field != b[ib].Field {
 				continue
 			}
-			if a[ia].CompOp != b[ib].CompOp || a[ia].Value != b[ib].Value {
-				return false
-			} else {
-				break
+			if a[ia].CompOp == b[ib].CompOp && valueEqual(a[ia].Value, b[ib].Value) {
+				continue
+			}
+			if isValueSetOp(a[ia].CompOp) || isValueSetOp(b[ib].CompOp) {
+				if !filterDisjoint(a[ia], b[ib]) {
+					continue
+				}
 			}
+			return false
 		}
 	}
 	return true
@@ -260,7 +298,7 @@ func filterEqual(a, b []Filter) bool {
 		if a[i].CompOp != b[i].CompOp {
 			return false
 		}
-		if a[i].Value != b[i].Value {
+		if !valueEqual(a[i].Value, b[i].Value) {
 			return false
 		}
 	}
@@ -268,20 +306,30 @@ func filterEqual(a, b []Filter) bool {
 }
 
 type Rule struct {
-	Layer      string
+	Layer string
+	// Attachment of the rule, with / as separator for nested attachments,
+	// e.g. foo/bar for ::foo { ::bar {} }.
 	Attachment string
-	Class      string
+	Classes    []string
 	Filters    []Filter
 	Zoom       ZoomRange
 	Properties *Properties
-	order      int
+	// Doc contains the annotations from the doc comments of the rule, eg.
+	// desc: Motorway casing for /** @desc Motorway casing */. Nested rules
+	// inherit the annotations of their parents.
+	Doc       map[string]string
+	order     int
+	selectors []*Selector // all selectors from the outermost to the innermost block
 }
 
 func (r *Rule) hash() uint64 {
 	h := fnv.New64()
 	h.Write([]byte(r.Layer))
 	h.Write([]byte(r.Attachment))
-	h.Write([]byte(r.Class))
+	for i := range r.Classes {
+		h.Write([]byte(r.Classes[i]))
+		h.Write([]byte{0})
+	}
 	binary.Write(h, binary.LittleEndian, r.Zoom)
 	for i := range r.Filters {
 		h.Write([]byte(r.Filters[i].String()))
@@ -290,7 +338,7 @@ func (r *Rule) hash() uint64 {
 }
 
 func (r *Rule) String() string {
-	return fmt.Sprintf("Rule{%#v %#v %#v %v %v %s}", r.Layer, r.Attachment, r.Class, r.Filters, r.Zoom, r.Properties.String())
+	return fmt.Sprintf("Rule{%#v %#v %#v %v %v %s}", r.Layer, r.Attachment, r.Classes, r.Filters, r.Zoom, r.Properties.String())
 }
 
 // childOf checks whether it is a more specific rule of o.
@@ -302,7 +350,7 @@ func (r Rule) childOf(o Rule) bool {
 	if !(r.Attachment == o.Attachment || o.Attachment == "") {
 		return false
 	}
-	if !(r.Class == o.Class || o.Class == "") {
+	if !containsClasses(r.Classes, o.Classes) {
 		return false
 	}
 	if !(r.Zoom&o.Zoom == r.Zoom || o.Zoom == AllZoom) {
@@ -322,7 +370,7 @@ func (r Rule) same(o Rule) bool {
 	if r.Attachment != o.Attachment {
 		return false
 	}
-	if r.Class != o.Class {
+	if !equalClasses(r.Classes, o.Classes) {
 		return false
 	}
 	if r.Zoom != o.Zoom {
@@ -334,7 +382,7 @@ func (r Rule) same(o Rule) bool {
 	return true
 }
 
-// sameExceptClass checks whether both rule selectors are the same, ignores different Class value
+// sameExceptClass checks whether both rule selectors are the same, ignores different Classes
 func (r Rule) sameExceptClass(o Rule) bool {
 	if r.Layer != o.Layer {
 		return false
@@ -360,9 +408,6 @@ func (r Rule) overlaps(o Rule) bool {
 	if !(r.Attachment == o.Attachment || o.Attachment == "") {
 		return false
 	}
-	// if !(r.Class == o.Class || o.Class == "") {
-	// 	return false
-	// }
 	if !(r.Zoom.combine(o.Zoom).Levels() > 0 || r.Zoom == o.Zoom) {
 		return false
 	}
@@ -393,7 +438,42 @@ func (m *MSS) LayerRules(layer string, classes ...string) []Rule {
 }
 
 // LayerZoomRules returns all Rules for this layer within the specified ZoomRange.
+//
+// Rules are grouped by attachment. Rules without attachment come first,
+// followed by all attachments in order of their first appearance in the
+// style. Nested attachments are separate attachments with a composed name,
+// e.g. foo/bar for ::foo { ::bar {} }. They appear after their parent, as the
+// parent is always defined first. Styles should be rendered in this order.
 func (m *MSS) LayerZoomRules(layer string, zoom ZoomRange, classes ...string) []Rule {
+	rules, attachments := m.collectRules(layer, zoom, classes, nil)
+	if len(rules) > 0 {
+		rules = sortedRules(rules, attachments)
+		rules = expandInterpolations(rules)
+	}
+
+	return rules
+}
+
+// EachLayer calls fn with the rules of each layer within the ZoomRange (see
+// LayerZoomRules). Layers and classes are taken from the MML, if mml is not
+// nil. Otherwise all layers of the style are used, in order of appearance.
+// The decoder needs to be evaluated (see Decoder.Evaluate).
+func (m *MSS) EachLayer(mml *MML, zoom ZoomRange, fn func(layer string, rules []Rule)) {
+	if mml != nil {
+		for _, l := range mml.Layers {
+			fn(l.ID, m.LayerZoomRules(l.ID, zoom, l.Classes...))
+		}
+		return
+	}
+	for _, l := range m.Layers() {
+		fn(l, m.LayerZoomRules(l, zoom))
+	}
+}
+
+// collectRules returns a rule for each block with properties for this layer,
+// and the order of all attachments. Selectors that can never match are
+// skipped and reported to dead, if dead is not nil.
+func (m *MSS) collectRules(layer string, zoom ZoomRange, classes []string, dead func(DeadRule)) ([]Rule, map[string]int) {
 	attachments := make(map[string]int) // store order of first appearance
 	rules := []Rule{}
 	order := 1
@@ -409,10 +489,12 @@ func (m *MSS) LayerZoomRules(layer string, zoom ZoomRange, classes ...string) []
 		for _, s := range node.selectors {
 			current := Rule{
 				Layer:      parent.Layer,
-				Class:      parent.Class,
+				Classes:    parent.Classes,
 				Attachment: parent.Attachment,
 				Filters:    append([]Filter{}, parent.Filters...),
 				Zoom:       parent.Zoom,
+				Doc:        mergeDoc(node.doc, parent.Doc),
+				selectors:  append(parent.selectors[:len(parent.selectors):len(parent.selectors)], s),
 			}
 			if s.Layer != "" {
 				if s.Layer != layer {
@@ -420,28 +502,32 @@ func (m *MSS) LayerZoomRules(layer string, zoom ZoomRange, classes ...string) []
 				}
 				current.Layer = s.Layer
 			}
-			foundClass := false
-			if s.Class != "" {
-				for _, c := range classes {
-					if c == s.Class {
-						foundClass = true
-						break
-					}
-				}
-				if !foundClass {
+			if len(s.Classes) > 0 {
+				// layer needs all classes of the selector
+				if !containsClasses(classes, s.Classes) {
 					continue
 				}
-				current.Class = s.Class
+				current.Classes = unionClasses(current.Classes, s.Classes)
 			}
 			if s.Attachment != "" {
-				if _, ok := attachments[s.Attachment]; !ok {
-					attachments[s.Attachment] = order
+				attachment := s.Attachment
+				if current.Attachment != "" {
+					// nested attachment, eg. bar in "::foo { ::bar {}}"
+					attachment = current.Attachment + "/" + s.Attachment
+				}
+				if _, ok := attachments[attachment]; !ok {
+					attachments[attachment] = len(attachments) + 1
 				}
-				// TODO handle 'hidden' attachments, eg. bar in "::foo { ::bar {}}"
-				current.Attachment = s.Attachment
+				current.Attachment = attachment
 			}
 			if s.Filters != nil {
 				sort.Sort(byField(s.Filters))
+				if d, ok := contradictingFilters(s, parent.selectors); ok {
+					if dead != nil {
+						dead(d)
+					}
+					continue
+				}
 				f, ok := mergeFilters(current.Filters, s.Filters)
 				if !ok {
 					continue
@@ -449,18 +535,25 @@ func (m *MSS) LayerZoomRules(layer string, zoom ZoomRange, classes ...string) []
 				current.Filters = f
 			}
 
-			if s.Zoom != InvalidZoom {
-				if current.Zoom != 0 {
-					current.Zoom = current.Zoom.combine(s.Zoom)
-					if current.Zoom == InvalidZoom {
-						continue
+			if s.Zoom == InvalidZoom {
+				if dead != nil {
+					dead(DeadRule{Reason: EmptyZoom, Range: s.pos.Range()})
+				}
+				continue
+			}
+			if current.Zoom != 0 {
+				current.Zoom = current.Zoom.combine(s.Zoom)
+				if current.Zoom == InvalidZoom {
+					if dead != nil {
+						dead(emptyZoom(s, parent.selectors))
 					}
-				} else {
-					current.Zoom = s.Zoom
+					continue
 				}
+			} else {
+				current.Zoom = s.Zoom
 			}
 
-			if (s.Layer == layer || s.Layer == "") && (foundClass || s.Class == "") {
+			if s.Layer == layer || s.Layer == "" {
 				// carto adds empty properties, eg.
 				// type=baz gets added to foo even if zoom does not match in nested define
 				// #foo[zoom=18],
@@ -472,12 +565,14 @@ func (m *MSS) LayerZoomRules(layer string, zoom ZoomRange, classes ...string) []
 					order += 1
 					r := Rule{
 						Layer:      current.Layer,
-						Class:      current.Class,
+						Classes:    current.Classes,
 						Attachment: current.Attachment,
 						Filters:    append([]Filter{}, current.Filters...),
 						Zoom:       current.Zoom,
 						Properties: node.properties.clone(),
+						Doc:        current.Doc,
 						order:      order,
+						selectors:  current.selectors,
 					}
 					spec := r.specificity()
 					for _, k := range r.Properties.keys() {
@@ -492,25 +587,24 @@ func (m *MSS) LayerZoomRules(layer string, zoom ZoomRange, classes ...string) []
 		}
 	}
 	collect(&m.root, Rule{Zoom: zoom})
-	if len(rules) > 0 {
-		rules = sortedRules(rules, attachments, classes)
-	}
+	// rules without layer (eg. .class {}) apply to this layer as well,
+	// set layer so that they can be merged with the other rules
 	for i := range rules {
 		if rules[i].Layer == "" {
 			rules[i].Layer = layer
 		}
 	}
-
-	return rules
+	return rules, attachments
 }
 
 // combineRules creates a new rule: based on a, missing properties from b, and combined filters
 func combineRules(a, b Rule) Rule {
 	r := Rule{
 		Layer:      a.Layer,
-		Class:      a.Class,
+		Classes:    unionClasses(a.Classes, b.Classes),
 		Attachment: a.Attachment,
 		Zoom:       a.Zoom.combine(b.Zoom),
+		Doc:        mergeDoc(a.Doc, b.Doc),
 	}
 
 	r.Filters = combineFilters(a.Filters, b.Filters)
@@ -525,16 +619,40 @@ func combineRules(a, b Rule) Rule {
 	return r
 }
 
+// mergeDoc returns the annotations of a and the missing annotations of b.
+func mergeDoc(a, b map[string]string) map[string]string {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	doc := make(map[string]string, len(a)+len(b))
+	for k, v := range b {
+		doc[k] = v
+	}
+	for k, v := range a {
+		doc[k] = v
+	}
+	return doc
+}
+
 func combineFilters(a, b []Filter) []Filter {
 	combined := make([]Filter, len(a))
 	copy(combined, a)
 nextFilter:
 	for _, f := range b {
-		for _, c := range combined {
+		for i, c := range combined {
 			if f.Field == c.Field {
-				continue nextFilter
+				// use intersection of both filters, e.g. a=1 for a in (1, 2) and a=1
+				if merged, ok := mergeFilter(c, f); ok {
+					combined[i] = merged
+					continue nextFilter
+				}
 			}
 		}
+		// keep both filters if the intersection can not be expressed as
+		// a single filter, e.g. for two different regular expressions
 		combined = append(combined, f)
 	}
 	// XXX sort
@@ -608,7 +726,7 @@ func fillProperties(r Rule, subRules []Rule) []Rule {
 	return newRules
 }
 
-func sortedRules(rules []Rule, attachments map[string]int, classes []string) []Rule {
+func sortedRules(rules []Rule, attachments map[string]int) []Rule {
 	if len(rules) == 0 {
 		return nil
 	}
@@ -653,8 +771,10 @@ func sortedRules(rules []Rule, attachments map[string]int, classes []string) []R
 		pos++
 	}
 
-	if len(classes) > 0 {
-		return dedupMergeClasses(rules, classes)
+	for i := range rules {
+		if len(rules[i].Classes) > 0 {
+			return dedupMergeClasses(rules)
+		}
 	}
 	return dedup(rules)
 }
@@ -681,34 +801,17 @@ func dedup(rules []Rule) []Rule {
 	return result
 }
 
-// dedup removes all duplicates, merges rules with different classes
-func dedupMergeClasses(rules []Rule, classes []string) []Rule {
-	classIdx := func(class string) int {
-		if class == "" {
-			return math.MaxInt32
-		}
-		for i := range classes {
-			if classes[i] == class {
-				return i
-			}
-		}
-		return math.MaxInt32
-	}
-
+// dedupMergeClasses removes all duplicates, merges rules with different
+// classes. Properties of the rule with the higher specificity win, the merged
+// rule contains all classes of both rules.
+func dedupMergeClasses(rules []Rule) []Rule {
 	result := []Rule{}
 	for i := range rules {
 		found := false
 		for j := range result {
 			if rules[i].sameExceptClass(result[j]) {
-				classIdxI := classIdx(rules[i].Class)
-				classIdxJ := classIdx(result[j].Class)
-
-				if classIdxI < classIdxJ {
-					rules[i].Properties.updateMissing(result[j].Properties)
-					result[j] = rules[i]
-				} else if classIdxJ < classIdxI {
-					result[j].Properties.updateMissing(rules[i].Properties)
-				}
+				result[j].Properties.updateMissing(rules[i].Properties)
+				result[j].Classes = unionClasses(result[j].Classes, rules[i].Classes)
 				found = true
 				break
 			}
@@ -720,6 +823,48 @@ func dedupMergeClasses(rules []Rule, classes []string) []Rule {
 	return result
 }
 
+// unionClasses returns the sorted classes of a and b, without duplicates.
+func unionClasses(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+	result := make([]string, 0, len(a)+len(b))
+	result = append(result, a...)
+	for _, c := range b {
+		if !containsClasses(result, []string{c}) {
+			result = append(result, c)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// containsClasses checks whether all classes of sub are in classes.
+func containsClasses(classes, sub []string) bool {
+nextClass:
+	for _, s := range sub {
+		for _, c := range classes {
+			if c == s {
+				continue nextClass
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func equalClasses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func mergeFilters(a, b []Filter) ([]Filter, bool) {
 	result := make([]Filter, 0, len(a)+len(b))
 
@@ -736,10 +881,19 @@ func mergeFilters(a, b []Filter) ([]Filter, bool) {
 			continue
 		} else {
 			f, ok := mergeFilter(a[ai], b[bi])
-			if !ok {
+			if ok {
+				result = append(result, f)
+			} else if (isValueSetOp(a[ai].CompOp) || isValueSetOp(b[bi].CompOp)) && !filterDisjoint(a[ai], b[bi]) {
+				// intersection is unknown, e.g. for two different regular
+				// expressions
+				if byField([]Filter{a[ai], b[bi]}).Less(1, 0) {
+					result = append(result, b[bi], a[ai])
+				} else {
+					result = append(result, a[ai], b[bi])
+				}
+			} else {
 				return nil, false
 			}
-			result = append(result, f)
 			ai++
 			bi++
 		}
@@ -755,9 +909,12 @@ func mergeFilter(a, b Filter) (Filter, bool) {
 	if a.Field != b.Field {
 		return Filter{}, false
 	}
-	if a.CompOp == b.CompOp && a.Value == b.Value {
+	if a.CompOp == b.CompOp && valueEqual(a.Value, b.Value) {
 		return a, true
 	}
+	if isValueSetOp(a.CompOp) || isValueSetOp(b.CompOp) {
+		return mergeSetFilter(a, b)
+	}
 	if a.CompOp == LT {
 		a.CompOp = LTE
 		a.Value = a.Value.(float64) - 1
@@ -791,6 +948,308 @@ func mergeFilter(a, b Filter) (Filter, bool) {
 	return Filter{}, false
 }
 
+// generality counts the list and regular expression filters of a rule.
+type generality struct {
+	notIn       int // number of not in filters
+	notInValues int // number of values excluded by not in filters
+	regex       int // number of regular expression filters
+	inValues    int // number of additional values matched by in filters
+}
+
+// filterGenerality returns the generality of the filters.
+func filterGenerality(filters []Filter) generality {
+	var g generality
+	for _, f := range filters {
+		switch f.CompOp {
+		case IN:
+			g.inValues += len(f.Value.([]Value)) - 1
+		case REGEX:
+			g.regex++
+		case NOTIN:
+			g.notIn++
+			g.notInValues += len(f.Value.([]Value))
+		}
+	}
+	return g
+}
+
+// compareGenerality returns a positive value if filters a match more values
+// than filters b, a negative value if they match less and 0 if they are
+// equally general. Filters are compared in tiers, so that a long list can not
+// outweigh a filter of a higher tier: [a=1] is more specific than
+// [a in (1, 2)], then [a in (1, 2, 3)], then [a=~'.*'], then
+// [a not in (3, 4, 5)] and then [a not in (3, 4)].
+func compareGenerality(a, b []Filter) int {
+	ga, gb := filterGenerality(a), filterGenerality(b)
+	switch {
+	case ga.notIn != gb.notIn:
+		return ga.notIn - gb.notIn
+	case ga.notInValues != gb.notInValues:
+		return gb.notInValues - ga.notInValues
+	case ga.regex != gb.regex:
+		return ga.regex - gb.regex
+	default:
+		return ga.inValues - gb.inValues
+	}
+}
+
+func isListOp(op CompOp) bool {
+	return op == IN || op == NOTIN
+}
+
+// isValueSetOp returns true for comparsions that are evaluated by checking
+// the actual values that match the filter (see filterMatch).
+func isValueSetOp(op CompOp) bool {
+	return op == IN || op == NOTIN || op == REGEX
+}
+
+var regexpCache sync.Map
+
+// matchRegexp returns whether the filter regexp matches the complete value, like
+// Mapnik's match() does.
+//
+// Mapnik uses ICU regular expressions, Go uses RE2. ok is false if the result
+// can differ: for expressions that RE2 does not support (lookarounds,
+// backreferences, possessive quantifiers, etc.) and for \w, \d, \s and \b,
+// which match only ASCII characters with RE2 but all Unicode characters
+// with ICU.
+func matchRegexp(expr string, v string) (match bool, ok bool) {
+	var re *regexp.Regexp
+	if cached, found := regexpCache.Load(expr); found {
+		re, _ = cached.(*regexp.Regexp)
+	} else {
+		if !asciiClasses(expr) {
+			re, _ = regexp.Compile(`^(?:` + expr + `)$`)
+		}
+		regexpCache.Store(expr, re)
+	}
+	if re == nil {
+		return false, false
+	}
+	return re.MatchString(v), true
+}
+
+// asciiClasses returns whether expr contains \w, \d, \s or \b, or their
+// negations.
+func asciiClasses(expr string) bool {
+	for i := 0; i < len(expr)-1; i++ {
+		if expr[i] != '\\' {
+			continue
+		}
+		i++
+		switch expr[i] {
+		case 'w', 'W', 'd', 'D', 's', 'S', 'b', 'B':
+			return true
+		}
+	}
+	return false
+}
+
+// valueEqual compares two filter values, including lists of IN/NOTIN filters.
+func valueEqual(a, b Value) bool {
+	al, aok := a.([]Value)
+	bl, bok := b.([]Value)
+	if aok != bok {
+		return false
+	}
+	if !aok {
+		return a == b
+	}
+	if len(al) != len(bl) {
+		return false
+	}
+	for i := range al {
+		if !valueEqual(al[i], bl[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// newListFilter returns an IN or NOTIN filter for values. Duplicate values
+// are removed and the values are sorted. Lists with a single value are
+// returned as EQ or NEQ filters.
+func newListFilter(field string, op CompOp, values []Value) Filter {
+	sorted := make([]Value, 0, len(values))
+	for _, v := range values {
+		if !listContains(sorted, v) {
+			sorted = append(sorted, v)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return listValueLess(sorted[i], sorted[j])
+	})
+	if len(sorted) == 1 {
+		if op == IN {
+			return Filter{Field: field, CompOp: EQ, Value: sorted[0]}
+		}
+		return Filter{Field: field, CompOp: NEQ, Value: sorted[0]}
+	}
+	return Filter{Field: field, CompOp: op, Value: sorted}
+}
+
+// listValueLess orders null before numbers before strings.
+func listValueLess(a, b Value) bool {
+	order := func(v Value) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case float64:
+			return 1
+		default:
+			return 2
+		}
+	}
+	if order(a) != order(b) {
+		return order(a) < order(b)
+	}
+	switch a := a.(type) {
+	case float64:
+		return a < b.(float64)
+	case string:
+		return a < b.(string)
+	}
+	return false
+}
+
+func listContains(values []Value, v Value) bool {
+	for i := range values {
+		if valueEqual(values[i], v) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterMatch returns whether the value v matches the filter f. ok is false
+// if the result can not be determined (e.g. for regular expressions).
+func filterMatch(f Filter, v Value) (match bool, ok bool) {
+	switch f.CompOp {
+	case EQ:
+		return valueEqual(f.Value, v), true
+	case NEQ:
+		return !valueEqual(f.Value, v), true
+	case IN:
+		return listContains(f.Value.([]Value), v), true
+	case NOTIN:
+		return !listContains(f.Value.([]Value), v), true
+	case REGEX:
+		expr, eok := f.Value.(string)
+		vs, vok := v.(string)
+		if !eok || !vok {
+			return false, false
+		}
+		return matchRegexp(expr, vs)
+	case GT, GTE, LT, LTE:
+		fv, fok := f.Value.(float64)
+		vv, vok := v.(float64)
+		if !fok || !vok {
+			return false, false
+		}
+		switch f.CompOp {
+		case GT:
+			return vv > fv, true
+		case GTE:
+			return vv >= fv, true
+		case LT:
+			return vv < fv, true
+		default:
+			return vv <= fv, true
+		}
+	}
+	return false, false
+}
+
+// finiteValues returns all values that match f, if f only matches a
+// limited number of values (EQ and IN filters).
+func finiteValues(f Filter) ([]Value, bool) {
+	switch f.CompOp {
+	case EQ:
+		return []Value{f.Value}, true
+	case IN:
+		return f.Value.([]Value), true
+	}
+	return nil, false
+}
+
+// excludedValues returns all values that do not match f, if f matches all
+// except a limited number of values (NEQ and NOTIN filters).
+func excludedValues(f Filter) ([]Value, bool) {
+	switch f.CompOp {
+	case NEQ:
+		return []Value{f.Value}, true
+	case NOTIN:
+		return f.Value.([]Value), true
+	}
+	return nil, false
+}
+
+// setFilterContains checks whether all values matched by b are also matched by a,
+// with a or b being an IN, NOTIN or REGEX filter. Returns false if this can not
+// be determined, e.g. for two different regular expressions.
+func setFilterContains(a, b Filter) bool {
+	if a.CompOp == b.CompOp && valueEqual(a.Value, b.Value) {
+		return true
+	}
+	if values, ok := finiteValues(b); ok {
+		for _, v := range values {
+			if match, ok := filterMatch(a, v); !match || !ok {
+				return false
+			}
+		}
+		return true
+	}
+	bExcluded, bok := excludedValues(b)
+	aExcluded, aok := excludedValues(a)
+	if !aok || !bok {
+		return false
+	}
+	for _, v := range aExcluded {
+		if !listContains(bExcluded, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeSetFilter returns the intersection of a and b, with a or b being an IN,
+// NOTIN or REGEX filter. Returns false if the intersection is empty or can not
+// be expressed as a single filter, e.g. for two different regular expressions.
+func mergeSetFilter(a, b Filter) (Filter, bool) {
+	if a.CompOp == b.CompOp && valueEqual(a.Value, b.Value) {
+		return a, true
+	}
+	values, ok := finiteValues(a)
+	other := b
+	if !ok {
+		values, ok = finiteValues(b)
+		other = a
+	}
+	if ok {
+		result := []Value{}
+		for _, v := range values {
+			match, ok := filterMatch(other, v)
+			if !ok {
+				return Filter{}, false
+			}
+			if match {
+				result = append(result, v)
+			}
+		}
+		if len(result) == 0 {
+			return Filter{}, false
+		}
+		return newListFilter(a.Field, IN, result), true
+	}
+
+	aExcluded, aok := excludedValues(a)
+	bExcluded, bok := excludedValues(b)
+	if !aok || !bok {
+		return Filter{}, false
+	}
+	return newListFilter(a.Field, NOTIN, append(append([]Value{}, aExcluded...), bExcluded...)), true
+}
+
 func RulesZoom(rs []Rule) ZoomRange {
 	z := InvalidZoom
 	for _, r := range rs {
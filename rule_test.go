@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/flywave/go-cartocss/color"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -45,6 +46,34 @@ func TestLayerRules(t *testing.T) {
 	}
 }
 
+func TestEachLayer(t *testing.T) {
+	d, err := decodeString(`
+#roads { line-width: 1; }
+#water[zoom>=10] { polygon-fill: blue; }
+#water.small { polygon-fill: red; }
+`)
+	assert.NoError(t, err)
+
+	type layer struct {
+		id    string
+		rules int
+	}
+	var got []layer
+	collect := func(id string, rules []Rule) { got = append(got, layer{id, len(rules)}) }
+
+	d.MSS().EachLayer(nil, InvalidZoom, collect)
+	assert.Equal(t, []layer{{"roads", 1}, {"water", 1}}, got)
+
+	got = nil
+	d.MSS().EachLayer(nil, NewZoomRange(LT, 10), collect)
+	assert.Equal(t, []layer{{"roads", 1}, {"water", 0}}, got)
+
+	got = nil
+	mml := &MML{Layers: []Layer{{ID: "water", Classes: []string{"small"}}, {ID: "missing"}}}
+	d.MSS().EachLayer(mml, InvalidZoom, collect)
+	assert.Equal(t, []layer{{"water", 2}, {"missing", 0}}, got)
+}
+
 func TestRuleSame(t *testing.T) {
 	assert.True(t, Rule{Layer: "Foo"}.same(Rule{Layer: "Foo"}))
 	assert.False(t, Rule{Layer: "Foo", Attachment: "Bar"}.same(Rule{Layer: "Foo"}))
@@ -273,7 +302,7 @@ func TestSortedRulesNoInfinitiveLoop(t *testing.T) {
 		{Filters: []Filter{}, Zoom: NewZoomRange(GTE, 14), Properties: NewProperties("width", 1)},
 		{Filters: []Filter{}, Zoom: NewZoomRange(GTE, 15), Properties: NewProperties("width", 1)},
 	}
-	sorted := sortedRules(rules, nil, nil)
+	sorted := sortedRules(rules, nil)
 	assert.Len(t, sorted, 9)
 }
 
@@ -283,7 +312,7 @@ func TestSortedRulesCombinationDuplicates(t *testing.T) {
 		{Filters: []Filter{{"b", EQ, "1"}}, Properties: NewProperties("b", 1)},
 		{Filters: []Filter{{"c", EQ, "1"}}, Properties: NewProperties("c", 1)},
 	}
-	sorted := sortedRules(rules, nil, nil)
+	sorted := sortedRules(rules, nil)
 	assert.Len(t, sorted, 7)
 
 	rules = []Rule{
@@ -292,7 +321,7 @@ func TestSortedRulesCombinationDuplicates(t *testing.T) {
 		{Filters: []Filter{{"c", EQ, "1"}}, Properties: NewProperties("c", 1)},
 		{Filters: []Filter{{"a", EQ, "1"}, {"b", EQ, "1"}}, Properties: NewProperties("b", 2, "a", 2)},
 	}
-	sorted = sortedRules(rules, nil, nil)
+	sorted = sortedRules(rules, nil)
 	assert.Len(t, sorted, 7)
 }
 
@@ -300,26 +329,26 @@ func TestSortedRulesMultipleClasses(t *testing.T) {
 	// .A [a=1] { a: 1 }
 	// .B [b=1] { b: 1 }
 	rules := []Rule{
-		{Class: "A", Filters: []Filter{{"a", EQ, 1}}, Properties: NewProperties("a", 1)},
-		{Class: "B", Filters: []Filter{{"b", EQ, 1}}, Properties: NewProperties("b", 1)},
+		{Classes: []string{"A"}, Filters: []Filter{{"a", EQ, 1}}, Properties: NewProperties("a", 1)},
+		{Classes: []string{"B"}, Filters: []Filter{{"b", EQ, 1}}, Properties: NewProperties("b", 1)},
 	}
 
-	sorted := sortedRules(rules, nil, nil)
+	sorted := sortedRules(rules, nil)
 	assert.Len(t, sorted, 3)
 	assertRuleEq(t, Rule{
-		Class:      "A", // TODO A, B
+		Classes:    []string{"A", "B"},
 		Filters:    []Filter{{"a", EQ, 1}, {"b", EQ, 1}},
 		Properties: NewProperties("a", 1, "b", 1)},
 		sorted[0],
 	)
 	assertRuleEq(t, Rule{
-		Class:      "A",
+		Classes:    []string{"A"},
 		Filters:    []Filter{{"a", EQ, 1}},
 		Properties: NewProperties("a", 1)},
 		sorted[1],
 	)
 	assertRuleEq(t, Rule{
-		Class:      "B",
+		Classes:    []string{"B"},
 		Filters:    []Filter{{"b", EQ, 1}},
 		Properties: NewProperties("b", 1)},
 		sorted[2],
@@ -330,21 +359,21 @@ func TestSortedRulesMultipleClassesInstance(t *testing.T) {
 	// .A::X [a=1] { a: 1}
 	// .B::X [a=1][b=2] { b/b: 1}
 	rules := []Rule{
-		{Class: "A", Attachment: "X", Filters: []Filter{{"a", EQ, 1}}, Properties: NewPropertiesInstance("a", "", 1)},
-		{Class: "B", Attachment: "X", Filters: []Filter{{"a", EQ, 1}, {"b", EQ, 2}}, Properties: NewPropertiesInstance("b", "b", 1)},
+		{Classes: []string{"A"}, Attachment: "X", Filters: []Filter{{"a", EQ, 1}}, Properties: NewPropertiesInstance("a", "", 1)},
+		{Classes: []string{"B"}, Attachment: "X", Filters: []Filter{{"a", EQ, 1}, {"b", EQ, 2}}, Properties: NewPropertiesInstance("b", "b", 1)},
 	}
 
-	sorted := sortedRules(rules, nil, nil)
+	sorted := sortedRules(rules, nil)
 	assert.Len(t, sorted, 2)
 	assertRuleEq(t, Rule{
-		Class:      "B", // TODO A, B
+		Classes:    []string{"A", "B"},
 		Attachment: "X",
 		Filters:    []Filter{{"a", EQ, 1}, {"b", EQ, 2}},
 		Properties: NewPropertiesInstance("a", "", 1, "b", "b", 1)},
 		sorted[0],
 	)
 	assertRuleEq(t, Rule{
-		Class:      "A",
+		Classes:    []string{"A"},
 		Attachment: "X",
 		Filters:    []Filter{{"a", EQ, 1}},
 		Properties: NewPropertiesInstance("a", "", 1)},
@@ -359,7 +388,7 @@ func TestSortedRulesCombination(t *testing.T) {
 		{Filters: []Filter{{"tunnel", EQ, 1}}, Properties: NewProperties("dash-array", 1)},
 		{Filters: []Filter{{"access", EQ, "private"}}, Properties: NewProperties("color", "grey")},
 	}
-	sorted := sortedRules(rules, nil, nil)
+	sorted := sortedRules(rules, nil)
 	assert.Len(t, sorted, 7)
 
 	assertRuleEq(t, Rule{
@@ -417,7 +446,7 @@ func TestSortedRulesRedundantRuleRemoved(t *testing.T) {
 		{Filters: []Filter{{"size", GT, 2000}}, Properties: NewProperties("width", 2)},
 		{Zoom: NewZoomRange(GTE, 17), Filters: []Filter{{"size", GT, 2000}}, Properties: NewProperties("width", 2)},
 	}
-	sorted := sortedRules(rules, nil, nil)
+	sorted := sortedRules(rules, nil)
 	assert.Len(t, sorted, 4)
 
 	assertRuleEq(t, Rule{
@@ -502,3 +531,279 @@ func TestMergeFilters(t *testing.T) {
 		t.Error("error merging filters", result)
 	}
 }
+
+func TestListFilter(t *testing.T) {
+	in := func(values ...Value) Filter { return newListFilter("foo", IN, values) }
+	notIn := func(values ...Value) Filter { return newListFilter("foo", NOTIN, values) }
+
+	// normalized
+	assert.Equal(t, Filter{"foo", IN, []Value{nil, 1.0, "a", "b"}}, in("b", "a", 1.0, nil, "a"))
+	assert.Equal(t, Filter{"foo", EQ, "a"}, in("a", "a"))
+	assert.Equal(t, Filter{"foo", NEQ, "a"}, notIn("a"))
+
+	for _, tt := range []struct {
+		a, b     Filter
+		contains bool
+	}{
+		{in("a", "b"), Filter{"foo", EQ, "a"}, true},
+		{in("a", "b"), Filter{"foo", EQ, "c"}, false},
+		{in("a", "b", "c"), in("a", "b"), true},
+		{in("a", "b"), in("a", "c"), false},
+		{in(1.0, 2.0), Filter{"foo", GT, 1.0}, false},
+		{Filter{"foo", GT, 1.0}, in(2.0, 3.0), true},
+		{Filter{"foo", GT, 1.0}, in(1.0, 3.0), false},
+		{Filter{"foo", NEQ, "c"}, in("a", "b"), true},
+		{notIn("a", "b"), Filter{"foo", EQ, "c"}, true},
+		{notIn("a", "b"), Filter{"foo", EQ, "a"}, false},
+		{notIn("a", "b"), notIn("a", "b", "c"), true},
+		{notIn("a", "b", "c"), notIn("a", "b"), false},
+		{Filter{"foo", NEQ, "a"}, notIn("a", "b"), true},
+		{in("a", "b"), notIn("c", "d"), false},
+	} {
+		assert.Equal(t, tt.contains, filterContains(tt.a, tt.b), "%v contains %v", tt.a, tt.b)
+	}
+
+	for _, tt := range []struct {
+		a, b   Filter
+		merged Filter
+		ok     bool
+	}{
+		{in("a", "b"), Filter{"foo", EQ, "a"}, Filter{"foo", EQ, "a"}, true},
+		{in("a", "b"), Filter{"foo", EQ, "c"}, Filter{}, false},
+		{in("a", "b", "c"), in("b", "c", "d"), in("b", "c"), true},
+		{in("a", "b"), in("c", "d"), Filter{}, false},
+		{in("a", "b", "c"), Filter{"foo", NEQ, "a"}, in("b", "c"), true},
+		{in("a", "b", "c"), notIn("a", "b"), Filter{"foo", EQ, "c"}, true},
+		{in(1.0, 5.0, 10.0), Filter{"foo", GTE, 5.0}, in(5.0, 10.0), true},
+		{notIn("a", "b"), notIn("c", "d"), notIn("a", "b", "c", "d"), true},
+		{notIn("a", "b"), Filter{"foo", NEQ, "c"}, notIn("a", "b", "c"), true},
+		{notIn("a", "b"), Filter{"foo", EQ, "c"}, Filter{"foo", EQ, "c"}, true},
+		{notIn("a", "b"), Filter{"foo", EQ, "a"}, Filter{}, false},
+		{in("a", "b"), Filter{"foo", REGEX, "a"}, Filter{"foo", EQ, "a"}, true},
+	} {
+		merged, ok := mergeFilter(tt.a, tt.b)
+		assert.Equal(t, tt.ok, ok, "merge %v and %v", tt.a, tt.b)
+		assert.Equal(t, tt.merged, merged, "merge %v and %v", tt.a, tt.b)
+	}
+
+	assert.True(t, filterOverlap([]Filter{in("a", "b")}, []Filter{{"foo", EQ, "a"}}))
+	assert.False(t, filterOverlap([]Filter{in("a", "b")}, []Filter{{"foo", EQ, "c"}}))
+	assert.True(t, filterEqual([]Filter{in("a", "b")}, []Filter{in("b", "a")}))
+	assert.False(t, filterEqual([]Filter{in("a", "b")}, []Filter{notIn("b", "a")}))
+}
+
+func TestCompareGenerality(t *testing.T) {
+	in := func(values ...Value) Filter { return newListFilter("foo", IN, values) }
+	notIn := func(values ...Value) Filter { return newListFilter("foo", NOTIN, values) }
+	re := Filter{"foo", REGEX, "a.*"}
+
+	long := make([]Value, 1200)
+	for i := range long {
+		long[i] = float64(i)
+	}
+
+	// ordered from specific to general
+	tiers := [][]Filter{
+		{{"foo", EQ, "a"}},
+		{in("a", "b")},
+		{in("a", "b", "c")},
+		{in(long...)},
+		{re},
+		{re, in(long...)},
+		{notIn(long...)},
+		{notIn("a", "b", "c")},
+		{notIn("a", "b")},
+		{notIn("a", "b"), re},
+	}
+	for i := range tiers {
+		assert.Equal(t, 0, compareGenerality(tiers[i], tiers[i]), "%v", tiers[i])
+		for j := i + 1; j < len(tiers); j++ {
+			assert.True(t, compareGenerality(tiers[i], tiers[j]) < 0, "%v before %v", tiers[i], tiers[j])
+			assert.True(t, compareGenerality(tiers[j], tiers[i]) > 0, "%v after %v", tiers[j], tiers[i])
+		}
+	}
+}
+
+func TestListFilterRules(t *testing.T) {
+	d, err := decodeString(`
+		#roads[type in ('primary', 'secondary', 'tertiary')] {
+			line-width: 1;
+			[type = 'primary'] { line-width: 3; }
+			[type not in('tertiary')] { line-color: red; }
+		}
+	`)
+	assert.NoError(t, err)
+	rules := d.MSS().LayerRules("roads")
+	assertRulesEq(t, rules, []Rule{
+		{Layer: "roads", Filters: []Filter{{"type", EQ, "primary"}}, Zoom: AllZoom, Properties: NewProperties("line-width", float64(3), "line-color", color.MustParse("red"))},
+		{Layer: "roads", Filters: []Filter{{"type", IN, []Value{"primary", "secondary"}}}, Zoom: AllZoom, Properties: NewProperties("line-width", float64(1), "line-color", color.MustParse("red"))},
+		{Layer: "roads", Filters: []Filter{{"type", IN, []Value{"primary", "secondary", "tertiary"}}}, Zoom: AllZoom, Properties: NewProperties("line-width", float64(1))},
+	})
+}
+
+func TestRegexFilter(t *testing.T) {
+	re := func(expr string) Filter { return Filter{"foo", REGEX, expr} }
+	in := func(values ...Value) Filter { return newListFilter("foo", IN, values) }
+	notIn := func(values ...Value) Filter { return newListFilter("foo", NOTIN, values) }
+
+	for _, tt := range []struct {
+		a, b     Filter
+		contains bool
+	}{
+		{re("a.*"), re("a.*"), true},
+		{re("a.*"), Filter{"foo", EQ, "abc"}, true},
+		{re("a.*"), Filter{"foo", EQ, "bac"}, false}, // match is anchored
+		{re("a"), Filter{"foo", EQ, "abc"}, false},   // match is anchored
+		{re("a.*"), Filter{"foo", EQ, 1.0}, false},
+		{re("a.*"), in("ab", "ac"), true},
+		{re("a.*"), in("ab", "bc"), false},
+		{re(".*"), re("a.*"), false},                   // unknown, can't compare regular expressions
+		{re("a(?=b)"), Filter{"foo", EQ, "ab"}, false}, // unsupported by Go
+		{Filter{"foo", EQ, "abc"}, re("a.*"), false},
+		{Filter{"foo", NEQ, "x"}, re("a.*"), false},
+		{notIn("x"), re("a.*"), false},
+	} {
+		assert.Equal(t, tt.contains, filterContains(tt.a, tt.b), "%v contains %v", tt.a, tt.b)
+	}
+
+	for _, tt := range []struct {
+		a, b   Filter
+		merged Filter
+		ok     bool
+	}{
+		{re("a.*"), Filter{"foo", EQ, "abc"}, Filter{"foo", EQ, "abc"}, true},
+		{Filter{"foo", EQ, "abc"}, re("a.*"), Filter{"foo", EQ, "abc"}, true},
+		{re("a.*"), Filter{"foo", EQ, "bcd"}, Filter{}, false},
+		{re("a.*"), in("ab", "ac", "bc"), in("ab", "ac"), true},
+		{re("a.*"), re("b.*"), Filter{}, false},
+		{re("a.*"), Filter{"foo", NEQ, "abc"}, Filter{}, false},
+	} {
+		merged, ok := mergeFilter(tt.a, tt.b)
+		assert.Equal(t, tt.ok, ok, "merge %v and %v", tt.a, tt.b)
+		assert.Equal(t, tt.merged, merged, "merge %v and %v", tt.a, tt.b)
+	}
+
+	assert.True(t, filterOverlap([]Filter{re("a.*")}, []Filter{{"foo", EQ, "abc"}}))
+	assert.False(t, filterOverlap([]Filter{re("a.*")}, []Filter{{"foo", EQ, "xyz"}}))
+	// unknown intersections overlap
+	assert.True(t, filterOverlap([]Filter{re("a.*")}, []Filter{re("b.*")}))
+	assert.True(t, filterOverlap([]Filter{re("a.*")}, []Filter{{"foo", NEQ, "abc"}}))
+	assert.True(t, filterOverlap([]Filter{re("a(?=b)")}, []Filter{{"foo", EQ, "xyz"}}))
+	assert.False(t, filterOverlap([]Filter{re("a.*"), re("b.*")}, []Filter{{"foo", EQ, "xyz"}}))
+
+	// \d, \w, \s and \b match Unicode characters in Mapnik
+	match, ok := matchRegexp(`\d+`, "123")
+	assert.False(t, ok)
+	assert.False(t, match)
+	_, ok = matchRegexp(`\\d+`, `\ddd`)
+	assert.True(t, ok)
+	_, ok = matchRegexp(`[0-9]+\.`, "1.")
+	assert.True(t, ok)
+}
+
+func TestRegexFilterRules(t *testing.T) {
+	d, err := decodeString(`
+		#places {
+			[name =~ 'A.*'] { text-size: 10; }
+			[name = 'Aachen'] { text-fill: red; }
+			[name = 'Berlin'] { text-fill: blue; }
+		}
+	`)
+	assert.NoError(t, err)
+	rules := d.MSS().LayerRules("places")
+	assertRulesEq(t, rules, []Rule{
+		{Layer: "places", Filters: []Filter{{"name", EQ, "Berlin"}}, Zoom: AllZoom, Properties: NewProperties("text-fill", color.MustParse("blue"))},
+		{Layer: "places", Filters: []Filter{{"name", EQ, "Aachen"}}, Zoom: AllZoom, Properties: NewProperties("text-size", float64(10), "text-fill", color.MustParse("red"))},
+		{Layer: "places", Filters: []Filter{{"name", REGEX, "A.*"}}, Zoom: AllZoom, Properties: NewProperties("text-size", float64(10))},
+	})
+}
+
+func TestRegexFilterUnknownRules(t *testing.T) {
+	d, err := decodeString(`
+		#places {
+			[name =~ 'A.*'] { text-size: 10; }
+			[name =~ '.*n'] { text-fill: red; }
+		}
+	`)
+	assert.NoError(t, err)
+	rules := d.MSS().LayerRules("places")
+	assertRulesEq(t, rules, []Rule{
+		{Layer: "places", Filters: []Filter{{"name", REGEX, ".*n"}, {"name", REGEX, "A.*"}}, Zoom: AllZoom, Properties: NewProperties("text-size", float64(10), "text-fill", color.MustParse("red"))},
+		{Layer: "places", Filters: []Filter{{"name", REGEX, ".*n"}}, Zoom: AllZoom, Properties: NewProperties("text-fill", color.MustParse("red"))},
+		{Layer: "places", Filters: []Filter{{"name", REGEX, "A.*"}}, Zoom: AllZoom, Properties: NewProperties("text-size", float64(10))},
+	})
+
+	// nested selectors keep both filters
+	d, err = decodeString(`#places[name =~ 'A.*'] { [name != 'Aachen'] { text-size: 10; } }`)
+	assert.NoError(t, err)
+	assertRulesEq(t, d.MSS().LayerRules("places"), []Rule{
+		{Layer: "places", Filters: []Filter{{"name", NEQ, "Aachen"}, {"name", REGEX, "A.*"}}, Zoom: AllZoom, Properties: NewProperties("text-size", float64(10))},
+	})
+}
+
+func TestDeadRules(t *testing.T) {
+	d := NewDecoder()
+	err := d.ParseString(`
+#roads[a=1][a=2] { line-width: 1; }
+#roads[a=1] {
+	line-color: red;
+	[a=2] { line-width: 2; }
+	[b=3] { line-width: 3; }
+}
+#roads[zoom>10][zoom<5] { line-width: 4; }
+#roads[zoom>=10] {
+	line-cap: round;
+	[zoom<8] { line-width: 5; }
+}
+#roads[b='x'] { line-join: round; }
+#roads[b='x'] { line-join: miter; }
+#roads[b>1][b<=1] { line-width: 6; }
+#water[a=1][a=2] { line-width: 7; }
+#roads[c>1][c<5] { line-width: 8; }
+#roads[zoom>=10][zoom=12] { line-width: 9; }
+#roads[zoom!=5][zoom=12] { line-miterlimit: 2; }
+`)
+	if !assert.NoError(t, err) || !assert.NoError(t, d.Evaluate()) {
+		return
+	}
+
+	type result struct {
+		reason  DeadRuleReason
+		line    int
+		related []int
+	}
+	var got []result
+	for _, r := range d.MSS().DeadRules("roads") {
+		res := result{reason: r.Reason, line: r.Range.Start.Line}
+		for _, rel := range r.Related {
+			res.related = append(res.related, rel.Start.Line)
+		}
+		got = append(got, res)
+	}
+	assert.Equal(t, []result{
+		{ContradictingFilters, 2, nil},
+		{ContradictingFilters, 5, []int{3}},
+		{EmptyZoom, 8, nil},
+		{EmptyZoom, 11, []int{9}},
+		{ShadowedRule, 13, []int{14}},
+		{ContradictingFilters, 15, nil},
+	}, got)
+
+	r := d.MSS().DeadRules("roads")[0]
+	assert.Equal(t, Range{Start: Position{Line: 2, Column: 1}, End: Position{Line: 2, Column: 17}}, r.Range)
+	assert.Equal(t, "contradicting filters", r.Reason.String())
+
+	// dead rules are skipped
+	widths := map[float64]bool{}
+	miterlimit := false
+	for _, r := range d.MSS().LayerRules("roads") {
+		if w, ok := r.Properties.GetFloat("line-width"); ok {
+			widths[w] = true
+		}
+		if _, ok := r.Properties.GetFloat("line-miterlimit"); ok {
+			miterlimit = true
+		}
+	}
+	assert.True(t, miterlimit)
+	assert.Equal(t, map[float64]bool{3: true, 8: true, 9: true}, widths)
+}
@@ -34,6 +34,15 @@ func (t *token) String() string {
 		t.t, t.line, t.column, t.value)
 }
 
+// end returns the line and column of the first character after the token.
+func (t *token) end() (int, int) {
+	lines := strings.Count(t.value, "\n")
+	if lines == 0 {
+		return t.line, t.column + utf8.RuneCountInString(t.value)
+	}
+	return t.line + lines, utf8.RuneCountInString(t.value[strings.LastIndex(t.value, "\n")+1:]) + 1
+}
+
 // The complete list of tokens in MSS.
 const (
 	// Scanner flags
@@ -139,7 +148,7 @@ var macros = map[string]string{
 	"nmchar":     `[a-zA-Z0-9_-]|{nonascii}|{escape}`,
 	"num":        `-?[0-9]*\.?[0-9]+`,
 	"string":     `"(?:{stringchar}|')*"|'(?:{stringchar}|")*'`,
-	"stringchar": `{urlchar}|[ ]|\\{nl}`,
+	"stringchar": `\\{nl}|{escape}|{urlchar}|[ ]`, // escape before urlchar, so that \' does not end the string
 	"urlchar":    "[\u0009\u0021\u0023-\u0026\u0028-\u007E]|{nonascii}|{escape}",
 	"nl":         `[\n\r\f]|\r\n`,
 	"w":          `{wc}*`,
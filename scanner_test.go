@@ -53,6 +53,14 @@ func TestScanner(t *testing.T) {
 				{tokenRBrace, "}"},
 			},
 		},
+		{
+			text: `'it\'s' "\\"`,
+			tokens: []tokVal{
+				{tokenString, `'it\'s'`},
+				{tokenS, " "},
+				{tokenString, `"\\"`},
+			},
+		},
 		{
 			text: `lighten(a, 30%)`,
 			tokens: []tokVal{
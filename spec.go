@@ -1,8 +1,15 @@
 package cartocss
 
-import "github.com/flywave/go-cartocss/color"
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
-var attributeTypes map[string]isValid
+	"github.com/flywave/go-cartocss/color"
+)
 
 type isValid func(interface{}) bool
 
@@ -105,312 +112,229 @@ func isStops(val interface{}) bool {
 	return true
 }
 
-func isCompOp(val interface{}) bool {
-	return isKeyword(
-		"clear",
-		"src",
-		"dst",
-		"src-over",
-		"dst-over",
-		"src-in",
-		"dst-in",
-		"src-out",
-		"dst-out",
-		"src-atop",
-		"dst-atop",
-		"xor",
-		"plus",
-		"minus",
-		"multiply",
-		"divide",
-		"screen",
-		"overlay",
-		"darken",
-		"lighten",
-		"color-dodge",
-		"color-burn",
-		"hard-light",
-		"soft-light",
-		"difference",
-		"exclusion",
-		"contrast",
-		"invert",
-		"invert-rgb",
-		"grain-merge",
-		"grain-extract",
-		"hue",
-		"saturation",
-		"color",
-		"value",
-	)(val)
+//go:embed reference.json
+var referenceJSON []byte
+
+// reference is the specification of all properties, in the style of the
+// mapnik-reference JSON.
+type reference struct {
+	// Version is the latest Mapnik version covered by the reference.
+	Version string `json:"version"`
+	// KeywordSets are keyword lists shared by multiple properties, eg. all
+	// comp-op values.
+	KeywordSets map[string][]string      `json:"keyword-sets"`
+	Properties  map[string]*propertySpec `json:"properties"`
 }
 
-func isScaling(val interface{}) bool {
-	return isKeyword(
-		"near",
-		"fast",
-		"bilinear",
-		"bicubic",
-		"spline16",
-		"spline36",
-		"hanning",
-		"hamming",
-		"hermite",
-		"kaiser",
-		"quadric",
-		"catrom",
-		"gaussian",
-		"bessel",
-		"mitchell",
-		"sinc",
-		"lanczos",
-		"blackman",
-	)(val)
+// Spec is the specification of a property, see PropertySpec.
+type Spec struct {
+	Name string `json:"-"`
+	// Type is one of color, float, numbers, boolean, string, strings, uri,
+	// stops or keyword.
+	Type string `json:"type"`
+	// Keywords are the allowed values for keyword types, or additional
+	// values for other types, eg. auto for text-min-path-length.
+	Keywords []string `json:"keywords"`
+	// Field is true if the value can also be a field, eg. [rotation].
+	Field bool `json:"field"`
+	// Default is the value Mapnik uses if the property is not set, or nil
+	// if there is no default. Colors are color.Color values.
+	Default Value `json:"default-value"`
+	// Unit of numeric values, eg. pixels or degrees.
+	Unit string `json:"unit"`
+	Doc  string `json:"doc"`
+	// Since is the first Mapnik version that supports the property.
+	Since string `json:"since"`
 }
 
-func isSimplifyAlgorithm(val interface{}) bool {
-	return isKeyword(
-		"radial-distance",
-		"zhao-saalfeld",
-		"visvalingam-whyatt",
-	)(val)
+// IsDefault returns whether v is the default value of the property.
+func (s Spec) IsDefault(v Value) bool {
+	switch d := s.Default.(type) {
+	case nil:
+		return false
+	case color.Color:
+		c, ok := v.(color.Color)
+		return ok && c.String() == d.String()
+	default:
+		return v == s.Default
+	}
 }
 
-func isRasterizer(val interface{}) bool {
-	return isKeyword(
-		"full",
-		"fast",
-	)(val)
+// PropertySpec returns the specification of the property, eg. the default
+// value and the description.
+func PropertySpec(property string) (Spec, bool) {
+	p, ok := propertySpecs[property]
+	if !ok {
+		return Spec{}, false
+	}
+	s := p.Spec
+	s.Keywords = append([]string(nil), p.Keywords...)
+	return s, true
 }
 
-func isVerticalAlignment(val interface{}) bool {
-	return isKeyword(
-		"top",
-		"middle",
-		"bottom",
-		"auto",
-	)(val)
+// propertySpec is the specification of a property in the reference.
+type propertySpec struct {
+	Spec
+	KeywordSet string `json:"keyword-set"`
+
+	valid isValid
+	since SpecVersion
 }
 
-func isJustifyAlignment(val interface{}) bool {
-	return isKeyword(
-		"left",
-		"center",
-		"right",
-		"auto",
-	)(val)
+var typeValidators = map[string]isValid{
+	"color":   isColor,
+	"float":   isNumber,
+	"numbers": isNumbers,
+	"boolean": isBool,
+	"string":  isString,
+	"uri":     isString,
+	"strings": isStringOrStrings,
+	"stops":   isStops,
 }
 
+var propertySpecs map[string]*propertySpec
+
 func init() {
-	attributeTypes = map[string]isValid{
-		"background-color": isColor,
-
-		"building-fill":         isColor,
-		"building-fill-opacity": isNumber,
-		"building-height":       isNumber,
-
-		"dot-fill":    isColor,
-		"dot-opacity": isNumber,
-		"dot-width":   isNumber,
-		"dot-height":  isNumber,
-		"dot-comp-op": isCompOp,
-
-		"line-cap":                isKeyword("round", "butt", "square"),
-		"line-clip":               isBool,
-		"line-color":              isColor,
-		"line-dasharray":          isNumbers,
-		"line-dash-offset":        isNumbers,
-		"line-gamma":              isNumber,
-		"line-gamma-method":       isKeyword("power", "linear", "none", "threshold", "multiply"),
-		"line-join":               isKeyword("miter", "miter-revert", "round", "bevel"),
-		"line-miterlimit":         isNumber,
-		"line-offset":             isNumber,
-		"line-opacity":            isNumber,
-		"line-rasterizer":         isRasterizer,
-		"line-simplify":           isNumber,
-		"line-simplify-algorithm": isSimplifyAlgorithm,
-		"line-smooth":             isNumber,
-		"line-width":              isNumber,
-		"line-comp-op":            isCompOp,
-		"line-geometry-transform": isString,
-
-		"line-pattern-file":               isString,
-		"line-pattern-clip":               isBool,
-		"line-pattern-opacity":            isNumber,
-		"line-pattern-simplify":           isNumber,
-		"line-pattern-simplify-algorithm": isSimplifyAlgorithm,
-		"line-pattern-smooth":             isNumber,
-		"line-pattern-offset":             isNumber,
-		"line-pattern-geometry-transform": isString,
-		"line-pattern-comp-op":            isCompOp,
-
-		"marker-allow-overlap":      isBool,
-		"marker-file":               isString,
-		"marker-fill":               isColor,
-		"marker-fill-opacity":       isNumber,
-		"marker-height":             isNumber,
-		"marker-line-color":         isColor,
-		"marker-line-width":         isNumber,
-		"marker-line-opacity":       isNumber,
-		"marker-opacity":            isNumber,
-		"marker-placement":          isKeyword("point", "interior", "line", "vertex-first", "vertex-last"),
-		"marker-spacing":            isNumber,
-		"marker-transform":          isString,
-		"marker-type":               isKeyword("arrow", "ellipse"),
-		"marker-width":              isNumber,
-		"marker-multi-policy":       isKeyword("each", "whole", "largest"),
-		"marker-avoid-edges":        isBool,
-		"marker-ignore-placement":   isBool,
-		"marker-max-error":          isNumber,
-		"marker-clip":               isBool,
-		"marker-simplify":           isNumber,
-		"marker-simplify-algorithm": isSimplifyAlgorithm,
-		"marker-smooth":             isNumber,
-		"marker-geometry-transform": isString,
-		"marker-offset":             isNumber,
-		"marker-comp-op":            isCompOp,
-		"marker-direction":          isKeyword("auto", "auto-down", "left", "right", "left-only", "right-only", "up", "down"),
-
-		"point-file":             isString,
-		"point-allow-overlap":    isBool,
-		"point-opacity":          isNumber,
-		"point-transform":        isString,
-		"point-ignore-placement": isBool,
-		"point-placement":        isKeyword("centroid", "interior"),
-		"point-comp-op":          isCompOp,
-
-		"polygon-fill":               isColor,
-		"polygon-gamma":              isNumber,
-		"polygon-gamma-method":       isKeyword("power", "linear", "none", "threshold", "multiply"),
-		"polygon-opacity":            isNumber,
-		"polygon-clip":               isBool,
-		"polygon-simplify":           isNumber,
-		"polygon-simplify-algorithm": isSimplifyAlgorithm,
-		"polygon-smooth":             isNumber,
-		"polygon-geometry-transform": isString,
-		"polygon-comp-op":            isCompOp,
-
-		"polygon-pattern-alignment":          isKeyword("global", "local"),
-		"polygon-pattern-file":               isString,
-		"polygon-pattern-gamma":              isNumber,
-		"polygon-pattern-opacity":            isNumber,
-		"polygon-pattern-clip":               isBool,
-		"polygon-pattern-simplify":           isNumber,
-		"polygon-pattern-simplify-algorithm": isSimplifyAlgorithm,
-		"polygon-pattern-smooth":             isNumber,
-		"polygon-pattern-geometry-transform": isString,
-		"polygon-pattern-comp-op":            isCompOp,
-
-		"shield-allow-overlap":            isBool,
-		"shield-avoid-edges":              isBool,
-		"shield-character-spacing":        isNumber,
-		"shield-clip":                     isBool,
-		"shield-dx":                       isNumber,
-		"shield-dy":                       isNumber,
-		"shield-face-name":                isStringOrStrings,
-		"shield-file":                     isString,
-		"shield-fill":                     isColor,
-		"shield-halo-fill":                isColor,
-		"shield-halo-radius":              isNumber,
-		"shield-halo-rasterizer":          isRasterizer,
-		"shield-halo-transform":           isString,
-		"shield-halo-comp-op":             isCompOp,
-		"shield-halo-opacity":             isNumber,
-		"shield-line-spacing":             isNumber,
-		"shield-min-distance":             isNumber,
-		"shield-min-padding":              isNumber,
-		"shield-name":                     isString,
-		"shield-opacity":                  isNumber,
-		"shield-placement":                isKeyword("line", "point", "vertex", "interior"),
-		"shield-placement-type":           isKeyword("dummy", "simple", "list"),
-		"shield-placements":               isString,
-		"shield-transform":                isString,
-		"shield-simplify":                 isNumber,
-		"shield-simplify-algorithm":       isSimplifyAlgorithm,
-		"shield-smooth":                   isNumber,
-		"shield-comp-op":                  isCompOp,
-		"shield-size":                     isNumber,
-		"shield-spacing":                  isNumber,
-		"shield-text-dx":                  isNumber,
-		"shield-text-dy":                  isNumber,
-		"shield-text-opacity":             isNumber,
-		"shield-text-transform":           isKeyword("none", "uppercase", "lowercase", "capitalize", "reverse"),
-		"shield-wrap-before":              isBool,
-		"shield-wrap-character":           isString,
-		"shield-wrap-width":               isNumber,
-		"shield-unlock-image":             isBool,
-		"shield-margin":                   isNumber,
-		"shield-repeat-distance":          isNumber,
-		"shield-label-position-tolerance": isNumber,
-		"shield-horizontal-alignment":     isKeyword("left", "middle", "right", "auto"),
-		"shield-vertical-alignment":       isVerticalAlignment,
-		"shield-justify-alignment":        isJustifyAlignment,
-
-		"text-allow-overlap":            isBool,
-		"text-avoid-edges":              isBool,
-		"text-character-spacing":        isNumber,
-		"text-clip":                     isBool,
-		"text-dx":                       isNumber,
-		"text-dy":                       isNumber,
-		"text-face-name":                isStringOrStrings,
-		"text-font-feature-settings":    isString,
-		"text-fill":                     isColor,
-		"text-halo-fill":                isColor,
-		"text-halo-radius":              isNumber,
-		"text-halo-opacity":             isNumber,
-		"text-halo-rasterizer":          isRasterizer,
-		"text-halo-transform":           isString,
-		"text-halo-comp-op":             isCompOp,
-		"text-line-spacing":             isNumber,
-		"text-min-distance":             isNumber,
-		"text-min-padding":              isNumber,
-		"text-name":                     isString,
-		"text-opacity":                  isNumber,
-		"text-orientation":              isFieldOr(isNumber),
-		"text-placement":                isKeyword("line", "point", "vertex", "interior"),
-		"text-placement-type":           isKeyword("dummy", "simple", "list"),
-		"text-placements":               isString,
-		"text-size":                     isNumber,
-		"text-spacing":                  isNumber,
-		"text-transform":                isKeyword("none", "uppercase", "lowercase", "capitalize", "reverse"),
-		"text-wrap-before":              isBool,
-		"text-wrap-character":           isString,
-		"text-wrap-width":               isNumber,
-		"text-repeat-wrap-characater":   isBool,
-		"text-ratio":                    isNumber,
-		"text-label-position-tolerance": isNumber,
-		"text-max-char-angle-delta":     isNumber,
-		"text-vertical-alignment":       isVerticalAlignment,
-		"text-horizontal-alignment":     isKeyword("left", "middle", "right", "auto", "adjust"),
-		"text-justify-alignment":        isJustifyAlignment,
-		"text-margin":                   isNumber,
-		"text-repeat-distance":          isNumber,
-		"text-min-path-length":          isKeywordOr(isNumber, "auto"),
-		"text-rotate-displacement":      isBool,
-		"text-upgright":                 isKeyword("auto", "auto-down", "left", "right", "left-only", "right-only"),
-		"text-simplify":                 isNumber,
-		"text-simplify-algorithm":       isSimplifyAlgorithm,
-		"text-smooth":                   isNumber,
-		"text-comp-op":                  isCompOp,
-		"text-largest-bbox-only":        isBool,
-
-		"raster-opacity":                 isNumber,
-		"raster-scaling":                 isScaling,
-		"raster-colorizer-default-mode":  isKeyword("discrete", "linear", "exact"),
-		"raster-colorizer-default-color": isColor,
-		"raster-colorizer-stops":         isStops,
-		"raster-comp-op":                 isCompOp,
-		"raster-filter-factor":           isNumber,
-		"raster-mesh-size":               isNumber,
-		"raster-colorizer-epsilon":       isNumber,
+	specs, err := loadReference(referenceJSON)
+	if err != nil {
+		panic("invalid reference.json: " + err.Error())
+	}
+	propertySpecs = specs
+}
+
+// loadReference parses the reference and prepares the validation of all
+// properties.
+func loadReference(data []byte) (map[string]*propertySpec, error) {
+	var ref reference
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return nil, err
+	}
+	for name, p := range ref.Properties {
+		p.Name = name
+		if p.KeywordSet != "" {
+			set, ok := ref.KeywordSets[p.KeywordSet]
+			if !ok {
+				return nil, fmt.Errorf("unknown keyword-set %s for %s", p.KeywordSet, name)
+			}
+			p.Keywords = append(p.Keywords, set...)
+		}
+		if p.Type == "keyword" {
+			if len(p.Keywords) == 0 {
+				return nil, fmt.Errorf("missing keywords for %s", name)
+			}
+			p.valid = isKeyword(p.Keywords...)
+		} else {
+			valid, ok := typeValidators[p.Type]
+			if !ok {
+				return nil, fmt.Errorf("unknown type %s for %s", p.Type, name)
+			}
+			if len(p.Keywords) > 0 {
+				valid = isKeywordOr(valid, p.Keywords...)
+			}
+			p.valid = valid
+		}
+		if p.Field {
+			p.valid = isFieldOr(p.valid)
+		}
+		if s, ok := p.Default.(string); ok && p.Type == "color" {
+			c, err := color.Parse(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid default-value for %s: %w", name, err)
+			}
+			p.Default = c
+		}
+		since, err := ParseSpecVersion(p.Since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since for %s: %w", name, err)
+		}
+		p.since = since
+	}
+	return ref.Properties, nil
+}
+
+// SpecVersion is a Mapnik version, eg. 3.0.22 or 3.1 (3.1.0).
+type SpecVersion [3]int
+
+// ParseSpecVersion parses a Mapnik version with up to three numbers, eg.
+// 3.0.22 or 3.1.
+func ParseSpecVersion(version string) (SpecVersion, error) {
+	var v SpecVersion
+	parts := strings.Split(version, ".")
+	if len(parts) > len(v) {
+		return v, fmt.Errorf("invalid version %q", version)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", version)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func (v SpecVersion) less(other SpecVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
 	}
+	return false
+}
+
+func (v SpecVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// deprecatedProperties contains misspelled property names that were
+// supported by earlier versions, with their correct name.
+var deprecatedProperties = map[string]string{
+	"text-upgright":               "text-upright",
+	"text-repeat-wrap-characater": "text-repeat-wrap-character",
+}
+
+// PropertyAlias returns the name of the property for a deprecated property
+// name, eg. text-upright for text-upgright. Deprecated names are replaced
+// by the decoder.
+func PropertyAlias(property string) (string, bool) {
+	name, ok := deprecatedProperties[property]
+	return name, ok
+}
+
+// PropertyNames returns the sorted names of all supported properties.
+func PropertyNames() []string {
+	names := make([]string, 0, len(propertySpecs))
+	for name := range propertySpecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // validProperty returns whether the property and the value is valid.
 func validProperty(property string, value interface{}) (bool, bool) {
-	checkFunc, ok := attributeTypes[property]
+	spec, ok := propertySpecs[property]
 	if !ok {
 		return false, false
 	}
+	checkFunc := spec.valid
+	if i, ok := value.(*Interpolation); ok {
+		for _, s := range i.Stops {
+			if !checkFunc(s.Value) {
+				return true, false
+			}
+		}
+		return true, true
+	}
 	return true, checkFunc(value)
 }
+
+// supportedProperty returns whether the property is supported by the target
+// Mapnik version. It returns the version that added the property.
+func supportedProperty(property string, target SpecVersion) (bool, SpecVersion) {
+	spec, ok := propertySpecs[property]
+	if !ok {
+		return true, SpecVersion{}
+	}
+	return !target.less(spec.since), spec.since
+}
@@ -0,0 +1,84 @@
+package cartocss
+
+import "fmt"
+
+// Position is a location in a .mss file. Line and Column start at 1.
+type Position struct {
+	Filename string
+	Line     int
+	Column   int
+}
+
+// Before returns whether p is before other. Positions are ordered by
+// filename, line and column.
+func (p Position) Before(other Position) bool {
+	return p.Filename < other.Filename || p.Filename == other.Filename &&
+		(p.Line < other.Line || p.Line == other.Line && p.Column < other.Column)
+}
+
+func (p Position) String() string {
+	file := p.Filename
+	if file == "" {
+		file = "?"
+	}
+	return fmt.Sprintf("%s line: %d col: %d", file, p.Line, p.Column)
+}
+
+// Range is a section of a .mss file. End points to the first character after
+// the range.
+type Range struct {
+	Start Position
+	End   Position
+}
+
+// Severity of a Warning.
+type Severity int
+
+const (
+	SeverityError Severity = iota + 1
+	SeverityWarning
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	default:
+		return "unknown"
+	}
+}
+
+// WarningCode identifies the kind of a Warning. The values are stable and
+// can be used to filter warnings.
+type WarningCode string
+
+const (
+	InvalidProperty     WarningCode = "invalid-property"
+	InvalidValue        WarningCode = "invalid-value"
+	UnusedVariable      WarningCode = "unused-variable"
+	UnusedOverride      WarningCode = "unused-override"
+	UnsupportedProperty WarningCode = "unsupported-property"
+	DeprecatedProperty  WarningCode = "deprecated-property"
+)
+
+// Warning is a non-fatal problem found while decoding a style.
+type Warning struct {
+	Severity Severity
+	Code     WarningCode
+	Message  string
+	// Range is empty for warnings that do not refer to a stylesheet, eg.
+	// UnusedOverride.
+	Range Range
+}
+
+func (w Warning) String() string {
+	if w.Range == (Range{}) {
+		return w.Message
+	}
+	return fmt.Sprintf("%s in %s", w.Message, w.Range.Start)
+}
@@ -30,6 +30,8 @@ const (
 	NEQ
 	REGEX
 	MODULO
+	IN
+	NOTIN
 )
 
 func (c CompOp) String() string {
@@ -50,6 +52,10 @@ func (c CompOp) String() string {
 		return "=~"
 	case MODULO:
 		return "%"
+	case IN:
+		return "in"
+	case NOTIN:
+		return "not in"
 	default:
 		return "?"
 	}
@@ -73,6 +79,10 @@ func parseCompOp(comp string) (CompOp, error) {
 		return NEQ, nil
 	case "%":
 		return MODULO, nil
+	case "in":
+		return IN, nil
+	case "not in":
+		return NOTIN, nil
 	default:
 		return UnknownOp, fmt.Errorf("unknown comparsion '%s'", comp)
 	}
@@ -91,7 +101,7 @@ func (z ZoomRange) add(comp CompOp, level int8) ZoomRange {
 	l := uint(level)
 	switch comp {
 	case EQ:
-		return z & 1 << l
+		return z & (1 << l)
 	case NEQ:
 		return z & ^(1 << l)
 	case LT:
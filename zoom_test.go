@@ -46,6 +46,10 @@ func TestZoomRangeadd(t *testing.T) {
 	checkZoomIncludes(t, AllZoom.add(LT, 4), []int{0, 1, 2, 3})
 	checkZoomIncludes(t, AllZoom.add(LTE, 4), []int{0, 1, 2, 3, 4})
 	checkZoomExcludes(t, AllZoom.add(NEQ, 4).add(NEQ, 8), []int{4, 8})
+	checkZoomIncludes(t, AllZoom.add(GTE, 10).add(EQ, 12), []int{12})
+	checkZoomIncludes(t, AllZoom.add(NEQ, 5).add(EQ, 12), []int{12})
+	assert.Equal(t, AllZoom.add(EQ, 12), AllZoom.add(GTE, 10).add(EQ, 12))
+	assert.Equal(t, InvalidZoom, AllZoom.add(GTE, 10).add(EQ, 8))
 
 	assert.Equal(t, InvalidZoom.add(EQ, 15), InvalidZoom)
 	assert.Equal(t, InvalidZoom.add(GTE, 15), InvalidZoom)